@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/db"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// client calls the API in-process, signed in as a user, so that commands go
+// through the same authorization, guardrails, limits and audit log as the
+// UI does.
+type client struct {
+	srv       *server
+	ctx       context.Context
+	token     string
+	tokenHash string
+}
+
+// signIn starts a short-lived session for the user with email. Close ends it.
+func signIn(ctx context.Context, srv *server, email string) (*client, error) {
+	if email == "" {
+		return nil, usagef("--user is required")
+	}
+	user, err := srv.db.GetUserByEmail(email)
+	if errors.Is(err, db.ErrUserNotFound) {
+		return nil, fmt.Errorf("no user with email %s; create one with \"composer users create\"", email)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &models.AuthSession{TokenHash: hash, UserID: user.ID, ExpiresAt: now.Add(time.Hour), CreatedAt: now}
+	if err := srv.db.InsertAuthSession(session); err != nil {
+		return nil, err
+	}
+	return &client{srv: srv, ctx: ctx, token: token, tokenHash: hash}, nil
+}
+
+func (cl *client) Close() error {
+	return cl.srv.db.DeleteAuthSession(cl.tokenHash)
+}
+
+// do sends a request and returns the response, or the API's error message
+// if it failed.
+func (cl *client) do(method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req := httptest.NewRequest(method, path, body).WithContext(cl.ctx)
+	req.RemoteAddr = "127.0.0.1:0"
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+cl.token)
+	req.Header.Set("User-Agent", "composer-cli")
+	if contentType != "" {
+		req.Header.Set(echo.HeaderContentType, contentType)
+	}
+
+	rec := httptest.NewRecorder()
+	cl.srv.ServeHTTP(rec, req)
+	res := rec.Result()
+	if res.StatusCode >= 400 {
+		defer res.Body.Close()
+		return nil, responseError(res)
+	}
+	return res, nil
+}
+
+// doJSON sends in, if not nil, as JSON and decodes the response into out.
+func (cl *client) doJSON(method, path string, in, out any) error {
+	var body io.Reader
+	var contentType string
+	if in != nil {
+		raw, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body, contentType = bytes.NewReader(raw), echo.MIMEApplicationJSON
+	}
+	res, err := cl.do(method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func responseError(res *http.Response) error {
+	raw, _ := io.ReadAll(res.Body)
+	var body struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(raw, &body) == nil && body.Message != "" {
+		return fmt.Errorf("%s (%d)", body.Message, res.StatusCode)
+	}
+	return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(raw)))
+}
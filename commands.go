@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/config"
+	"composer/internal/db"
+	"composer/internal/models"
+	"composer/internal/routes"
+	"composer/internal/webhooks"
+
+	"github.com/labstack/echo/v4"
+)
+
+// userFlag is the account commands act as. Their requests are authorized
+// and audited as that user's.
+func userFlag(fs *flag.FlagSet) *string {
+	return fs.String("user", os.Getenv("COMPOSER_USER"), "email of the user to act as (env COMPOSER_USER)")
+}
+
+// migrateCommand creates missing tables and columns, which db.New does on
+// every start, so that upgrades can be run before the new server starts.
+func migrateCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("composer migrate", flag.ExitOnError)
+	cfg, _, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.New(cfg.Database.Type, cfg.Database.DSN)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s database is up to date\n", cfg.Database.Type)
+	return conn.Close()
+}
+
+// usersCommand runs "composer users create" and "composer users
+// claim-sessions".
+func usersCommand(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "create":
+			return createUserCommand(args[1:])
+		case "claim-sessions":
+			return claimSessionsCommand(args[1:])
+		}
+	}
+	return usagef("usage: composer users create --email <email> [--name <name>] [--password-stdin]\n" +
+		"       composer users claim-sessions --email <email>")
+}
+
+// createUserCommand adds a local account and prints its ID. Without a
+// password, the user can only sign in with OIDC.
+func createUserCommand(args []string) error {
+	fs := flag.NewFlagSet("composer users create", flag.ExitOnError)
+	email := fs.String("email", "", "email to sign in with")
+	name := fs.String("name", "", "display name")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from the first line of standard input")
+	cfg, _, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	*email = strings.TrimSpace(*email)
+	if !strings.Contains(*email, "@") {
+		return usagef("a valid --email is required")
+	}
+
+	user := &models.User{Email: *email, Name: *name, CreatedAt: time.Now()}
+	if *passwordStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if user.PasswordHash, err = auth.HashPassword(strings.TrimRight(line, "\r\n")); err != nil {
+			return err
+		}
+	}
+
+	conn, err := db.New(cfg.Database.Type, cfg.Database.DSN)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.GetUserByEmail(user.Email); err == nil {
+		return fmt.Errorf("an account with email %s already exists", user.Email)
+	}
+	if err := conn.InsertUser(user); err != nil {
+		return err
+	}
+	fmt.Println(user.ID)
+	return nil
+}
+
+// claimSessionsCommand gives an account the sessions created before
+// accounts existed, which have no owner, and prints how many it got.
+func claimSessionsCommand(args []string) error {
+	fs := flag.NewFlagSet("composer users claim-sessions", flag.ExitOnError)
+	email := fs.String("email", "", "email of the account to give the sessions to")
+	cfg, _, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(*email) == "" {
+		return usagef("--email is required")
+	}
+
+	conn, err := db.New(cfg.Database.Type, cfg.Database.DSN)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	user, err := conn.GetUserByEmail(strings.TrimSpace(*email))
+	if err != nil {
+		return fmt.Errorf("no account with email %s; create it with composer users create", *email)
+	}
+	n, err := conn.ClaimOwnerlessChatSessions(user.ID)
+	if err != nil {
+		return err
+	}
+	fmt.Println(n)
+	return nil
+}
+
+// exportSessionCommand writes a session's artifact to a file named like the
+// UI's downloads, or to --out.
+func exportSessionCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("composer export-session", flag.ExitOnError)
+	user := userFlag(fs)
+	format := fs.String("format", "docx", "docx, pdf, md or html")
+	version := fs.String("version", "", "version to export; the latest if empty")
+	out := fs.String("out", "", "file to write, - for standard output; named after the document if empty")
+	cfg, positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usagef("usage: composer export-session <session id> [--format docx|pdf|md|html] [--version n] [--out file]")
+	}
+
+	return withClient(ctx, cfg, *user, func(cl *client) error {
+		query := url.Values{"format": {*format}}
+		if *version != "" {
+			query.Set("version", *version)
+		}
+		res, err := cl.do(http.MethodGet, "/api/chat-sessions/"+url.PathEscape(positional[0])+"/export?"+query.Encode(), "", nil)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		name := *out
+		if name == "" {
+			_, params, _ := mime.ParseMediaType(res.Header.Get("Content-Disposition"))
+			name = filepath.Base(params["filename"])
+		}
+		return writeOutput(name, res.Body)
+	})
+}
+
+// importCommand imports a document as the first version of a session's
+// artifact, creating the session unless --session is given. It prints the
+// session's ID.
+func importCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("composer import", flag.ExitOnError)
+	user := userFlag(fs)
+	sessionID := fs.String("session", "", "session to import into; a new one if empty")
+	workspace := fs.String("workspace", "", "workspace of the new session")
+	format := fs.String("format", "html", "html for the document editor, markdown for the code editor")
+	cfg, positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usagef("usage: composer import <file> [--session id | --workspace id] [--format html|markdown]")
+	}
+
+	return withClient(ctx, cfg, *user, func(cl *client) error {
+		id, err := importFile(cl, *sessionID, *workspace, positional[0], *format)
+		if err != nil {
+			return err
+		}
+		fmt.Println(id)
+		return nil
+	})
+}
+
+// generateCommand sends one prompt, as the UI would, and writes the
+// resulting artifact to --out. The explanation goes to standard error.
+func generateCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("composer generate", flag.ExitOnError)
+	user := userFlag(fs)
+	prompt := fs.String("prompt", "", "what to write or change")
+	out := fs.String("out", "-", "file to write the document to, - for standard output")
+	format := fs.String("format", "markdown", "markdown, or html as in the document editor")
+	sessionID := fs.String("session", "", "session to continue; a new one if empty")
+	workspace := fs.String("workspace", "", "workspace of the new session")
+	from := fs.String("from", "", "document to start from, imported into the session first")
+	cfg, _, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(*prompt) == "" {
+		return usagef("--prompt is required")
+	}
+	if *format != "markdown" && *format != "html" {
+		return usagef("--format must be markdown or html, not %q", *format)
+	}
+
+	return withClient(ctx, cfg, *user, func(cl *client) error {
+		id := *sessionID
+		if *from != "" {
+			var err error
+			if id, err = importFile(cl, id, *workspace, *from, *format); err != nil {
+				return err
+			}
+		} else if id == "" {
+			var session models.ChatSession
+			if err := cl.doJSON(http.MethodPost, "/api/chat-sessions", models.ChatSession{WorkspaceID: *workspace}, &session); err != nil {
+				return err
+			}
+			id = session.ID
+		}
+		fmt.Fprintf(os.Stderr, "session %s\n", id)
+
+		// The model sees the latest version as the user's current document.
+		var versions []models.Document
+		if err := cl.doJSON(http.MethodGet, "/api/chat-sessions/"+url.PathEscape(id)+"/versions", nil, &versions); err != nil {
+			return err
+		}
+		var artifact string
+		if len(versions) > 0 {
+			artifact = versions[len(versions)-1].Contents
+		}
+
+		body, err := json.Marshal(map[string]any{
+			"content":          *prompt,
+			"artifact":         artifact,
+			"isDocumentEditor": *format == "html",
+		})
+		if err != nil {
+			return err
+		}
+		res, err := cl.do(http.MethodPost, "/api/chat-sessions/"+url.PathEscape(id)+"/messages", echo.MIMEApplicationJSON, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		// The response streams the whole state on every update; the last
+		// one is final.
+		var final routes.UserChatMessageResponse
+		dec := json.NewDecoder(res.Body)
+		for {
+			var update routes.UserChatMessageResponse
+			if err := dec.Decode(&update); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return fmt.Errorf("reading the response: %w", err)
+			}
+			final = update
+		}
+
+		if final.Message != "" {
+			fmt.Fprintln(os.Stderr, final.Message)
+		}
+		for _, f := range final.Guardrails {
+			fmt.Fprintf(os.Stderr, "guardrail %s: %s\n", f.Rule, f.Action)
+		}
+		if final.Artifact == "" {
+			return errors.New("the response contains no document")
+		}
+		return writeOutput(*out, strings.NewReader(final.Artifact))
+	})
+}
+
+// webhooksCommand runs a receiver that checks and prints the deliveries it
+// gets, to try webhooks out locally.
+func webhooksCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "listen" {
+		return usagef("usage: composer webhooks listen [--addr 127.0.0.1:9090] [--secret <secret>] [--status 204]")
+	}
+
+	fs := flag.NewFlagSet("composer webhooks listen", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:9090", "address to listen on")
+	secret := fs.String("secret", os.Getenv("COMPOSER_WEBHOOK_SECRET"), "the webhook's secret, to check signatures (env COMPOSER_WEBHOOK_SECRET)")
+	status := fs.Int("status", http.StatusNoContent, "status to answer with; an error status makes Composer retry")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	srv := &http.Server{Addr: *addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 10<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		event, delivery := r.Header.Get(webhooks.HeaderEvent), r.Header.Get(webhooks.HeaderDelivery)
+
+		signature := "not checked"
+		if *secret != "" {
+			if err := webhooks.Verify(*secret, r.Header.Get(webhooks.HeaderSignature), body, time.Now(), 5*time.Minute); err != nil {
+				fmt.Fprintf(os.Stderr, "rejected %s delivery %s: %s\n", event, delivery, err)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			signature = "valid"
+		}
+
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, body, "", "  ") != nil {
+			pretty.Reset()
+			pretty.Write(body)
+		}
+		fmt.Printf("%s delivery %s, signature %s\n%s\n", event, delivery, signature, pretty.String())
+		w.WriteHeader(*status)
+	})}
+
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	fmt.Fprintf(os.Stderr, "listening for deliveries on http://%s\n", *addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// withClient starts the server in-process and runs fn signed in as user.
+func withClient(ctx context.Context, cfg *config.Config, user string, fn func(*client) error) error {
+	srv, err := newServer(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer srv.Close(context.WithoutCancel(ctx))
+
+	cl, err := signIn(ctx, srv, user)
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	return fn(cl)
+}
+
+// importFile uploads path into sessionID, creating a session in workspace if
+// it is empty, and returns the session's ID.
+func importFile(cl *client, sessionID, workspace, path, format string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	if sessionID == "" {
+		var session models.ChatSession
+		if err := cl.doJSON(http.MethodPost, "/api/chat-sessions", models.ChatSession{WorkspaceID: workspace}, &session); err != nil {
+			return "", err
+		}
+		sessionID = session.ID
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("format", format)
+	fw, err := mw.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	fw.Write(data)
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+
+	res, err := cl.do(http.MethodPost, "/api/chat-sessions/"+url.PathEscape(sessionID)+"/import", mw.FormDataContentType(), &body)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	return sessionID, nil
+}
+
+// writeOutput writes r to the file name, or to standard output for "-".
+func writeOutput(name string, r io.Reader) error {
+	if name == "-" {
+		_, err := io.Copy(os.Stdout, r)
+		return err
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", name)
+	return nil
+}
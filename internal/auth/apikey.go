@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"composer/internal/db"
+	"composer/internal/logging"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// APIKeyPrefix marks a bearer token as an API key rather than a session
+// token.
+const APIKeyPrefix = "cmp_"
+
+// API keys can't be used to manage keys or sign-in, so a leaked key can't
+// mint new credentials.
+var apiKeyDenied = []string{"/api/api-keys", "/api/auth/"}
+
+// NewAPIKey returns a key, the short prefix shown in listings, and the hash
+// under which it is stored.
+func NewAPIKey() (key, prefix, hash string, err error) {
+	token, _, err := NewToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + token
+	return key, key[:len(APIKeyPrefix)+6], HashToken(key), nil
+}
+
+// CurrentAPIKey returns the key the request was made with, or nil when it
+// came from a signed-in session.
+func CurrentAPIKey(c echo.Context) *models.APIKey {
+	key, _ := c.Get("api_key").(*models.APIKey)
+	return key
+}
+
+func authenticateAPIKey(c echo.Context, database *db.Db, token string) (*models.User, error) {
+	if err := apiKeyPathDenied(c); err != nil {
+		return nil, err
+	}
+
+	key, err := database.GetAPIKeyByHash(HashToken(token))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "invalid API key")
+	}
+	return useAPIKey(c, database, key)
+}
+
+func apiKeyPathDenied(c echo.Context) error {
+	path := c.Request().URL.Path
+	for _, p := range apiKeyDenied {
+		if path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+			return echo.NewHTTPError(http.StatusForbidden, "API keys can't be used here")
+		}
+	}
+	return nil
+}
+
+// useAPIKey checks that key is valid for the request and returns its user.
+func useAPIKey(c echo.Context, database *db.Db, key *models.APIKey) (*models.User, error) {
+	now := time.Now()
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "API key has expired")
+	}
+
+	scope := models.ScopeWrite
+	switch c.Request().Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		scope = models.ScopeRead
+	}
+	if !key.HasScope(scope) {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "API key lacks the "+scope+" scope")
+	}
+
+	user, err := database.GetUser(key.UserID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "invalid API key")
+	}
+
+	if err := database.TouchAPIKey(key.ID, now); err != nil {
+		logging.FromContext(c.Request().Context()).Error("recording use of API key", "api_key_id", key.ID, "error", err)
+	}
+	key.LastUsedAt = &now
+	c.Set("api_key", key)
+	return user, nil
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"composer/internal/db"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	CookieName = "composer_session"
+	SessionTTL = 7 * 24 * time.Hour
+
+	MinPasswordLength = 8
+)
+
+// PublicPaths are the API routes that can be called without signing in.
+// Anything outside /api/ (the UI) is public as well.
+var PublicPaths = []string{
+	"/api/v1/healthz",
+	"/api/auth/register",
+	"/api/auth/login",
+	"/api/auth/oidc/",
+	"/api/shared/",
+}
+
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", errors.New("password must be at least 8 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CheckPassword authenticates a local account. Users without a password
+// (OIDC only) can never sign in this way.
+func CheckPassword(user *models.User, password string) error {
+	if user.PasswordHash == "" {
+		return ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// NewToken returns a random token and the hash under which it is stored.
+func NewToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartSession signs user in, setting the session cookie for browsers. The
+// returned token can be used as a bearer token by other clients.
+func StartSession(c echo.Context, database *db.Db, user *models.User) (string, *models.AuthSession, error) {
+	token, hash, err := NewToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	session := &models.AuthSession{
+		TokenHash: hash,
+		UserID:    user.ID,
+		ExpiresAt: now.Add(SessionTTL),
+		CreatedAt: now,
+	}
+	if err := database.InsertAuthSession(session); err != nil {
+		return "", nil, err
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, session, nil
+}
+
+// EndSession signs out the caller's current session.
+func EndSession(c echo.Context, database *db.Db) error {
+	c.SetCookie(&http.Cookie{
+		Name:     CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	token := requestToken(c)
+	if token == "" {
+		return nil
+	}
+	return database.DeleteAuthSession(HashToken(token))
+}
+
+// Middleware rejects API requests that carry no valid session or API key, and
+// makes the user available through CurrentUser.
+func Middleware(database *db.Db) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if isPublic(c.Request().URL.Path) {
+				return next(c)
+			}
+			database := database.WithContext(c.Request().Context())
+
+			if p, ok := c.Request().Context().Value(principalKey{}).(Principal); ok {
+				user, err := authenticatePrincipal(c, database, p)
+				if err != nil {
+					return err
+				}
+				c.Set("user", user)
+				return next(c)
+			}
+
+			token := requestToken(c)
+			if token == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "sign in required")
+			}
+
+			if strings.HasPrefix(token, APIKeyPrefix) {
+				user, err := authenticateAPIKey(c, database, token)
+				if err != nil {
+					return err
+				}
+				c.Set("user", user)
+				return next(c)
+			}
+
+			hash := HashToken(token)
+			session, err := database.GetAuthSession(hash)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired session")
+			}
+			if time.Now().After(session.ExpiresAt) {
+				database.DeleteAuthSession(hash)
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired session")
+			}
+
+			user, err := database.GetUser(session.UserID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired session")
+			}
+
+			c.Set("user", user)
+			return next(c)
+		}
+	}
+}
+
+type principalKey struct{}
+
+// Principal is a user, and the API key they used if any, on whose behalf a
+// request is made in-process, such as by a background job.
+type Principal struct {
+	UserID   string
+	APIKeyID string
+}
+
+// WithPrincipal returns a context for in-process requests on behalf of p,
+// which Middleware accepts in place of a token. Contexts can't come from the
+// network, so this can't be used by clients.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// authenticatePrincipal checks the user and key again, as they may have been
+// deleted, revoked or have expired since the work was requested.
+func authenticatePrincipal(c echo.Context, database *db.Db, p Principal) (*models.User, error) {
+	if p.APIKeyID != "" {
+		if err := apiKeyPathDenied(c); err != nil {
+			return nil, err
+		}
+		key, err := database.GetAPIKey(p.APIKeyID)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusUnauthorized, "invalid API key")
+		}
+		return useAPIKey(c, database, key)
+	}
+
+	user, err := database.GetUser(p.UserID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "user not found")
+	}
+	return user, nil
+}
+
+// CurrentUser returns the signed-in user, or nil on public routes.
+func CurrentUser(c echo.Context) *models.User {
+	user, _ := c.Get("user").(*models.User)
+	return user
+}
+
+// requestToken reads a bearer token, falling back to the session cookie.
+func requestToken(c echo.Context) string {
+	if header := c.Request().Header.Get(echo.HeaderAuthorization); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	if cookie, err := c.Cookie(CookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func isPublic(path string) bool {
+	if !strings.HasPrefix(path, "/api/") {
+		return true
+	}
+	for _, p := range PublicPaths {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
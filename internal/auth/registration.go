@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrRegistrationClosed = errors.New("registration is closed; ask an administrator for an account")
+	ErrDomainNotAllowed   = errors.New("accounts with this email domain are not allowed")
+)
+
+// Registration decides who may create an account themselves: Open lets
+// anyone register a local account, and Domains, if not empty, limits new
+// accounts, local or OIDC, to emails of those domains. Accounts created with
+// "composer users create" are not subject to either.
+type Registration struct {
+	Open    bool
+	Domains []string
+}
+
+// AllowsEmail reports whether email is in one of the allowed domains.
+// Subdomains are not: example.com doesn't allow mail.example.com.
+func (r Registration) AllowsEmail(email string) bool {
+	if len(r.Domains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, d := range r.Domains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+// Package config loads Composer's configuration from a YAML or TOML file, the
+// environment and command-line flags, each overriding the one before.
+//
+// Every setting has a key such as server.addr, used in files and as the flag
+// --server.addr, and most have an environment variable, named in their env
+// tag. Run "composer config print" to see them all with their values.
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/jobs"
+	"composer/internal/logging"
+	"composer/internal/ratelimit"
+	"composer/internal/webhooks"
+)
+
+type Config struct {
+	Server    Server    `yaml:"server" toml:"server"`
+	Database  Database  `yaml:"database" toml:"database"`
+	LLM       LLM       `yaml:"llm" toml:"llm"`
+	Knowledge Knowledge `yaml:"knowledge" toml:"knowledge"`
+	Auth      Auth      `yaml:"auth" toml:"auth"`
+	OIDC      OIDC      `yaml:"oidc" toml:"oidc"`
+	Limits    Limits    `yaml:"limits" toml:"limits"`
+	Jobs      Jobs      `yaml:"jobs" toml:"jobs"`
+	Webhooks  Webhooks  `yaml:"webhooks" toml:"webhooks"`
+	Logging   Logging   `yaml:"logging" toml:"logging"`
+	Branding  Branding  `yaml:"branding" toml:"branding"`
+}
+
+type Server struct {
+	Addr           string `yaml:"addr" toml:"addr" env:"COMPOSER_ADDR" help:"address to listen on"`
+	StaticDir      string `yaml:"static_dir" toml:"static_dir" env:"COMPOSER_STATIC_DIR" help:"directory of a built UI to serve instead of the embedded one, for development"`
+	BlobDir        string `yaml:"blob_dir" toml:"blob_dir" env:"COMPOSER_BLOB_DIR" help:"directory attachments are stored in"`
+	MetricsAddr    string `yaml:"metrics_addr" toml:"metrics_addr" env:"COMPOSER_METRICS_ADDR" help:"address to serve Prometheus metrics on, apart from the API; empty doesn't serve them"`
+	TrustedProxies string `yaml:"trusted_proxies" toml:"trusted_proxies" env:"COMPOSER_TRUSTED_PROXIES" help:"comma-separated IPs or CIDR ranges of reverse proxies whose X-Forwarded-For is believed; empty uses the connection's address"`
+}
+
+type Database struct {
+	Type string `yaml:"type" toml:"type" env:"DB_TYPE" help:"sqlite3 or postgres"`
+	DSN  string `yaml:"dsn" toml:"dsn" env:"DB_CONNECTION_STRING" secret:"dsn" help:"file name for sqlite3, connection URL for postgres"`
+}
+
+type LLM struct {
+	Provider       string `yaml:"provider" toml:"provider" env:"LLM_PROVIDER" help:"vertex, or googleai for the Gemini API"`
+	Project        string `yaml:"project" toml:"project" env:"GOOGLE_CLOUD_PROJECT" help:"Google Cloud project, for vertex"`
+	Location       string `yaml:"location" toml:"location" env:"GOOGLE_CLOUD_LOCATION" help:"Google Cloud region, for vertex"`
+	APIKey         string `yaml:"api_key" toml:"api_key" env:"GOOGLE_API_KEY" secret:"true" help:"API key, for googleai"`
+	Model          string `yaml:"model" toml:"model" env:"LLM_MODEL" help:"model used for generation"`
+	PricesFile     string `yaml:"prices_file" toml:"prices_file" env:"MODEL_PRICES_FILE" help:"JSON price table for cost estimates; empty uses the built-in prices"`
+	GuardrailsFile string `yaml:"guardrails_file" toml:"guardrails_file" env:"GUARDRAILS_FILE" help:"JSON guardrail rules; empty only checks for prompt injection"`
+	TemplatesDir   string `yaml:"templates_dir" toml:"templates_dir" env:"TEMPLATES_DIR" help:"outlines /api/generate can follow, as <name>.md or <name>.html"`
+}
+
+type Knowledge struct {
+	Dir      string `yaml:"dir" toml:"dir" env:"KNOWLEDGE_DIR" help:"directories under here can be ingested"`
+	Embedder string `yaml:"embedder" toml:"embedder" env:"KNOWLEDGE_EMBEDDER" help:"hash works offline, vertex uses the LLM provider's embeddings"`
+	Editors  string `yaml:"editors" toml:"editors" env:"KNOWLEDGE_EDITORS" help:"comma-separated emails of the users who may add to the shared default knowledge base; nobody if empty"`
+}
+
+type Auth struct {
+	AllowRegistration string `yaml:"allow_registration" toml:"allow_registration" env:"AUTH_ALLOW_REGISTRATION" help:"true to let anyone create a local account, false to leave it to \"composer users create\"; empty is true unless OIDC is configured"`
+	AllowedDomains    string `yaml:"allowed_domains" toml:"allowed_domains" env:"AUTH_ALLOWED_DOMAINS" help:"comma-separated email domains of the accounts users can create, locally or with OIDC; any if empty"`
+}
+
+type OIDC struct {
+	Issuer       string `yaml:"issuer" toml:"issuer" env:"OIDC_ISSUER" help:"OpenID Connect issuer URL; empty turns OIDC sign-in off"`
+	ClientID     string `yaml:"client_id" toml:"client_id" env:"OIDC_CLIENT_ID" help:"OIDC client ID"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true" help:"OIDC client secret"`
+	RedirectURL  string `yaml:"redirect_url" toml:"redirect_url" env:"OIDC_REDIRECT_URL" help:"URL of /api/auth/oidc/callback as the browser sees it"`
+}
+
+// Limits are the generation limits of ratelimit. File, if set, replaces the
+// limits given here.
+type Limits struct {
+	Store     string           `yaml:"store" toml:"store" env:"RATE_LIMIT_STORE" help:"memory, or sql to share counters between instances"`
+	File      string           `yaml:"file" toml:"file" env:"RATE_LIMITS_FILE" help:"JSON file of limits, replacing those below"`
+	User      ratelimit.Limits `yaml:"user" toml:"user" help:"limits per user; 0 is unlimited"`
+	APIKey    ratelimit.Limits `yaml:"api_key" toml:"api_key" help:"limits per API key; 0 is unlimited"`
+	Workspace ratelimit.Limits `yaml:"workspace" toml:"workspace" help:"limits per workspace; 0 is unlimited"`
+}
+
+type Jobs struct {
+	Workers      int `yaml:"workers" toml:"workers" env:"JOB_WORKERS" help:"background job workers per instance; 0 runs none"`
+	MaxAttempts  int `yaml:"max_attempts" toml:"max_attempts" env:"JOB_MAX_ATTEMPTS" help:"tries per job before it fails"`
+	LeaseSeconds int `yaml:"lease_seconds" toml:"lease_seconds" env:"JOB_LEASE_SECONDS" help:"how long a worker holds a job between renewals"`
+}
+
+type Webhooks struct {
+	MaxAttempts    int  `yaml:"max_attempts" toml:"max_attempts" env:"WEBHOOK_MAX_ATTEMPTS" help:"tries per delivery before it fails"`
+	TimeoutSeconds int  `yaml:"timeout_seconds" toml:"timeout_seconds" env:"WEBHOOK_TIMEOUT_SECONDS" help:"how long a receiver has to answer"`
+	AllowPrivate   bool `yaml:"allow_private" toml:"allow_private" env:"WEBHOOK_ALLOW_PRIVATE" help:"let webhooks reach loopback and private addresses, for trying receivers locally"`
+}
+
+type Logging struct {
+	Level   string `yaml:"level" toml:"level" env:"LOG_LEVEL" help:"debug, info, warn or error"`
+	Format  string `yaml:"format" toml:"format" env:"LOG_FORMAT" help:"json or text"`
+	Content bool   `yaml:"content" toml:"content" env:"LOG_CONTENT" help:"log documents and prompts, for debugging only"`
+}
+
+type Branding struct {
+	Name string `yaml:"name" toml:"name" env:"COMPOSER_BRAND_NAME" help:"organization name on exports and shared pages"`
+	Logo string `yaml:"logo" toml:"logo" env:"COMPOSER_BRAND_LOGO" help:"PNG logo on exports and shared pages, the UI's if empty; skipped if missing"`
+}
+
+// Default is the configuration before any file, environment variable or
+// flag is applied.
+func Default() *Config {
+	return &Config{
+		Server:   Server{Addr: ":9081", BlobDir: "data/blobs"},
+		Database: Database{Type: "sqlite3", DSN: "composer.db"},
+		LLM: LLM{
+			Provider:     "vertex",
+			Project:      "kodespaces",
+			Location:     "us-central1",
+			Model:        "gemini-2.0-flash-exp",
+			TemplatesDir: "data/templates",
+		},
+		Knowledge: Knowledge{Dir: "data/knowledge", Embedder: "hash"},
+		OIDC:      OIDC{RedirectURL: "http://localhost:9081/api/auth/oidc/callback"},
+		Limits: Limits{
+			Store:     "memory",
+			User:      ratelimit.DefaultConfig.User,
+			APIKey:    ratelimit.DefaultConfig.APIKey,
+			Workspace: ratelimit.DefaultConfig.Workspace,
+		},
+		Jobs:     Jobs{Workers: 2, MaxAttempts: 3, LeaseSeconds: 60},
+		Webhooks: Webhooks{MaxAttempts: 8, TimeoutSeconds: 10},
+		Logging:  Logging{Level: "info", Format: "json"},
+		Branding: Branding{Name: "Citi"},
+	}
+}
+
+// RateLimits returns the limits to enforce, reading Limits.File if set.
+func (c *Config) RateLimits() (ratelimit.Config, error) {
+	if c.Limits.File != "" {
+		return ratelimit.Load(c.Limits.File)
+	}
+	return ratelimit.Config{User: c.Limits.User, APIKey: c.Limits.APIKey, Workspace: c.Limits.Workspace}, nil
+}
+
+// TrustedProxies returns the ranges of Server.TrustedProxies. A bare IP is a
+// range of one.
+func (c *Config) TrustedProxies() ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	for _, item := range list(c.Server.TrustedProxies) {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP or CIDR range", item)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			ranges = append(ranges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP or CIDR range", item)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
+
+// KnowledgeEditors returns the users allowed to add to the default knowledge
+// base, lower-cased.
+func (c *Config) KnowledgeEditors() []string {
+	return list(strings.ToLower(c.Knowledge.Editors))
+}
+
+// Registration returns who may create an account. Registration is open by
+// default only without OIDC: with it, accounts come from the provider.
+func (c *Config) Registration() auth.Registration {
+	open := c.OIDC.Issuer == ""
+	if c.Auth.AllowRegistration != "" {
+		open, _ = strconv.ParseBool(c.Auth.AllowRegistration)
+	}
+	return auth.Registration{Open: open, Domains: list(strings.ToLower(c.Auth.AllowedDomains))}
+}
+
+// list splits a comma-separated setting, dropping empty items.
+func list(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// JobsConfig returns the settings for jobs.New.
+func (c *Config) JobsConfig() jobs.Config {
+	return jobs.Config{
+		Workers:     c.Jobs.Workers,
+		MaxAttempts: c.Jobs.MaxAttempts,
+		Lease:       time.Duration(c.Jobs.LeaseSeconds) * time.Second,
+	}
+}
+
+// WebhooksConfig returns the settings for webhooks.New.
+func (c *Config) WebhooksConfig() webhooks.Config {
+	return webhooks.Config{
+		MaxAttempts:  c.Webhooks.MaxAttempts,
+		Timeout:      time.Duration(c.Webhooks.TimeoutSeconds) * time.Second,
+		AllowPrivate: c.Webhooks.AllowPrivate,
+	}
+}
+
+// LoggingConfig returns the settings for logging.Setup.
+func (c *Config) LoggingConfig() logging.Config {
+	return logging.Config{Level: c.Logging.Level, Format: c.Logging.Format, Content: c.Logging.Content}
+}
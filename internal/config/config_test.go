@@ -0,0 +1,231 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// clearEnv unsets the environment variables of every setting for the test.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, f := range fields(Default()) {
+		if f.env != "" {
+			t.Setenv(f.env, "")
+			os.Unsetenv(f.env)
+		}
+	}
+	t.Setenv("COMPOSER_CONFIG", "")
+}
+
+func writeFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// load loads the configuration with args as the command line.
+func load(t *testing.T, args ...string) (*Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("composer", flag.ContinueOnError)
+	flags := BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags.Load()
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	files := map[string]string{
+		"composer.yaml": "server:\n  addr: \":1\"\nllm:\n  model: file\njobs:\n  workers: 5\nlogging:\n  level: debug\n",
+		"composer.toml": "[server]\naddr = \":1\"\n[llm]\nmodel = \"file\"\n[jobs]\nworkers = 5\n[logging]\nlevel = \"debug\"\n",
+	}
+	for name, contents := range files {
+		t.Run(name, func(t *testing.T) {
+			clearEnv(t)
+			path := writeFile(t, name, contents)
+			t.Setenv("COMPOSER_ADDR", ":2")
+			t.Setenv("LLM_MODEL", "env")
+
+			cfg, err := load(t, "--config", path, "--server.addr", ":3")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, tt := range []struct{ setting, got, want string }{
+				{"server.addr, set everywhere", cfg.Server.Addr, ":3"},
+				{"llm.model, in the file and the environment", cfg.LLM.Model, "env"},
+				{"logging.level, in the file", cfg.Logging.Level, "debug"},
+				{"database.type, nowhere", cfg.Database.Type, "sqlite3"},
+			} {
+				if tt.got != tt.want {
+					t.Errorf("%s = %q, want %q", tt.setting, tt.got, tt.want)
+				}
+			}
+			if cfg.Jobs.Workers != 5 {
+				t.Errorf("jobs.workers = %d, want 5", cfg.Jobs.Workers)
+			}
+		})
+	}
+
+	t.Run("COMPOSER_CONFIG", func(t *testing.T) {
+		clearEnv(t)
+		t.Setenv("COMPOSER_CONFIG", writeFile(t, "composer.yaml", "llm:\n  model: file\n"))
+		cfg, err := load(t)
+		if err != nil || cfg.LLM.Model != "file" {
+			t.Errorf("Load() = %v, %v; want llm.model from the file", cfg, err)
+		}
+	})
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		env   map[string]string
+		args  []string
+		wants []string
+	}{
+		{name: "unknown yaml key", file: "server:\n  adr: \":1\"\n", wants: []string{"adr"}},
+		{name: "unknown toml key", file: "[server]\nadr = \":1\"\n", wants: []string{"unknown setting server.adr"}},
+		{name: "unknown toml section", file: "[sever]\naddr = \":1\"\n", wants: []string{"unknown setting sever"}},
+		{name: "other file type", file: "{}", wants: []string{"must end in .yaml, .yml or .toml"}},
+		{
+			name:  "bad values in the environment and flags",
+			env:   map[string]string{"JOB_WORKERS": "many", "WEBHOOK_ALLOW_PRIVATE": "maybe"},
+			args:  []string{"--jobs.max_attempts", "x"},
+			wants: []string{"jobs.workers (JOB_WORKERS): \"many\" is not a whole number", "webhooks.allow_private (WEBHOOK_ALLOW_PRIVATE): \"maybe\" is not true or false", "jobs.max_attempts (JOB_MAX_ATTEMPTS): \"x\" is not a whole number"},
+		},
+		{name: "invalid", args: []string{"--database.type", "mysql"}, wants: []string{"database.type (DB_TYPE): must be sqlite3 or postgres"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				name := "composer.yaml"
+				switch {
+				case strings.Contains(tt.name, "toml"):
+					name = "composer.toml"
+				case strings.Contains(tt.name, "other"):
+					name = "composer.json"
+				}
+				args = append([]string{"--config", writeFile(t, name, tt.file)}, args...)
+			}
+
+			_, err := load(t, args...)
+			if err == nil {
+				t.Fatal("Load() succeeded, want an error")
+			}
+			for _, want := range tt.wants {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Load() = %v, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("the defaults are invalid: %v", err)
+	}
+
+	cfg := Default()
+	cfg.Server.Addr = "9081"
+	cfg.Server.MetricsAddr = "9081"
+	cfg.Server.TrustedProxies = "10.0.0.1, proxy"
+	cfg.Auth.AllowRegistration = "sometimes"
+	cfg.LLM.Provider = "googleai"
+	cfg.Knowledge.Embedder = "openai"
+	cfg.OIDC.Issuer = "accounts.example.com"
+	cfg.Limits.User.Concurrent = -1
+	cfg.Jobs.MaxAttempts = 0
+	cfg.Logging.Level = "verbose"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() succeeded")
+	}
+	wants := []string{
+		`server.addr (COMPOSER_ADDR): must be host:port or :port, not "9081"`,
+		`server.metrics_addr (COMPOSER_METRICS_ADDR): must be host:port or :port`,
+		`server.trusted_proxies (COMPOSER_TRUSTED_PROXIES): "proxy" is not an IP or CIDR range`,
+		`auth.allow_registration (AUTH_ALLOW_REGISTRATION): must be true, false or empty`,
+		`llm.api_key (GOOGLE_API_KEY): is required with the googleai provider`,
+		`knowledge.embedder (KNOWLEDGE_EMBEDDER): must be hash or vertex, not "openai"`,
+		`oidc.issuer (OIDC_ISSUER): must be an absolute URL`,
+		`oidc.client_id (OIDC_CLIENT_ID): is required with an OIDC issuer`,
+		`limits.user.concurrent: must not be negative`,
+		`jobs.max_attempts (JOB_MAX_ATTEMPTS): must be at least 1`,
+		`logging.level (LOG_LEVEL): must be debug, info, warn or error`,
+	}
+	for _, want := range wants {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() doesn't report %q", want)
+		}
+	}
+	if n := len(strings.Split(err.Error(), "\n")); n != len(wants) {
+		t.Errorf("Validate() reported %d errors, want %d:\n%v", n, len(wants), err)
+	}
+
+	cfg = Default()
+	cfg.Server.MetricsAddr = cfg.Server.Addr
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "must differ from server.addr") {
+		t.Errorf("Validate() with metrics on the API's address = %v", err)
+	}
+}
+
+func TestMaskDSN(t *testing.T) {
+	tests := []struct{ dsn, want string }{
+		{"composer.db", "composer.db"},
+		{"postgres://app:s3cret@db:5432/composer?sslmode=disable", "postgres://app:xxxxx@db:5432/composer?sslmode=disable"},
+		{"postgres://app@db/composer", "postgres://app@db/composer"},
+		{"host=db user=app password=s3cret dbname=composer", "host=db user=app password=******** dbname=composer"},
+		{"host=db PASSWORD='two words' dbname=composer", "host=db PASSWORD=******** dbname=composer"},
+	}
+	for _, tt := range tests {
+		if got := maskDSN(tt.dsn); got != tt.want {
+			t.Errorf("maskDSN(%q) = %q, want %q", tt.dsn, got, tt.want)
+		}
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	tests := []struct {
+		setting string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"10.0.0.1", []string{"10.0.0.1/32"}, false},
+		{" 10.0.0.0/8 , ,::1", []string{"10.0.0.0/8", "::1/128"}, false},
+		{"192.168.1.7/24", []string{"192.168.1.0/24"}, false},
+		{"::ffff:10.0.0.1", []string{"10.0.0.1/32"}, false},
+		{"10.0.0.1, proxy.internal", nil, true},
+		{"10.0.0.0/33", nil, true},
+	}
+	for _, tt := range tests {
+		cfg := Default()
+		cfg.Server.TrustedProxies = tt.setting
+		ranges, err := cfg.TrustedProxies()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("TrustedProxies(%q) error = %v, want error %v", tt.setting, err, tt.wantErr)
+			continue
+		}
+		var got []string
+		for _, r := range ranges {
+			got = append(got, r.String())
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("TrustedProxies(%q) = %v, want %v", tt.setting, got, tt.want)
+		}
+	}
+}
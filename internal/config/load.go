@@ -0,0 +1,170 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// field is a setting: a leaf of Config.
+type field struct {
+	key    string
+	env    string
+	help   string
+	secret string
+	value  reflect.Value
+}
+
+// fields lists the settings of cfg in declaration order.
+func fields(cfg *Config) []field {
+	var out []field
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			key := prefix + strings.Split(sf.Tag.Get("yaml"), ",")[0]
+			if sf.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), key+".")
+				continue
+			}
+			out = append(out, field{
+				key:    key,
+				env:    sf.Tag.Get("env"),
+				help:   sf.Tag.Get("help"),
+				secret: sf.Tag.Get("secret"),
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	return out
+}
+
+// name is how errors refer to a setting.
+func (f field) name() string {
+	if f.env != "" {
+		return fmt.Sprintf("%s (%s)", f.key, f.env)
+	}
+	return f.key
+}
+
+func (f field) set(s string) error {
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a whole number", f.name(), s)
+		}
+		f.value.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not true or false", f.name(), s)
+		}
+		f.value.SetBool(b)
+	default:
+		return fmt.Errorf("%s: unsupported type %s", f.name(), f.value.Type())
+	}
+	return nil
+}
+
+// Flags are the command-line flags of the configuration: --config, naming
+// the file, and one flag per setting.
+type Flags struct {
+	path   string
+	values map[string]string
+}
+
+// BindFlags registers the configuration flags on fs.
+func BindFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{values: map[string]string{}}
+	fs.StringVar(&f.path, "config", os.Getenv("COMPOSER_CONFIG"), "YAML or TOML configuration file (env COMPOSER_CONFIG)")
+	for _, fd := range fields(Default()) {
+		key := fd.key
+		usage := fd.help
+		if fd.env != "" {
+			usage += " (env " + fd.env + ")"
+		}
+		fs.Func(key, usage, func(s string) error {
+			f.values[key] = s
+			return nil
+		})
+	}
+	return f
+}
+
+// Load builds the configuration from the defaults, the file, the environment
+// and the flags, and validates it.
+func (f *Flags) Load() (*Config, error) {
+	cfg := Default()
+	if f.path != "" {
+		if err := loadFile(cfg, f.path); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, fd := range fields(cfg) {
+		if fd.env == "" {
+			continue
+		}
+		if s, ok := os.LookupEnv(fd.env); ok {
+			errs = append(errs, fd.set(s))
+		}
+	}
+	for _, fd := range fields(cfg) {
+		if s, ok := f.values[fd.key]; ok {
+			errs = append(errs, fd.set(s))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile reads a file over cfg. Unknown keys are errors, so that typos
+// don't go unnoticed.
+func loadFile(cfg *Config, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(raw))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(raw), cfg)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("parsing %s: unknown setting %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("%s: configuration files must end in .yaml, .yml or .toml", path)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Print writes cfg as a configuration file in format, yaml or toml, with
+// secrets masked. YAML output documents each setting in a comment.
+func Print(w io.Writer, cfg *Config, format string) error {
+	masked := *cfg
+	for _, f := range fields(&masked) {
+		switch {
+		case f.value.String() == "":
+		case f.secret == "true":
+			f.value.SetString("********")
+		case f.secret == "dsn":
+			f.value.SetString(maskDSN(f.value.String()))
+		}
+	}
+
+	switch format {
+	case "yaml", "":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(documented(reflect.ValueOf(masked))); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "toml":
+		return toml.NewEncoder(w).Encode(masked)
+	}
+	return fmt.Errorf("format must be yaml or toml, not %q", format)
+}
+
+// documented converts a struct to a YAML mapping with each key's help and
+// environment variable as its comment.
+func documented(v reflect.Value) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: strings.Split(sf.Tag.Get("yaml"), ",")[0]}
+		comment := sf.Tag.Get("help")
+		if env := sf.Tag.Get("env"); env != "" {
+			comment = strings.TrimSpace(comment + " (env " + env + ")")
+		}
+		key.HeadComment = comment
+
+		var value *yaml.Node
+		if sf.Type.Kind() == reflect.Struct {
+			value = documented(v.Field(i))
+		} else {
+			value = &yaml.Node{}
+			value.Encode(v.Field(i).Interface())
+		}
+		node.Content = append(node.Content, key, value)
+	}
+	return node
+}
+
+var dsnPassword = regexp.MustCompile(`(?i)(password=)('[^']*'|\S+)`)
+
+// maskDSN hides the password of a postgres URL or key=value connection
+// string.
+func maskDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return u.Redacted()
+		}
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}********")
+}
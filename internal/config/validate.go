@@ -0,0 +1,130 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	byKey := map[string]field{}
+	for _, f := range fields(c) {
+		byKey[f.key] = f
+	}
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", byKey[key].name(), fmt.Sprintf(format, args...)))
+	}
+	oneOf := func(key, value string, allowed ...string) {
+		if !slices.Contains(allowed, value) {
+			fail(key, "must be %s, not %q", strings.Join(allowed, " or "), value)
+		}
+	}
+	required := func(key, value, why string) {
+		if value == "" {
+			fail(key, "is required%s", why)
+		}
+	}
+	exists := func(key, path string) {
+		if path == "" {
+			return
+		}
+		if _, err := os.Stat(path); err != nil {
+			fail(key, "%s", err)
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		fail("server.addr", "must be host:port or :port, not %q", c.Server.Addr)
+	}
+	if c.Server.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Server.MetricsAddr); err != nil {
+			fail("server.metrics_addr", "must be host:port or :port, not %q", c.Server.MetricsAddr)
+		} else if c.Server.MetricsAddr == c.Server.Addr {
+			fail("server.metrics_addr", "must differ from server.addr, or the metrics would be public")
+		}
+	}
+	required("server.blob_dir", c.Server.BlobDir, "")
+	exists("server.static_dir", c.Server.StaticDir)
+	if _, err := c.TrustedProxies(); err != nil {
+		fail("server.trusted_proxies", "%s", err)
+	}
+
+	if c.Auth.AllowRegistration != "" {
+		if _, err := strconv.ParseBool(c.Auth.AllowRegistration); err != nil {
+			fail("auth.allow_registration", "must be true, false or empty, not %q", c.Auth.AllowRegistration)
+		}
+	}
+	for _, domain := range list(c.Auth.AllowedDomains) {
+		if strings.ContainsAny(domain, "@/ ") {
+			fail("auth.allowed_domains", "%q is not a domain", domain)
+		}
+	}
+
+	oneOf("database.type", c.Database.Type, "sqlite3", "postgres")
+	required("database.dsn", c.Database.DSN, "")
+
+	oneOf("llm.provider", c.LLM.Provider, "vertex", "googleai")
+	switch c.LLM.Provider {
+	case "vertex":
+		required("llm.project", c.LLM.Project, " with the vertex provider")
+		required("llm.location", c.LLM.Location, " with the vertex provider")
+	case "googleai":
+		required("llm.api_key", c.LLM.APIKey, " with the googleai provider")
+	}
+	required("llm.model", c.LLM.Model, "")
+	exists("llm.prices_file", c.LLM.PricesFile)
+	exists("llm.guardrails_file", c.LLM.GuardrailsFile)
+
+	oneOf("knowledge.embedder", c.Knowledge.Embedder, "hash", "vertex")
+
+	if c.OIDC.Issuer != "" {
+		if u, err := url.Parse(c.OIDC.Issuer); err != nil || u.Scheme == "" || u.Host == "" {
+			fail("oidc.issuer", "must be an absolute URL, not %q", c.OIDC.Issuer)
+		}
+		required("oidc.client_id", c.OIDC.ClientID, " with an OIDC issuer")
+		if u, err := url.Parse(c.OIDC.RedirectURL); err != nil || u.Scheme == "" || u.Host == "" {
+			fail("oidc.redirect_url", "must be an absolute URL, not %q", c.OIDC.RedirectURL)
+		}
+	}
+
+	oneOf("limits.store", c.Limits.Store, "memory", "sql")
+	exists("limits.file", c.Limits.File)
+	for _, f := range fields(c) {
+		if strings.HasPrefix(f.key, "limits.") && f.value.CanInt() && f.value.Int() < 0 {
+			fail(f.key, "must not be negative")
+		}
+	}
+
+	if c.Jobs.Workers < 0 {
+		fail("jobs.workers", "must not be negative")
+	}
+	if c.Jobs.MaxAttempts < 1 {
+		fail("jobs.max_attempts", "must be at least 1")
+	}
+	if c.Jobs.LeaseSeconds < 3 {
+		fail("jobs.lease_seconds", "must be at least 3")
+	}
+
+	if c.Webhooks.MaxAttempts < 1 {
+		fail("webhooks.max_attempts", "must be at least 1")
+	}
+	if c.Webhooks.TimeoutSeconds < 1 {
+		fail("webhooks.timeout_seconds", "must be at least 1")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		fail("logging.level", "must be debug, info, warn or error, not %q", c.Logging.Level)
+	}
+	oneOf("logging.format", c.Logging.Format, "json", "text")
+
+	return errors.Join(errs...)
+}
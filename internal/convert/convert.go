@@ -0,0 +1,80 @@
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"composer/internal/sanitize"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+type Format string
+
+const (
+	Markdown Format = "markdown"
+	HTML     Format = "html"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "markdown", "md":
+		return Markdown, nil
+	case "html":
+		return HTML, nil
+	}
+	return "", fmt.Errorf("unsupported format %q, expected markdown or html", s)
+}
+
+// FormatFor mirrors the isDocumentEditor flag sent by the UI: the document
+// editor works on HTML and the code editor on Markdown.
+func FormatFor(isDocumentEditor bool) Format {
+	if isDocumentEditor {
+		return HTML
+	}
+	return Markdown
+}
+
+// Stored is the format of a stored artifact: recorded, the format it was
+// stored with. Artifacts stored before formats were recorded have none, and
+// are guessed at: HTML artifacts always opened with markup, the branding logo
+// at the very least, and Markdown artifacts rarely did.
+func Stored(recorded, artifact string) Format {
+	if f, err := ParseFormat(recorded); err == nil {
+		return f
+	}
+	if strings.HasPrefix(strings.TrimSpace(artifact), "<") {
+		return HTML
+	}
+	return Markdown
+}
+
+// Convert converts an artifact from one format to another. Artifacts already
+// in that format are returned unchanged.
+func Convert(artifact string, from, to Format) (string, error) {
+	if from == to {
+		return artifact, nil
+	}
+
+	switch to {
+	case Markdown:
+		return HTMLToMarkdown(artifact)
+	case HTML:
+		return MarkdownToHTML(artifact)
+	}
+	return "", fmt.Errorf("unsupported format %q", to)
+}
+
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+func MarkdownToHTML(src string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(src), &buf); err != nil {
+		return "", err
+	}
+
+	out, _ := sanitize.Artifact(buf.String(), false)
+	return out, nil
+}
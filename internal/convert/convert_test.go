@@ -0,0 +1,110 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStored(t *testing.T) {
+	tests := []struct {
+		recorded, artifact string
+		want               Format
+	}{
+		{"markdown", "<!-- draft -->\n# Title", Markdown},
+		{"markdown", "<details>\n\nNotes\n</details>", Markdown},
+		{"html", "Plain text, no markup", HTML},
+		{"", "<p>Legacy</p>", HTML},
+		{"", "# Legacy", Markdown},
+	}
+	for _, tt := range tests {
+		if got := Stored(tt.recorded, tt.artifact); got != tt.want {
+			t.Errorf("Stored(%q, %q) = %s, want %s", tt.recorded, tt.artifact, got, tt.want)
+		}
+	}
+}
+
+func TestHTMLToMarkdown(t *testing.T) {
+	tests := []struct {
+		name, html, want string
+	}{
+		{"heading", "<h2>Title</h2><p>Body</p>", "## Title\n\nBody\n"},
+		{"text like a heading", "<p># not a heading</p>", "\\# not a heading\n"},
+		{"text like an ordered list", "<p>1. x</p><p>2) y</p>", "1\\. x\n\n2\\) y\n"},
+		{"text like a bullet list", "<p>- x</p><p>+ y</p>", "\\- x\n\n\\+ y\n"},
+		{"text like a quote", "<p>&gt; x</p>", "\\> x\n"},
+		{"markers after a line break", "<p>a<br>- b<br>===</p>", "a  \n\\- b  \n\\===\n"},
+		{"inline markup in text", "<p>&lt;div&gt; &amp;copy; ~~s~~ *e*</p>", "\\<div> \\&copy; \\~\\~s\\~\\~ \\*e\\*\n"},
+		{"marker mid-line", "<p>a - b # c</p>", "a - b # c\n"},
+		{"nested lists", "<ul><li>a<ul><li>b</li><li>1. c</li></ul></li><li>d</li></ul><ol><li>one<ol><li>two</li></ol></li></ol>",
+			"- a\n  - b\n  - 1\\. c\n- d\n\n1. one\n   1. two\n"},
+		{"table", "<table><thead><tr><th>A</th><th>B</th></tr></thead><tbody><tr><td>1 | 2</td><td>- x</td></tr></tbody></table>",
+			"| A | B |\n| --- | --- |\n| 1 \\| 2 | - x |\n"},
+		{"code block", "<pre><code class=\"language-go\"># x\n- y</code></pre>", "```go\n# x\n- y\n```\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := HTMLToMarkdown(tt.html)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("HTMLToMarkdown(%q) = %q, want %q", tt.html, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkdownToHTML(t *testing.T) {
+	tests := []struct {
+		name, markdown, want string
+	}{
+		{"starting with markup", "<!-- draft -->\n# Title", "<h1>Title</h1>"},
+		{"headings", "# One\n\n## Two\n\nBody", "<h1>One</h1><h2>Two</h2><p>Body</p>"},
+		{"escaped markers", "\\# a\n\n1\\. b\n\n\\- c\n\n\\> d", "<p># a</p><p>1. b</p><p>- c</p><p>&gt; d</p>"},
+		{"nested lists", "- a\n  - b\n- c\n\n1. one\n   1. two", "<ul><li>a<ul><li>b</li></ul></li><li>c</li></ul><ol><li>one<ol><li>two</li></ol></li></ol>"},
+		{"table", "| A | B |\n| --- | --- |\n| 1 \\| 2 | x |", "<table><thead><tr><th>A</th><th>B</th></tr></thead><tbody><tr><td>1 | 2</td><td>x</td></tr></tbody></table>"},
+		{"code block", "```go\n# x\n- y\n```", "<pre><code class=\"language-go\"># x- y</code></pre>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarkdownToHTML(tt.markdown)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.ReplaceAll(got, "\n", ""); got != tt.want {
+				t.Errorf("MarkdownToHTML(%q) = %q, want %q", tt.markdown, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRoundTrip checks that converting HTML to Markdown and back keeps its
+// structure.
+func TestRoundTrip(t *testing.T) {
+	for _, src := range []string{
+		"<p># not a heading</p>",
+		"<p>1. x</p>",
+		"<p>- x</p>",
+		"<p>&gt; x</p>",
+		"<p>a<br>- b<br>===</p>",
+		"<p>&lt;div&gt; &amp;copy; ~~s~~</p>",
+		"<h1>Title</h1><p>Body with <strong>bold</strong> and <code>code</code>.</p>",
+		"<ul><li>a<ul><li>b</li><li>1. c</li></ul></li><li>d</li></ul>",
+		"<ol><li>one</li><li>two<ol><li>nested</li></ol></li></ol>",
+		"<table><thead><tr><th>A</th><th>B</th></tr></thead><tbody><tr><td>1 | 2</td><td>- x</td></tr></tbody></table>",
+		"<pre><code class=\"language-go\"># x\n- y</code></pre>",
+		"<blockquote><p># quoted</p></blockquote>",
+	} {
+		md, err := HTMLToMarkdown(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := MarkdownToHTML(md)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.ReplaceAll(got, "\n", "") != strings.ReplaceAll(src, "\n", "") {
+			t.Errorf("%q converted to %q and back to %q", src, md, got)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package convert
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"*", `\*`,
+	"_", `\_`,
+	"`", "\\`",
+	"[", `\[`,
+	"]", `\]`,
+	"~", `\~`,
+	"<", `\<`,
+	"&", `\&`,
+)
+
+// blockMarker matches text at the start of a line that Markdown would read
+// as the start of a block: a heading, a list item, a quote, or a setext
+// underline. The ordered list delimiter is the second group, the character
+// to escape in every other case the first.
+var blockMarker = regexp.MustCompile(`^( {0,3})(?:(#{1,6}(?:[ \t]|$)|[-+](?:[ \t]|$)|>|=+[ \t]*$)|\d{1,9}([.)])(?:[ \t]|$))`)
+
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Details: true, atom.Div: true, atom.Dl: true, atom.Fieldset: true,
+	atom.Figure: true, atom.Footer: true, atom.Form: true, atom.H1: true, atom.H2: true,
+	atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true, atom.Header: true,
+	atom.Hr: true, atom.Main: true, atom.Nav: true, atom.Ol: true, atom.P: true,
+	atom.Pre: true, atom.Section: true, atom.Table: true, atom.Ul: true, atom.Body: true,
+	atom.Html: true, atom.Summary: true, atom.Figcaption: true,
+}
+
+// HTMLToMarkdown converts an HTML artifact into GitHub flavoured Markdown.
+// The output only depends on the input, so converting the same version twice
+// yields identical documents.
+func HTMLToMarkdown(src string) (string, error) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(src), body)
+	if err != nil {
+		return "", err
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+
+	out := strings.Join(blocks(body), "\n\n")
+	return strings.TrimSpace(out) + "\n", nil
+}
+
+// blocks renders the children of n as a list of Markdown blocks. Runs of
+// inline content between block elements become paragraphs.
+func blocks(n *html.Node) []string {
+	var out []string
+	var run []*html.Node
+
+	flush := func() {
+		text := paragraph(run)
+		if text != "" {
+			out = append(out, text)
+		}
+		run = nil
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (blockElements[c.DataAtom] || c.DataAtom == atom.Li) {
+			flush()
+			if b := block(c); b != "" {
+				out = append(out, b)
+			}
+			continue
+		}
+		run = append(run, c)
+	}
+	flush()
+
+	return out
+}
+
+func block(n *html.Node) string {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		return strings.Repeat("#", level) + " " + strings.TrimSpace(inlines(children(n)))
+	case atom.P, atom.Summary, atom.Figcaption:
+		return paragraph(children(n))
+	case atom.Hr:
+		return "---"
+	case atom.Pre:
+		return codeBlock(n)
+	case atom.Blockquote:
+		return prefixLines(strings.Join(blocks(n), "\n\n"), "> ", "> ")
+	case atom.Ul, atom.Ol:
+		return list(n)
+	case atom.Li:
+		return prefixLines(strings.Join(blocks(n), "\n"), "- ", "  ")
+	case atom.Table:
+		return table(n)
+	case atom.Dl:
+		return definitionList(n)
+	}
+	return strings.Join(blocks(n), "\n\n")
+}
+
+func codeBlock(n *html.Node) string {
+	lang := ""
+	if code := n.FirstChild; code != nil && code.DataAtom == atom.Code {
+		for _, class := range strings.Fields(attr(code, "class")) {
+			if l, ok := strings.CutPrefix(class, "language-"); ok {
+				lang = l
+			}
+		}
+	}
+
+	text := strings.TrimSuffix(textContent(n), "\n")
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	return fence + lang + "\n" + text + "\n" + fence
+}
+
+func list(n *html.Node) string {
+	var items []string
+	ordered := n.DataAtom == atom.Ol
+	number := 1
+	if start := attr(n, "start"); start != "" {
+		fmt.Sscanf(start, "%d", &number)
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+			continue
+		}
+		marker := "- "
+		if ordered {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		content := strings.Join(blocks(c), "\n")
+		items = append(items, prefixLines(content, marker, strings.Repeat(" ", len(marker))))
+	}
+
+	return strings.Join(items, "\n")
+}
+
+func table(n *html.Node) string {
+	var rows [][]string
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.DataAtom {
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				visit(c)
+			case atom.Tr:
+				var row []string
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+						text := strings.TrimSpace(inlines(children(cell)))
+						text = strings.ReplaceAll(text, "|", `\|`)
+						row = append(row, strings.ReplaceAll(text, "\n", " "))
+					}
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+	visit(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+
+	var b strings.Builder
+	writeRow := func(row []string) {
+		b.WriteString("|")
+		for i := 0; i < columns; i++ {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			b.WriteString(" " + cell + " |")
+		}
+		b.WriteString("\n")
+	}
+
+	writeRow(rows[0])
+	b.WriteString("|" + strings.Repeat(" --- |", columns) + "\n")
+	for _, row := range rows[1:] {
+		writeRow(row)
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func definitionList(n *html.Node) string {
+	var out []string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.DataAtom {
+		case atom.Dt:
+			out = append(out, "**"+strings.TrimSpace(inlines(children(c)))+"**")
+		case atom.Dd:
+			out = append(out, prefixLines(strings.Join(blocks(c), "\n\n"), ": ", "  "))
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// paragraph renders nodes as the text of a paragraph, escaping anything at
+// the start of its lines that would make it another kind of block.
+func paragraph(nodes []*html.Node) string {
+	lines := strings.Split(strings.TrimSpace(inlines(nodes)), "\n")
+	for i, line := range lines {
+		m := blockMarker.FindStringSubmatchIndex(line)
+		switch {
+		case m == nil:
+		case m[6] >= 0:
+			lines[i] = line[:m[6]] + `\` + line[m[6]:]
+		default:
+			lines[i] = line[:m[3]] + `\` + line[m[3]:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func inlines(nodes []*html.Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		b.WriteString(inline(n))
+	}
+	return b.String()
+}
+
+func inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return markdownEscaper.Replace(whitespace.ReplaceAllString(n.Data, " "))
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	content := func() string { return inlines(children(n)) }
+
+	switch n.DataAtom {
+	case atom.Strong, atom.B:
+		return wrap(content(), "**")
+	case atom.Em, atom.I:
+		return wrap(content(), "*")
+	case atom.Del, atom.S, atom.Strike:
+		return wrap(content(), "~~")
+	case atom.Code, atom.Kbd:
+		text := textContent(n)
+		fence := "`"
+		for strings.Contains(text, fence) {
+			fence += "`"
+		}
+		return fence + text + fence
+	case atom.A:
+		href := attr(n, "href")
+		if href == "" {
+			return content()
+		}
+		if title := attr(n, "title"); title != "" {
+			return fmt.Sprintf("[%s](%s %q)", strings.TrimSpace(content()), href, title)
+		}
+		return fmt.Sprintf("[%s](%s)", strings.TrimSpace(content()), href)
+	case atom.Img:
+		return fmt.Sprintf("![%s](%s)", markdownEscaper.Replace(attr(n, "alt")), attr(n, "src"))
+	case atom.Br:
+		return "  \n"
+	case atom.Script, atom.Style, atom.Head, atom.Title:
+		return ""
+	}
+	return content()
+}
+
+// wrap puts a Markdown emphasis marker around text, keeping surrounding
+// whitespace outside of the markers where Markdown expects it.
+func wrap(text, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	lead := text[:len(text)-len(strings.TrimLeft(text, " "))]
+	trail := text[len(strings.TrimRight(text, " ")):]
+	return lead + marker + trimmed + marker + trail
+}
+
+func prefixLines(text, first, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" {
+			lines[i] = strings.TrimRight(prefix, " ")
+			continue
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func children(n *html.Node) []*html.Node {
+	var out []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		out = append(out, c)
+	}
+	return out
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
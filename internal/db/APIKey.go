@@ -0,0 +1,92 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"composer/internal/models"
+)
+
+const apiKeyColumns = `id, user_id, COALESCE(workspace_id, ''), name, prefix, key_hash, scopes, last_used_at, expires_at, created_at`
+
+func (d *Db) InsertAPIKey(k *models.APIKey) error {
+	query := `
+	INSERT INTO api_keys (user_id, workspace_id, name, prefix, key_hash, scopes, expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id`
+
+	return d.conn.QueryRow(query, k.UserID, k.WorkspaceID, k.Name, k.Prefix, k.KeyHash, strings.Join(k.Scopes, ","), k.ExpiresAt, k.CreatedAt).Scan(&k.ID)
+}
+
+func (d *Db) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	k, err := scanAPIKey(d.conn.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = $1`, keyHash))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("api key not found")
+	}
+	return k, err
+}
+
+func (d *Db) GetAPIKey(id string) (*models.APIKey, error) {
+	k, err := scanAPIKey(d.conn.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("api key not found")
+	}
+	return k, err
+}
+
+func (d *Db) ListAPIKeys(userID string) ([]*models.APIKey, error) {
+	rows, err := d.conn.Query(`SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = $1 ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey revokes one of a user's keys. It reports whether the key
+// existed.
+func (d *Db) DeleteAPIKey(userID, id string) (bool, error) {
+	result, err := d.conn.Exec(`DELETE FROM api_keys WHERE user_id = $1 AND id = $2`, userID, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (d *Db) TouchAPIKey(id string, at time.Time) error {
+	_, err := d.conn.Exec(`UPDATE api_keys SET last_used_at = $1 WHERE id = $2`, at, id)
+	return err
+}
+
+func scanAPIKey(row interface{ Scan(...any) error }) (*models.APIKey, error) {
+	k := &models.APIKey{}
+	var scopes string
+	var lastUsedAt, expiresAt sql.NullTime
+	err := row.Scan(&k.ID, &k.UserID, &k.WorkspaceID, &k.Name, &k.Prefix, &k.KeyHash, &scopes, &lastUsedAt, &expiresAt, &k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if scopes != "" {
+		k.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	return k, nil
+}
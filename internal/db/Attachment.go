@@ -0,0 +1,71 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"composer/internal/models"
+)
+
+func (d *Db) InsertAttachment(a *models.Attachment) error {
+	query := `
+	INSERT INTO attachments (session_id, filename, content_type, size, blob_key, extracted_text, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id`
+
+	return d.conn.QueryRow(query, a.SessionID, a.Filename, a.ContentType, a.Size, a.BlobKey, a.Text, a.CreatedAt).Scan(&a.ID)
+}
+
+func (d *Db) GetAttachment(sessionID, id string) (*models.Attachment, error) {
+	query := `
+	SELECT id, session_id, filename, content_type, size, blob_key, extracted_text, created_at
+	FROM attachments
+	WHERE session_id = $1 AND id = $2`
+
+	a := &models.Attachment{}
+	err := d.conn.QueryRow(query, sessionID, id).Scan(&a.ID, &a.SessionID, &a.Filename, &a.ContentType, &a.Size, &a.BlobKey, &a.Text, &a.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("attachment not found")
+		}
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (d *Db) ListAttachments(sessionID string) ([]*models.Attachment, error) {
+	query := `
+	SELECT id, session_id, filename, content_type, size, blob_key, extracted_text, created_at
+	FROM attachments
+	WHERE session_id = $1
+	ORDER BY created_at, id`
+
+	rows, err := d.conn.Query(query, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []*models.Attachment
+	for rows.Next() {
+		a := &models.Attachment{}
+		err := rows.Scan(&a.ID, &a.SessionID, &a.Filename, &a.ContentType, &a.Size, &a.BlobKey, &a.Text, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
+
+func (d *Db) DeleteAttachment(sessionID, id string) error {
+	query := `DELETE FROM attachments WHERE session_id = $1 AND id = $2`
+	_, err := d.conn.Exec(query, sessionID, id)
+	return err
+}
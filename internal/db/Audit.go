@@ -0,0 +1,79 @@
+package db
+
+import (
+	"time"
+
+	"composer/internal/models"
+)
+
+// AuditFilter narrows ListAuditEvents. Empty fields match everything, and
+// Visible limits the result to what the caller may audit.
+type AuditFilter struct {
+	ActorID   string
+	Action    string
+	SessionID string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+	Visible   Scope
+}
+
+func (d *Db) InsertAuditEvent(e *models.AuditEvent) error {
+	query := `
+	INSERT INTO audit_events (actor_id, actor_type, credential_id, action, session_id, workspace_id, version, model,
+		prompt_version, input_tokens, output_tokens, detail, ip, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	RETURNING id`
+
+	return d.conn.QueryRow(query, e.ActorID, e.ActorType, e.CredentialID, e.Action, e.SessionID, e.WorkspaceID, e.Version, e.Model,
+		e.PromptVersion, e.InputTokens, e.OutputTokens, e.Detail, e.IP, e.CreatedAt).Scan(&e.ID)
+}
+
+// ListAuditEvents returns matching events, newest first.
+func (d *Db) ListAuditEvents(f AuditFilter) ([]*models.AuditEvent, error) {
+	var q query
+	if f.ActorID != "" {
+		q.where = append(q.where, "actor_id = "+q.arg(f.ActorID))
+	}
+	if f.Action != "" {
+		q.where = append(q.where, "action = "+q.arg(f.Action))
+	}
+	if f.SessionID != "" {
+		q.where = append(q.where, "session_id = "+q.arg(f.SessionID))
+	}
+	q.scope(f.Visible, "actor_id", "session_id", "workspace_id")
+	if !f.Since.IsZero() {
+		q.where = append(q.where, "created_at >= "+q.arg(f.Since.UTC()))
+	}
+	if !f.Until.IsZero() {
+		q.where = append(q.where, "created_at < "+q.arg(f.Until.UTC()))
+	}
+
+	sql := `
+	SELECT id, actor_id, actor_type, credential_id, action, session_id, workspace_id, version, model,
+		prompt_version, input_tokens, output_tokens, detail, ip, created_at
+	FROM audit_events` + q.whereClause() + `
+	ORDER BY created_at DESC, id DESC`
+	if f.Limit > 0 {
+		sql += " LIMIT " + q.arg(f.Limit)
+	}
+
+	rows, err := d.conn.Query(sql, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.AuditEvent
+	for rows.Next() {
+		e := &models.AuditEvent{}
+		err := rows.Scan(&e.ID, &e.ActorID, &e.ActorType, &e.CredentialID, &e.Action, &e.SessionID, &e.WorkspaceID, &e.Version, &e.Model,
+			&e.PromptVersion, &e.InputTokens, &e.OutputTokens, &e.Detail, &e.IP, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"composer/internal/models"
+)
+
+func (d *Db) InsertAuthSession(s *models.AuthSession) error {
+	query := `
+	INSERT INTO auth_sessions (token_hash, user_id, expires_at, created_at)
+	VALUES ($1, $2, $3, $4)`
+
+	_, err := d.conn.Exec(query, s.TokenHash, s.UserID, s.ExpiresAt, s.CreatedAt)
+	return err
+}
+
+func (d *Db) GetAuthSession(tokenHash string) (*models.AuthSession, error) {
+	query := `
+	SELECT token_hash, user_id, expires_at, created_at
+	FROM auth_sessions
+	WHERE token_hash = $1`
+
+	s := &models.AuthSession{}
+	err := d.conn.QueryRow(query, tokenHash).Scan(&s.TokenHash, &s.UserID, &s.ExpiresAt, &s.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("auth session not found")
+		}
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (d *Db) DeleteAuthSession(tokenHash string) error {
+	_, err := d.conn.Exec(`DELETE FROM auth_sessions WHERE token_hash = $1`, tokenHash)
+	return err
+}
@@ -1,20 +1,36 @@
 package db
 
-import "composer/internal/models"
+import (
+	"encoding/json"
+
+	"composer/internal/models"
+)
 
 func (d *Db) InsertChatMessage(msg *models.ChatMessage) error {
+	citations, err := encodeCitations(msg.Citations)
+	if err != nil {
+		return err
+	}
+	guardrails, err := encodeGuardrails(msg.Guardrails)
+	if err != nil {
+		return err
+	}
+
 	query := `
-	INSERT INTO chat_messages (session_id, role, content, doc, diff, selected_text, created_at)
-	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	INSERT INTO chat_messages (session_id, role, content, doc, format, diff, selected_text, citations, guardrails,
+		user_id, model, input_tokens, output_tokens, cost, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
 	RETURNING id`
 
-	err := d.conn.QueryRow(query, msg.SessionID, msg.Role, msg.Content, msg.Doc, msg.Diff, msg.SelectedText, msg.CreatedAt).Scan(&msg.ID)
+	err = d.conn.QueryRow(query, msg.SessionID, msg.Role, msg.Content, msg.Doc, msg.Format, msg.Diff, msg.SelectedText, citations,
+		guardrails, msg.UserID, msg.Model, msg.InputTokens, msg.OutputTokens, msg.Cost, msg.CreatedAt).Scan(&msg.ID)
 	return err
 }
 
 func (d *Db) ListChatMessages(sessionID string) ([]*models.ChatMessage, error) {
 	query := `
-	SELECT id, session_id, role, content, doc, diff, selected_text, created_at 
+	SELECT id, session_id, role, content, doc, COALESCE(format, ''), diff, selected_text, COALESCE(citations, ''), COALESCE(guardrails, ''),
+		COALESCE(user_id, ''), COALESCE(model, ''), COALESCE(input_tokens, 0), COALESCE(output_tokens, 0), COALESCE(cost, 0), created_at
 	FROM chat_messages 
 	WHERE session_id = $1 
 	ORDER BY created_at`
@@ -28,21 +44,70 @@ func (d *Db) ListChatMessages(sessionID string) ([]*models.ChatMessage, error) {
 	var messages []*models.ChatMessage
 	for rows.Next() {
 		msg := &models.ChatMessage{}
+		var citations, guardrails string
 		err := rows.Scan(
 			&msg.ID,
 			&msg.SessionID,
 			&msg.Role,
 			&msg.Content,
 			&msg.Doc,
+			&msg.Format,
 			&msg.Diff,
 			&msg.SelectedText,
+			&citations,
+			&guardrails,
+			&msg.UserID,
+			&msg.Model,
+			&msg.InputTokens,
+			&msg.OutputTokens,
+			&msg.Cost,
 			&msg.CreatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if msg.Citations, err = decodeCitations(citations); err != nil {
+			return nil, err
+		}
+		if msg.Guardrails, err = decodeGuardrails(guardrails); err != nil {
+			return nil, err
+		}
 		messages = append(messages, msg)
 	}
 
 	return messages, nil
 }
+
+func encodeCitations(citations []models.Citation) (string, error) {
+	if len(citations) == 0 {
+		return "", nil
+	}
+	raw, err := json.Marshal(citations)
+	return string(raw), err
+}
+
+func decodeCitations(raw string) ([]models.Citation, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var citations []models.Citation
+	err := json.Unmarshal([]byte(raw), &citations)
+	return citations, err
+}
+
+func encodeGuardrails(findings []models.GuardrailFinding) (string, error) {
+	if len(findings) == 0 {
+		return "", nil
+	}
+	raw, err := json.Marshal(findings)
+	return string(raw), err
+}
+
+func decodeGuardrails(raw string) ([]models.GuardrailFinding, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var findings []models.GuardrailFinding
+	err := json.Unmarshal([]byte(raw), &findings)
+	return findings, err
+}
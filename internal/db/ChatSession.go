@@ -4,15 +4,15 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 
 	"composer/internal/models"
 )
 
 func (d *Db) InsertChatSession(chatSession *models.ChatSession) error {
-	query := `INSERT INTO chat_sessions (title) VALUES (?)`
-	log.Printf("Running query %s", query)
-	result, err := d.conn.Exec(query, chatSession.Title)
+	query := `INSERT INTO chat_sessions (title, user_id, workspace_id) VALUES (?, ?, ?)`
+	slog.Debug("running query", "query", query)
+	result, err := d.conn.Exec(query, chatSession.Title, chatSession.UserID, chatSession.WorkspaceID)
 	if err != nil {
 		return err
 	}
@@ -32,18 +32,71 @@ func (d *Db) UpdateChatSession(chatSession *models.ChatSession) error {
 	return err
 }
 
-func (d *Db) DeleteChatSession(id string) error {
-	query := `DELETE FROM chat_sessions WHERE id = ?`
-	_, err := d.conn.Exec(query, id)
-	return err
+// sessionTables hold rows that belong to a single chat session and go with
+// it. The audit log and usage records outlive the session on purpose, and
+// knowledge bases belong to workspaces.
+var sessionTables = []string{"chat_messages", "attachments", "comments", "share_accesses", "shares", "idempotency_keys"}
+
+// DeleteChatSession deletes a session and everything in it in one
+// transaction. It returns the keys of the attachment blobs it dropped, for the
+// caller to delete from the blob store once the rows are gone.
+func (d *Db) DeleteChatSession(id string) ([]string, error) {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT blob_key FROM attachments WHERE session_id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	var blobKeys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		blobKeys = append(blobKeys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, table := range sessionTables {
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE session_id = $1`, table), id); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM chat_sessions WHERE id = $1`, id); err != nil {
+		return nil, err
+	}
+
+	return blobKeys, tx.Commit()
+}
+
+// ClaimOwnerlessChatSessions gives userID the personal sessions created
+// before sessions had owners, which nobody can otherwise reach, and returns
+// how many there were.
+func (d *Db) ClaimOwnerlessChatSessions(userID string) (int64, error) {
+	query := `
+	UPDATE chat_sessions SET user_id = $1
+	WHERE (user_id IS NULL OR user_id = '') AND (workspace_id IS NULL OR workspace_id = '')`
+	result, err := d.conn.Exec(query, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 func (d *Db) GetChatSession(id string) (*models.ChatSession, error) {
-	query := `SELECT id, title, created_at FROM chat_sessions WHERE id = ?`
+	query := `SELECT id, title, COALESCE(user_id, ''), COALESCE(workspace_id, ''), created_at FROM chat_sessions WHERE id = ?`
 	row := d.conn.QueryRow(query, id)
 
 	var chatSession models.ChatSession
-	err := row.Scan(&chatSession.ID, &chatSession.Title, &chatSession.CreatedAt)
+	err := row.Scan(&chatSession.ID, &chatSession.Title, &chatSession.UserID, &chatSession.WorkspaceID, &chatSession.CreatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.New("chat session not found")
@@ -54,9 +107,16 @@ func (d *Db) GetChatSession(id string) (*models.ChatSession, error) {
 	return &chatSession, nil
 }
 
-func (d *Db) ListChatSessions() ([]models.ChatSession, error) {
-	query := `SELECT id, title, created_at FROM chat_sessions`
-	rows, err := d.conn.Query(query)
+// ListChatSessions returns the sessions a user can see: their personal ones
+// and those in workspaces they belong to. A non-empty workspaceID narrows the list to
+// that workspace.
+func (d *Db) ListChatSessions(userID, workspaceID string) ([]models.ChatSession, error) {
+	query := `
+	SELECT id, title, COALESCE(user_id, ''), COALESCE(workspace_id, ''), created_at
+	FROM chat_sessions
+	WHERE ((user_id = ? AND COALESCE(workspace_id, '') = '') OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))
+	AND (? = '' OR workspace_id = ?)`
+	rows, err := d.conn.Query(query, userID, userID, workspaceID, workspaceID)
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +125,7 @@ func (d *Db) ListChatSessions() ([]models.ChatSession, error) {
 	var chatSessions []models.ChatSession
 	for rows.Next() {
 		var chatSession models.ChatSession
-		err := rows.Scan(&chatSession.ID, &chatSession.Title, &chatSession.CreatedAt)
+		err := rows.Scan(&chatSession.ID, &chatSession.Title, &chatSession.UserID, &chatSession.WorkspaceID, &chatSession.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"composer/internal/models"
+)
+
+func TestDeleteChatSession(t *testing.T) {
+	d, err := New("sqlite3", filepath.Join(t.TempDir(), "composer.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	now := time.Now()
+	var sessions []*models.ChatSession
+	for i, title := range []string{"Deleted", "Kept"} {
+		s := &models.ChatSession{Title: title, UserID: "1"}
+		if err := d.InsertChatSession(s); err != nil {
+			t.Fatal(err)
+		}
+		sessions = append(sessions, s)
+
+		steps := []func() error{
+			func() error {
+				return d.InsertChatMessage(&models.ChatMessage{SessionID: s.ID, Role: "user", Content: "Hi", CreatedAt: now})
+			},
+			func() error {
+				return d.InsertAttachment(&models.Attachment{SessionID: s.ID, Filename: "a.txt", BlobKey: title, CreatedAt: now})
+			},
+			func() error {
+				return d.InsertComment(&models.Comment{SessionID: s.ID, UserID: "1", Body: "Note", CreatedAt: now})
+			},
+			func() error {
+				share := &models.Share{SessionID: s.ID, TokenHash: title, Access: models.RoleViewer, CreatedBy: "1", ExpiresAt: now.Add(time.Hour), CreatedAt: now}
+				if err := d.InsertShare(share); err != nil {
+					return err
+				}
+				return d.InsertShareAccess(&models.ShareAccess{ShareID: share.ID, SessionID: s.ID, Action: "view", CreatedAt: now})
+			},
+		}
+		for _, step := range steps {
+			if err := step(); err != nil {
+				t.Fatalf("session %d: %v", i, err)
+			}
+		}
+	}
+
+	blobKeys, err := d.DeleteChatSession(sessions[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(blobKeys, []string{"Deleted"}) {
+		t.Errorf("DeleteChatSession() blob keys = %v, want [Deleted]", blobKeys)
+	}
+
+	for i, s := range sessions {
+		counts := map[string]int{}
+		for _, table := range append(sessionTables, "chat_sessions") {
+			column := "session_id"
+			if table == "chat_sessions" {
+				column = "id"
+			}
+			var n int
+			if err := d.conn.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE `+column+` = $1`, s.ID).Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+			counts[table] = n
+		}
+		for table, n := range counts {
+			kept := i == 1 && table != "idempotency_keys"
+			if kept && n == 0 || !kept && n != 0 {
+				t.Errorf("%s session: %d rows left in %s", s.Title, n, table)
+			}
+		}
+	}
+}
+
+func TestClaimOwnerlessChatSessions(t *testing.T) {
+	d, err := New("sqlite3", filepath.Join(t.TempDir(), "composer.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// Sessions from before accounts have no user_id at all.
+	for _, query := range []string{
+		`INSERT INTO chat_sessions (title) VALUES ('Old')`,
+		`INSERT INTO chat_sessions (title, user_id) VALUES ('Empty', '')`,
+		`INSERT INTO chat_sessions (title, user_id) VALUES ('Owned', '2')`,
+		`INSERT INTO chat_sessions (title, workspace_id) VALUES ('In a workspace', '3')`,
+	} {
+		if _, err := d.conn.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := d.ClaimOwnerlessChatSessions("1")
+	if err != nil || n != 2 {
+		t.Fatalf("ClaimOwnerlessChatSessions() = %d, %v; want 2", n, err)
+	}
+	want := map[string]string{"Old": "1", "Empty": "1", "Owned": "2", "In a workspace": ""}
+	for id := 1; id <= len(want); id++ {
+		s, err := d.GetChatSession(fmt.Sprint(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.UserID != want[s.Title] {
+			t.Errorf("session %q belongs to %q, want %q", s.Title, s.UserID, want[s.Title])
+		}
+	}
+
+	if n, err := d.ClaimOwnerlessChatSessions("2"); err != nil || n != 0 {
+		t.Errorf("second ClaimOwnerlessChatSessions() = %d, %v; want 0", n, err)
+	}
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"composer/internal/models"
+)
+
+func (d *Db) InsertComment(cm *models.Comment) error {
+	query := `
+	INSERT INTO comments (session_id, user_id, author_name, body, selected_text, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id`
+
+	return d.conn.QueryRow(query, cm.SessionID, cm.UserID, cm.AuthorName, cm.Body, cm.SelectedText, cm.CreatedAt).Scan(&cm.ID)
+}
+
+func (d *Db) GetComment(sessionID, id string) (*models.Comment, error) {
+	query := `
+	SELECT id, session_id, user_id, COALESCE(author_name, ''), body, selected_text, created_at
+	FROM comments
+	WHERE session_id = $1 AND id = $2`
+
+	cm := &models.Comment{}
+	err := d.conn.QueryRow(query, sessionID, id).Scan(&cm.ID, &cm.SessionID, &cm.UserID, &cm.AuthorName, &cm.Body, &cm.SelectedText, &cm.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("comment not found")
+		}
+		return nil, err
+	}
+
+	return cm, nil
+}
+
+func (d *Db) ListComments(sessionID string) ([]*models.Comment, error) {
+	query := `
+	SELECT id, session_id, user_id, COALESCE(author_name, ''), body, selected_text, created_at
+	FROM comments
+	WHERE session_id = $1
+	ORDER BY created_at, id`
+
+	rows, err := d.conn.Query(query, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*models.Comment
+	for rows.Next() {
+		cm := &models.Comment{}
+		if err := rows.Scan(&cm.ID, &cm.SessionID, &cm.UserID, &cm.AuthorName, &cm.Body, &cm.SelectedText, &cm.CreatedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, cm)
+	}
+
+	return comments, rows.Err()
+}
+
+func (d *Db) DeleteComment(sessionID, id string) error {
+	_, err := d.conn.Exec(`DELETE FROM comments WHERE session_id = $1 AND id = $2`, sessionID, id)
+	return err
+}
@@ -0,0 +1,41 @@
+package db
+
+import "composer/internal/models"
+
+// ListArtifactVersions returns every artifact recorded in a session, oldest
+// first. Each chat message carrying a document is one version, numbered from
+// 1 in the same order the UI's version picker uses.
+func (d *Db) ListArtifactVersions(sessionID string) ([]*models.Document, error) {
+	query := `
+	SELECT id, role, doc, COALESCE(format, ''), COALESCE(citations, ''), created_at
+	FROM chat_messages
+	WHERE session_id = $1 AND doc IS NOT NULL AND doc != ''
+	ORDER BY created_at, id`
+
+	rows, err := d.conn.Query(query, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []*models.Document
+	for rows.Next() {
+		doc := &models.Document{Version: len(versions) + 1}
+		var citations string
+		err := rows.Scan(&doc.ChatMessageID, &doc.LastModifiedBy, &doc.Contents, &doc.Format, &citations, &doc.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if doc.Citations, err = decodeCitations(citations); err != nil {
+			return nil, err
+		}
+		doc.ID = doc.ChatMessageID
+		versions = append(versions, doc)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
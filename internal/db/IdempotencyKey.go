@@ -0,0 +1,75 @@
+package db
+
+import (
+	"time"
+
+	"composer/internal/models"
+)
+
+// ClaimIdempotencyKey returns a user's key, recording it first if it is new.
+// claimed reports whether the caller now holds the key and should run the
+// request: it was new, or an earlier attempt with the same request hash
+// failed or let its lock expire. Until lockUntil, other claims of the key
+// get claimed == false.
+func (d *Db) ClaimIdempotencyKey(userID, key, requestHash string, now, lockUntil time.Time) (k *models.IdempotencyKey, claimed bool, err error) {
+	k = &models.IdempotencyKey{UserID: userID, Key: key, RequestHash: requestHash, CreatedAt: now}
+	result, err := d.conn.Exec(`
+	INSERT INTO idempotency_keys (user_id, key, request_hash, locked_until, created_at)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (user_id, key) DO NOTHING`, userID, key, requestHash, lockUntil.UTC(), now.UTC())
+	if err != nil {
+		return nil, false, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, false, err
+	} else if n == 1 {
+		return k, true, nil
+	}
+
+	// Taken over from an attempt that is no longer running, compare-and-set
+	// like ClaimJob so that only one retry wins.
+	result, err = d.conn.Exec(`
+	UPDATE idempotency_keys SET locked_until = $1
+	WHERE user_id = $2 AND key = $3 AND request_hash = $4 AND response_status = 0
+		AND (locked_until IS NULL OR locked_until < $5)`,
+		lockUntil.UTC(), userID, key, requestHash, now.UTC())
+	if err != nil {
+		return nil, false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var response string
+	err = d.conn.QueryRow(`
+	SELECT session_id, request_hash, response_status, response, created_at FROM idempotency_keys WHERE user_id = $1 AND key = $2`,
+		userID, key).Scan(&k.SessionID, &k.RequestHash, &k.ResponseStatus, &response, &k.CreatedAt)
+	if err != nil {
+		return nil, false, err
+	}
+	k.Response = []byte(response)
+	return k, n == 1, nil
+}
+
+// SetIdempotencySession records the session a request created.
+func (d *Db) SetIdempotencySession(k *models.IdempotencyKey) error {
+	_, err := d.conn.Exec(`UPDATE idempotency_keys SET session_id = $1 WHERE user_id = $2 AND key = $3`,
+		k.SessionID, k.UserID, k.Key)
+	return err
+}
+
+// FinishIdempotencyKey records the response to a request.
+func (d *Db) FinishIdempotencyKey(k *models.IdempotencyKey) error {
+	_, err := d.conn.Exec(`UPDATE idempotency_keys SET response_status = $1, response = $2, locked_until = NULL WHERE user_id = $3 AND key = $4`,
+		k.ResponseStatus, string(k.Response), k.UserID, k.Key)
+	return err
+}
+
+// ReleaseIdempotencyKey gives up a claim without a response, so that the
+// request can be retried at once.
+func (d *Db) ReleaseIdempotencyKey(k *models.IdempotencyKey) error {
+	_, err := d.conn.Exec(`UPDATE idempotency_keys SET locked_until = NULL WHERE user_id = $1 AND key = $2 AND response_status = 0`,
+		k.UserID, k.Key)
+	return err
+}
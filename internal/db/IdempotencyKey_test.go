@@ -0,0 +1,84 @@
+package db
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	d, err := New("sqlite3", filepath.Join(t.TempDir(), "composer.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	now := time.Now()
+	lock := now.Add(time.Minute)
+
+	// Of concurrent requests with the same key, one runs.
+	var claims atomic.Int32
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, claimed, err := d.ClaimIdempotencyKey("1", "k", "h", now, lock)
+			if err != nil {
+				t.Error(err)
+			}
+			if claimed {
+				claims.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := claims.Load(); n != 1 {
+		t.Fatalf("%d concurrent claims succeeded, want 1", n)
+	}
+
+	k, claimed, err := d.ClaimIdempotencyKey("1", "k", "other", now, lock)
+	if err != nil || claimed || k.RequestHash != "h" {
+		t.Fatalf("claim with another request = %+v, %v, %v; want the first request's hash, unclaimed", k, claimed, err)
+	}
+
+	// A released key, or one whose lock expired, can be taken over, but not
+	// with another request.
+	k.SessionID = "7"
+	if err := d.SetIdempotencySession(k); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ReleaseIdempotencyKey(k); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed, _ := d.ClaimIdempotencyKey("1", "k", "other", now, lock); claimed {
+		t.Fatal("a released key was claimed with another request")
+	}
+	k, claimed, err = d.ClaimIdempotencyKey("1", "k", "h", now, lock)
+	if err != nil || !claimed || k.SessionID != "7" {
+		t.Fatalf("claim of a released key = %+v, %v, %v; want it claimed with session 7", k, claimed, err)
+	}
+	if _, claimed, _ := d.ClaimIdempotencyKey("1", "k", "h", now, lock); claimed {
+		t.Fatal("a locked key was claimed twice")
+	}
+	if _, claimed, _ := d.ClaimIdempotencyKey("1", "k", "h", lock.Add(time.Second), lock.Add(time.Hour)); !claimed {
+		t.Fatal("a key whose lock expired wasn't claimed")
+	}
+
+	// Once finished, it is never claimed again.
+	k.ResponseStatus, k.Response = 200, []byte(`{"ok":true}`)
+	if err := d.FinishIdempotencyKey(k); err != nil {
+		t.Fatal(err)
+	}
+	k, claimed, err = d.ClaimIdempotencyKey("1", "k", "h", lock.Add(time.Hour), lock.Add(2*time.Hour))
+	if err != nil || claimed || k.ResponseStatus != 200 || string(k.Response) != `{"ok":true}` {
+		t.Fatalf("claim of a finished key = %+v, %v, %v; want its response, unclaimed", k, claimed, err)
+	}
+
+	// Keys are per user.
+	if _, claimed, _ := d.ClaimIdempotencyKey("2", "k", "h", now, lock); !claimed {
+		t.Fatal("another user's key was taken")
+	}
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"composer/internal/metrics"
+	"composer/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithContext returns a Db whose statements are traced as part of ctx, which
+// is usually the request's. Cancelling ctx doesn't cancel the statements, so
+// that work is still saved when a client goes away mid-response.
+func (d *Db) WithContext(ctx context.Context) *Db {
+	conn := d.conn
+	conn.ctx = context.WithoutCancel(ctx)
+	return &Db{conn: conn}
+}
+
+// instrumentedConn is a *sql.DB that traces and times each statement.
+type instrumentedConn struct {
+	*sql.DB
+	system attribute.KeyValue
+	ctx    context.Context
+}
+
+func (c instrumentedConn) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Query's span and timing end when the statement returns its first rows, not
+// when the caller has read them all, so they leave out the time spent
+// streaming a large result.
+func (c instrumentedConn) Query(query string, args ...any) (*sql.Rows, error) {
+	ctx, done := c.start(c.context(), query)
+	rows, err := c.DB.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
+
+func (c instrumentedConn) QueryRow(query string, args ...any) *sql.Row {
+	ctx, done := c.start(c.context(), query)
+	row := c.DB.QueryRowContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
+
+func (c instrumentedConn) Exec(query string, args ...any) (sql.Result, error) {
+	ctx, done := c.start(c.context(), query)
+	result, err := c.DB.ExecContext(ctx, query, args...)
+	done(err)
+	return result, err
+}
+
+func (c instrumentedConn) Begin() (instrumentedTx, error) {
+	tx, err := c.DB.BeginTx(c.context(), nil)
+	return instrumentedTx{Tx: tx, conn: c}, err
+}
+
+type instrumentedTx struct {
+	*sql.Tx
+	conn instrumentedConn
+}
+
+func (t instrumentedTx) Query(query string, args ...any) (*sql.Rows, error) {
+	ctx, done := t.conn.start(t.conn.context(), query)
+	rows, err := t.Tx.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
+
+func (t instrumentedTx) QueryRow(query string, args ...any) *sql.Row {
+	ctx, done := t.conn.start(t.conn.context(), query)
+	row := t.Tx.QueryRowContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
+
+func (t instrumentedTx) Exec(query string, args ...any) (sql.Result, error) {
+	ctx, done := t.conn.start(t.conn.context(), query)
+	result, err := t.Tx.ExecContext(ctx, query, args...)
+	done(err)
+	return result, err
+}
+
+// start starts a span for a statement. The returned function ends it and
+// records how long the statement took.
+func (c instrumentedConn) start(ctx context.Context, query string) (context.Context, func(error)) {
+	l := statementLabelsOf(query)
+	name := l.operation
+	if l.table != "" {
+		name += " " + l.table
+	}
+	ctx, span := tracing.Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			c.system,
+			semconv.DBOperationName(l.operation),
+			semconv.DBCollectionName(l.table),
+			semconv.DBQueryText(strings.TrimSpace(query)),
+		),
+	)
+	start := time.Now()
+	return ctx, func(err error) {
+		metrics.DBQuery(l.operation, l.table, time.Since(start))
+		if !errors.Is(err, sql.ErrNoRows) {
+			tracing.Fail(span, err)
+		}
+		span.End()
+	}
+}
+
+var tablePattern = regexp.MustCompile(`(?i)\b(?:from|into|update)\s+([a-z_]+)`)
+
+type statementLabels struct {
+	operation, table string
+}
+
+// statementLabelsOf works the labels out for every statement. They aren't
+// cached by query text: statements with IN lists have as many texts as there
+// are list lengths, and parsing costs little next to the round trip.
+func statementLabelsOf(query string) statementLabels {
+	l := statementLabels{operation: "other"}
+	query = strings.TrimSpace(query)
+	if end := strings.IndexFunc(query, unicode.IsSpace); end > 0 {
+		l.operation = strings.ToLower(query[:end])
+	} else if query != "" {
+		l.operation = strings.ToLower(query)
+	}
+	if m := tablePattern.FindStringSubmatch(query); m != nil {
+		l.table = strings.ToLower(m[1])
+	}
+	return l
+}
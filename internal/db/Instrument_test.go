@@ -0,0 +1,23 @@
+package db
+
+import "testing"
+
+func TestStatementLabelsOf(t *testing.T) {
+	tests := []struct {
+		query string
+		want  statementLabels
+	}{
+		{"SELECT id FROM chat_sessions WHERE id = $1", statementLabels{"select", "chat_sessions"}},
+		{"\n\tINSERT INTO comments (body) VALUES ($1)", statementLabels{"insert", "comments"}},
+		{"UPDATE Jobs SET status = $1", statementLabels{"update", "jobs"}},
+		{"DELETE FROM shares WHERE id IN ($1, $2, $3)", statementLabels{"delete", "shares"}},
+		{"PRAGMA table_info(users)", statementLabels{"pragma", ""}},
+		{"COMMIT", statementLabels{"commit", ""}},
+		{"", statementLabels{"other", ""}},
+	}
+	for _, tt := range tests {
+		if got := statementLabelsOf(tt.query); got != tt.want {
+			t.Errorf("statementLabelsOf(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
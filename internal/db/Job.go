@@ -0,0 +1,188 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"composer/internal/models"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+const jobColumns = `id, kind, status, user_id, api_key_id, input, request_method, request_path, request_content_type,
+	request_body_key, attempts, max_attempts, error, webhook_url, webhook_secret, result_status, result_type, result_key, lease_owner,
+	lease_expires_at, run_after, created_at, started_at, finished_at`
+
+func (d *Db) InsertJob(j *models.Job) error {
+	query := `
+	INSERT INTO jobs (kind, status, user_id, api_key_id, input, request_method, request_path, request_content_type,
+		request_body_key, max_attempts, webhook_url, webhook_secret, run_after, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	RETURNING id`
+
+	r := j.Request
+	return d.conn.QueryRow(query, j.Kind, j.Status, j.UserID, j.APIKeyID, string(j.Input), r.Method, r.Path, r.ContentType,
+		r.BodyKey, j.MaxAttempts, j.WebhookURL, j.WebhookSecret, j.RunAfter.UTC(), j.CreatedAt.UTC()).Scan(&j.ID)
+}
+
+func (d *Db) GetJob(id string) (*models.Job, error) {
+	j, err := scanJob(d.conn.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	return j, err
+}
+
+// ListJobs returns a user's most recent jobs, newest first.
+func (d *Db) ListJobs(userID string, limit int) ([]*models.Job, error) {
+	rows, err := d.conn.Query(`SELECT `+jobColumns+` FROM jobs WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*models.Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// ClaimJob leases the oldest job that is due, or whose lease has expired
+// because its worker went away, to owner until leaseUntil. It returns nil if
+// no job is due.
+//
+// Claims are compare-and-set, so that workers sharing the database never
+// lease the same job, without needing row locks SQLite doesn't have.
+func (d *Db) ClaimJob(owner string, now, leaseUntil time.Time) (*models.Job, error) {
+	now = now.UTC()
+	for {
+		var id string
+		err := d.conn.QueryRow(`
+		SELECT id FROM jobs
+		WHERE (status = $1 AND run_after <= $2) OR (status = $3 AND lease_expires_at < $2 AND attempts < max_attempts)
+		ORDER BY run_after, id LIMIT 1`,
+			models.JobQueued, now, models.JobRunning).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := d.conn.Exec(`
+		UPDATE jobs
+		SET status = $1, lease_owner = $2, lease_expires_at = $3, attempts = attempts + 1, started_at = COALESCE(started_at, $4)
+		WHERE id = $5 AND ((status = $6 AND run_after <= $4) OR (status = $1 AND lease_expires_at < $4 AND attempts < max_attempts))`,
+			models.JobRunning, owner, leaseUntil.UTC(), now, id, models.JobQueued)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return nil, err
+		} else if n == 1 {
+			return d.GetJob(id)
+		}
+		// Another worker claimed it first.
+	}
+}
+
+// ExtendJobLease keeps a running job leased to owner. It reports false if
+// the lease was lost, after expiring and being claimed by another worker.
+func (d *Db) ExtendJobLease(id, owner string, leaseUntil time.Time) (bool, error) {
+	result, err := d.conn.Exec(`UPDATE jobs SET lease_expires_at = $1 WHERE id = $2 AND lease_owner = $3 AND status = $4`,
+		leaseUntil.UTC(), id, owner, models.JobRunning)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// FinishJob records the outcome of the attempt owner made at j: its status,
+// error, result and, when queued again, when to retry. It reports false if
+// the lease was lost in the meantime.
+func (d *Db) FinishJob(j *models.Job, owner string) (bool, error) {
+	var finishedAt *time.Time
+	if j.Done() {
+		now := time.Now().UTC()
+		finishedAt = &now
+	}
+	result, err := d.conn.Exec(`
+	UPDATE jobs
+	SET status = $1, error = $2, result_status = $3, result_type = $4, result_key = $5, run_after = $6,
+		finished_at = $7, lease_owner = '', lease_expires_at = NULL
+	WHERE id = $8 AND lease_owner = $9 AND status = $10`,
+		j.Status, j.Error, j.ResultStatus, j.ResultType, j.ResultKey, j.RunAfter.UTC(), finishedAt, j.ID, owner, models.JobRunning)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if n == 1 {
+		j.FinishedAt = finishedAt
+	}
+	return n == 1, err
+}
+
+// FailExhaustedJobs fails running jobs whose lease expired on their last
+// attempt, which ClaimJob would otherwise run again.
+func (d *Db) FailExhaustedJobs(now time.Time) ([]*models.Job, error) {
+	now = now.UTC()
+	rows, err := d.conn.Query(`SELECT `+jobColumns+` FROM jobs WHERE status = $1 AND lease_expires_at < $2 AND attempts >= max_attempts`,
+		models.JobRunning, now)
+	if err != nil {
+		return nil, err
+	}
+	var expired []*models.Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		expired = append(expired, j)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var failed []*models.Job
+	for _, j := range expired {
+		j.Status, j.Error = models.JobFailed, "the worker running the last attempt stopped"
+		if ok, err := d.FinishJob(j, j.LeaseOwner); err != nil {
+			return failed, err
+		} else if ok {
+			failed = append(failed, j)
+		}
+	}
+	return failed, nil
+}
+
+func scanJob(row interface{ Scan(...any) error }) (*models.Job, error) {
+	j := &models.Job{}
+	var input string
+	var leaseExpiresAt, startedAt, finishedAt sql.NullTime
+	err := row.Scan(&j.ID, &j.Kind, &j.Status, &j.UserID, &j.APIKeyID, &input, &j.Request.Method, &j.Request.Path,
+		&j.Request.ContentType, &j.Request.BodyKey, &j.Attempts, &j.MaxAttempts, &j.Error, &j.WebhookURL, &j.WebhookSecret, &j.ResultStatus,
+		&j.ResultType, &j.ResultKey, &j.LeaseOwner, &leaseExpiresAt, &j.RunAfter, &j.CreatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	j.Input = []byte(input)
+	if leaseExpiresAt.Valid {
+		j.LeaseExpiresAt = &leaseExpiresAt.Time
+	}
+	if startedAt.Valid {
+		j.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return j, nil
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"composer/internal/models"
+)
+
+func TestClaimJobContention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "composer.db")
+	// Two connection pools, like two instances sharing the database.
+	var instances []*Db
+	for range 2 {
+		d, err := New("sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { d.Close() })
+		instances = append(instances, d)
+	}
+
+	const jobs = 40
+	now := time.Now()
+	for i := range jobs {
+		j := &models.Job{
+			Kind:        "generate",
+			Status:      models.JobQueued,
+			UserID:      "1",
+			Input:       []byte("{}"),
+			MaxAttempts: 3,
+			RunAfter:    now.Add(-time.Minute),
+			CreatedAt:   now,
+		}
+		if err := instances[i%2].InsertJob(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	claims := map[string][]string{}
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			owner := fmt.Sprintf("worker-%d", w)
+			for {
+				j, err := instances[w%2].ClaimJob(owner, now, now.Add(time.Minute))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if j == nil {
+					return
+				}
+				if j.LeaseOwner != owner || j.Status != models.JobRunning || j.Attempts != 1 {
+					t.Errorf("claimed job %s: owner %s, status %s, attempts %d", j.ID, j.LeaseOwner, j.Status, j.Attempts)
+				}
+				mu.Lock()
+				claims[j.ID] = append(claims[j.ID], owner)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claims) != jobs {
+		t.Errorf("%d jobs claimed, want %d", len(claims), jobs)
+	}
+	for id, owners := range claims {
+		if len(owners) != 1 {
+			t.Errorf("job %s claimed by %v, want one worker", id, owners)
+		}
+	}
+
+	// Leases that haven't expired stay with their owner.
+	if j, err := instances[0].ClaimJob("late", now.Add(30*time.Second), now.Add(time.Minute)); err != nil || j != nil {
+		t.Fatalf("ClaimJob() before the leases expired = %v, %v; want nothing", j, err)
+	}
+	// Expired ones are taken over, once.
+	later := now.Add(2 * time.Minute)
+	j, err := instances[1].ClaimJob("late", later, later.Add(time.Minute))
+	if err != nil || j == nil {
+		t.Fatalf("ClaimJob() after the leases expired = %v, %v; want a job", j, err)
+	}
+	if j.Attempts != 2 || j.LeaseOwner != "late" {
+		t.Errorf("reclaimed job: attempts %d, owner %s; want 2, late", j.Attempts, j.LeaseOwner)
+	}
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"composer/internal/models"
+)
+
+// ReplaceKnowledgeSource stores the chunks of one knowledge base source,
+// dropping whatever was previously ingested from it.
+func (d *Db) ReplaceKnowledgeSource(workspace, source string, chunks []*models.KnowledgeChunk) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM knowledge_chunks WHERE workspace = $1 AND source = $2`, workspace, source)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO knowledge_chunks (workspace, source, chunk_index, content, metadata, embedding, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id`
+
+	for _, chunk := range chunks {
+		metadata, err := json.Marshal(chunk.Metadata)
+		if err != nil {
+			return err
+		}
+		var embedding bytes.Buffer
+		if err := binary.Write(&embedding, binary.LittleEndian, chunk.Embedding); err != nil {
+			return err
+		}
+		if chunk.CreatedAt.IsZero() {
+			chunk.CreatedAt = time.Now()
+		}
+
+		err = tx.QueryRow(query, workspace, source, chunk.ChunkIndex, chunk.Content, string(metadata), embedding.Bytes(), chunk.CreatedAt).Scan(&chunk.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *Db) ListKnowledgeChunks(workspace string) ([]*models.KnowledgeChunk, error) {
+	query := `
+	SELECT id, workspace, source, chunk_index, content, metadata, embedding, created_at
+	FROM knowledge_chunks
+	WHERE workspace = $1
+	ORDER BY source, chunk_index`
+
+	rows, err := d.conn.Query(query, workspace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []*models.KnowledgeChunk
+	for rows.Next() {
+		chunk := &models.KnowledgeChunk{}
+		var metadata string
+		var embedding []byte
+		err := rows.Scan(&chunk.ID, &chunk.Workspace, &chunk.Source, &chunk.ChunkIndex, &chunk.Content, &metadata, &embedding, &chunk.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(metadata), &chunk.Metadata); err != nil {
+			return nil, err
+		}
+		chunk.Embedding = make([]float32, len(embedding)/4)
+		if err := binary.Read(bytes.NewReader(embedding), binary.LittleEndian, chunk.Embedding); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return chunks, nil
+}
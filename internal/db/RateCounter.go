@@ -0,0 +1,63 @@
+package db
+
+import "time"
+
+// AddRateCounter adds n to a rate limiting counter and returns its new value.
+// Counters are identified by a key and the start of their window.
+func (d *Db) AddRateCounter(key string, windowStart time.Time, n int) (int, error) {
+	query := `
+	INSERT INTO rate_counters (key, window_start, count)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (key, window_start) DO UPDATE SET count = rate_counters.count + excluded.count
+	RETURNING count`
+
+	var count int
+	err := d.conn.QueryRow(query, key, windowStart.UTC(), n).Scan(&count)
+	return count, err
+}
+
+func (d *Db) GetRateCounter(key string, windowStart time.Time) (int, error) {
+	var count int
+	err := d.conn.QueryRow(`SELECT COALESCE(SUM(count), 0) FROM rate_counters WHERE key = $1 AND window_start = $2`, key, windowStart.UTC()).Scan(&count)
+	return count, err
+}
+
+// PruneRateCounters deletes counters of windows that started before cutoff.
+func (d *Db) PruneRateCounters(cutoff time.Time) error {
+	_, err := d.conn.Exec(`DELETE FROM rate_counters WHERE window_start < $1`, cutoff.UTC())
+	return err
+}
+
+// HoldRateSlot holds the concurrency slot id of key until expires, or extends
+// it, and returns how many of key's slots are held at now, this one included.
+// Slots that have expired, left by an instance that stopped mid-request, are
+// dropped.
+func (d *Db) HoldRateSlot(key, id string, now, expires time.Time) (int, error) {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	INSERT INTO rate_slots (key, id, expires_at)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (key, id) DO UPDATE SET expires_at = excluded.expires_at`, key, id, expires.UTC())
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM rate_slots WHERE key = $1 AND expires_at <= $2`, key, now.UTC()); err != nil {
+		return 0, err
+	}
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM rate_slots WHERE key = $1`, key).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, tx.Commit()
+}
+
+func (d *Db) ReleaseRateSlot(key, id string) error {
+	_, err := d.conn.Exec(`DELETE FROM rate_slots WHERE key = $1 AND id = $2`, key, id)
+	return err
+}
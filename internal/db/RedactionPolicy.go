@@ -0,0 +1,53 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"composer/internal/models"
+)
+
+// GetRedactionPolicy returns a workspace's policy, or nil if it has none.
+func (d *Db) GetRedactionPolicy(workspaceID string) (*models.RedactionPolicy, error) {
+	query := `
+	SELECT workspace_id, enabled, detectors, patterns, updated_at
+	FROM redaction_policies
+	WHERE workspace_id = $1`
+
+	p := &models.RedactionPolicy{}
+	var detectors, patterns string
+	err := d.conn.QueryRow(query, workspaceID).Scan(&p.WorkspaceID, &p.Enabled, &detectors, &patterns, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if detectors != "" {
+		p.Detectors = strings.Split(detectors, ",")
+	}
+	if patterns != "" {
+		if err := json.Unmarshal([]byte(patterns), &p.Patterns); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (d *Db) SetRedactionPolicy(p *models.RedactionPolicy) error {
+	patterns, err := json.Marshal(p.Patterns)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO redaction_policies (workspace_id, enabled, detectors, patterns, updated_at)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (workspace_id) DO UPDATE SET
+		enabled = excluded.enabled, detectors = excluded.detectors, patterns = excluded.patterns, updated_at = excluded.updated_at`
+
+	_, err = d.conn.Exec(query, p.WorkspaceID, p.Enabled, strings.Join(p.Detectors, ","), string(patterns), p.UpdatedAt)
+	return err
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scope limits a query to what a user may see: rows they caused, or that
+// belong to one of the listed sessions or workspaces. An empty Scope matches
+// nothing, so that forgetting to fill one in can't expose every row; All
+// matches everything, for operators' exports.
+type Scope struct {
+	All          bool
+	UserID       string
+	SessionIDs   []string
+	WorkspaceIDs []string
+}
+
+// query builds WHERE clauses with numbered placeholders.
+type query struct {
+	where []string
+	args  []any
+}
+
+func (q *query) arg(v any) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+func (q *query) in(column string, values []string) string {
+	placeholders := make([]string, len(values))
+	for i, v := range values {
+		placeholders[i] = q.arg(v)
+	}
+	return column + " IN (" + strings.Join(placeholders, ", ") + ")"
+}
+
+// scope adds s as one condition on the given columns, combining its parts
+// with OR.
+func (q *query) scope(s Scope, userColumn, sessionColumn, workspaceColumn string) {
+	if s.All {
+		return
+	}
+	var parts []string
+	if s.UserID != "" {
+		parts = append(parts, userColumn+" = "+q.arg(s.UserID))
+	}
+	if len(s.SessionIDs) > 0 {
+		parts = append(parts, q.in(sessionColumn, s.SessionIDs))
+	}
+	if len(s.WorkspaceIDs) > 0 {
+		parts = append(parts, q.in(workspaceColumn, s.WorkspaceIDs))
+	}
+	if len(parts) == 0 {
+		q.where = append(q.where, "1 = 0")
+		return
+	}
+	q.where = append(q.where, "("+strings.Join(parts, " OR ")+")")
+}
+
+func (q *query) whereClause() string {
+	if len(q.where) == 0 {
+		return ""
+	}
+	return "\n\tWHERE " + strings.Join(q.where, " AND ")
+}
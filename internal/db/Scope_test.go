@@ -0,0 +1,35 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueryScope(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope Scope
+		where []string
+		args  []any
+	}{
+		{"empty matches nothing", Scope{}, []string{"1 = 0"}, nil},
+		{"all matches everything", Scope{All: true, UserID: "1"}, nil, nil},
+		{"user", Scope{UserID: "1"}, []string{"(u = $1)"}, []any{"1"}},
+		{
+			"everything",
+			Scope{UserID: "1", SessionIDs: []string{"2", "3"}, WorkspaceIDs: []string{"4"}},
+			[]string{"(u = $1 OR s IN ($2, $3) OR w IN ($4))"},
+			[]any{"1", "2", "3", "4"},
+		},
+		{"workspaces only", Scope{WorkspaceIDs: []string{"4"}}, []string{"(w IN ($1))"}, []any{"4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var q query
+			q.scope(tt.scope, "u", "s", "w")
+			if !reflect.DeepEqual(q.where, tt.where) || !reflect.DeepEqual(q.args, tt.args) {
+				t.Errorf("scope(%+v) = %q %v, want %q %v", tt.scope, q.where, q.args, tt.where, tt.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"composer/internal/models"
+)
+
+const shareColumns = `id, session_id, token_hash, access, created_by, expires_at, revoked_at, created_at`
+
+func (d *Db) InsertShare(s *models.Share) error {
+	query := `
+	INSERT INTO shares (session_id, token_hash, access, created_by, expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id`
+
+	return d.conn.QueryRow(query, s.SessionID, s.TokenHash, s.Access, s.CreatedBy, s.ExpiresAt, s.CreatedAt).Scan(&s.ID)
+}
+
+func (d *Db) GetShareByToken(tokenHash string) (*models.Share, error) {
+	row := d.conn.QueryRow(`SELECT `+shareColumns+` FROM shares WHERE token_hash = $1`, tokenHash)
+	s, err := scanShare(row)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("share not found")
+	}
+	return s, err
+}
+
+func (d *Db) GetShare(sessionID, id string) (*models.Share, error) {
+	row := d.conn.QueryRow(`SELECT `+shareColumns+` FROM shares WHERE session_id = $1 AND id = $2`, sessionID, id)
+	s, err := scanShare(row)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("share not found")
+	}
+	return s, err
+}
+
+func (d *Db) ListShares(sessionID string) ([]*models.Share, error) {
+	rows, err := d.conn.Query(`SELECT `+shareColumns+` FROM shares WHERE session_id = $1 ORDER BY created_at, id`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []*models.Share
+	for rows.Next() {
+		s, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+
+	return shares, rows.Err()
+}
+
+func (d *Db) RevokeShare(s *models.Share, at time.Time) error {
+	_, err := d.conn.Exec(`UPDATE shares SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`, at, s.ID)
+	if err == nil && s.RevokedAt == nil {
+		s.RevokedAt = &at
+	}
+	return err
+}
+
+func scanShare(row interface{ Scan(...any) error }) (*models.Share, error) {
+	s := &models.Share{}
+	var revokedAt sql.NullTime
+	err := row.Scan(&s.ID, &s.SessionID, &s.TokenHash, &s.Access, &s.CreatedBy, &s.ExpiresAt, &revokedAt, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		s.RevokedAt = &revokedAt.Time
+	}
+	return s, nil
+}
+
+func (d *Db) InsertShareAccess(a *models.ShareAccess) error {
+	query := `
+	INSERT INTO share_accesses (share_id, session_id, action, ip, user_agent, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id`
+
+	return d.conn.QueryRow(query, a.ShareID, a.SessionID, a.Action, a.IP, a.UserAgent, a.CreatedAt).Scan(&a.ID)
+}
+
+func (d *Db) ListShareAccesses(shareID string) ([]*models.ShareAccess, error) {
+	query := `
+	SELECT id, share_id, session_id, action, ip, user_agent, created_at
+	FROM share_accesses
+	WHERE share_id = $1
+	ORDER BY created_at, id`
+
+	rows, err := d.conn.Query(query, shareID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accesses []*models.ShareAccess
+	for rows.Next() {
+		a := &models.ShareAccess{}
+		if err := rows.Scan(&a.ID, &a.ShareID, &a.SessionID, &a.Action, &a.IP, &a.UserAgent, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		accesses = append(accesses, a)
+	}
+
+	return accesses, rows.Err()
+}
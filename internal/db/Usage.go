@@ -0,0 +1,76 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"composer/internal/models"
+)
+
+// usageGroups maps the groupings SumUsage accepts to their columns.
+var usageGroups = map[string]string{
+	"user":      "user_id",
+	"api_key":   "api_key_id",
+	"session":   "session_id",
+	"model":     "model",
+	"workspace": "workspace_id",
+}
+
+func (d *Db) InsertUsage(u *models.UsageRecord) error {
+	query := `
+	INSERT INTO usage_records (user_id, api_key_id, session_id, workspace_id, model, input_tokens, output_tokens, cost, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id`
+
+	return d.conn.QueryRow(query, u.UserID, u.APIKeyID, u.SessionID, u.WorkspaceID, u.Model, u.InputTokens, u.OutputTokens,
+		u.Cost, u.CreatedAt.UTC()).Scan(&u.ID)
+}
+
+type UsageFilter struct {
+	GroupBy string
+	From    time.Time
+	To      time.Time
+	Visible Scope
+}
+
+// SumUsage totals the token counts and cost of model calls per group,
+// largest cost first. Calls are counted towards the workspace they were made
+// in, even once their session is deleted.
+func (d *Db) SumUsage(f UsageFilter) ([]*models.UsageTotal, error) {
+	key, ok := usageGroups[f.GroupBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown grouping %q", f.GroupBy)
+	}
+
+	q := query{}
+	q.scope(f.Visible, "user_id", "session_id", "workspace_id")
+	if !f.From.IsZero() {
+		q.where = append(q.where, "created_at >= "+q.arg(f.From.UTC()))
+	}
+	if !f.To.IsZero() {
+		q.where = append(q.where, "created_at < "+q.arg(f.To.UTC()))
+	}
+
+	query := `
+	SELECT ` + key + `, COUNT(*), COALESCE(SUM(input_tokens), 0), COALESCE(SUM(output_tokens), 0), COALESCE(SUM(cost), 0)
+	FROM usage_records` + q.whereClause() + `
+	GROUP BY ` + key + `
+	ORDER BY 5 DESC, 1`
+
+	rows, err := d.conn.Query(query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []*models.UsageTotal{}
+	for rows.Next() {
+		t := &models.UsageTotal{}
+		if err := rows.Scan(&t.Key, &t.Requests, &t.InputTokens, &t.OutputTokens, &t.Cost); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+
+	return totals, rows.Err()
+}
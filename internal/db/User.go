@@ -0,0 +1,56 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"composer/internal/models"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+func (d *Db) InsertUser(u *models.User) error {
+	query := `
+	INSERT INTO users (email, name, password_hash, oidc_issuer, oidc_subject, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id`
+
+	return d.conn.QueryRow(query, u.Email, u.Name, u.PasswordHash, u.OIDCIssuer, u.OIDCSubject, u.CreatedAt).Scan(&u.ID)
+}
+
+// LinkUserOIDC records the OIDC identity a user signs in with.
+func (d *Db) LinkUserOIDC(u *models.User) error {
+	query := `UPDATE users SET oidc_issuer = $1, oidc_subject = $2 WHERE id = $3`
+	_, err := d.conn.Exec(query, u.OIDCIssuer, u.OIDCSubject, u.ID)
+	return err
+}
+
+func (d *Db) GetUser(id string) (*models.User, error) {
+	return d.getUser(`WHERE id = $1`, id)
+}
+
+// GetUserByEmail matches email case-insensitively.
+func (d *Db) GetUserByEmail(email string) (*models.User, error) {
+	return d.getUser(`WHERE LOWER(email) = LOWER($1)`, email)
+}
+
+func (d *Db) GetUserByOIDC(issuer, subject string) (*models.User, error) {
+	return d.getUser(`WHERE oidc_issuer = $1 AND oidc_subject = $2`, issuer, subject)
+}
+
+func (d *Db) getUser(where string, args ...any) (*models.User, error) {
+	query := `
+	SELECT id, email, name, password_hash, oidc_issuer, oidc_subject, created_at
+	FROM users ` + where
+
+	u := &models.User{}
+	err := d.conn.QueryRow(query, args...).Scan(&u.ID, &u.Email, &u.Name, &u.PasswordHash, &u.OIDCIssuer, &u.OIDCSubject, &u.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return u, nil
+}
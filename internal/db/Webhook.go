@@ -0,0 +1,207 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"composer/internal/models"
+)
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+const webhookColumns = `id, workspace_id, job_id, url, events, description, secret, created_by, created_at`
+
+const deliveryColumns = `id, webhook_id, event, payload, status, attempts, response_status, error, duration_ms,
+	next_attempt_at, created_at, delivered_at`
+
+func (d *Db) InsertWebhook(w *models.Webhook) error {
+	query := `
+	INSERT INTO webhooks (workspace_id, job_id, url, events, description, secret, created_by, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id`
+
+	return d.conn.QueryRow(query, w.WorkspaceID, w.JobID, w.URL, strings.Join(w.Events, ","), w.Description, w.Secret,
+		w.CreatedBy, w.CreatedAt.UTC()).Scan(&w.ID)
+}
+
+// GetWebhook returns a workspace's webhook.
+func (d *Db) GetWebhook(workspaceID, id string) (*models.Webhook, error) {
+	w, err := scanWebhook(d.conn.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1 AND workspace_id = $2 AND job_id = ''`,
+		id, workspaceID))
+	if err == sql.ErrNoRows {
+		return nil, ErrWebhookNotFound
+	}
+	return w, err
+}
+
+// GetJobWebhook returns the webhook notified of a job's outcome.
+func (d *Db) GetJobWebhook(jobID string) (*models.Webhook, error) {
+	w, err := scanWebhook(d.conn.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE job_id = $1`, jobID))
+	if err == sql.ErrNoRows {
+		return nil, ErrWebhookNotFound
+	}
+	return w, err
+}
+
+// ListWebhooks returns a workspace's webhooks, leaving out those of jobs.
+func (d *Db) ListWebhooks(workspaceID string) ([]*models.Webhook, error) {
+	rows, err := d.conn.Query(`SELECT `+webhookColumns+` FROM webhooks WHERE workspace_id = $1 AND job_id = '' ORDER BY id`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*models.Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook removes a webhook and its deliveries, including those not
+// yet sent.
+func (d *Db) DeleteWebhook(workspaceID, id string) error {
+	result, err := d.conn.Exec(`DELETE FROM webhooks WHERE id = $1 AND workspace_id = $2 AND job_id = ''`, id, workspaceID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrWebhookNotFound
+	}
+	_, err = d.conn.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = $1`, id)
+	return err
+}
+
+func (d *Db) InsertWebhookDelivery(dl *models.WebhookDelivery) error {
+	query := `
+	INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt_at, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id`
+
+	return d.conn.QueryRow(query, dl.WebhookID, dl.Event, string(dl.Payload), dl.Status, dl.NextAttemptAt.UTC(),
+		dl.CreatedAt.UTC()).Scan(&dl.ID)
+}
+
+// ListWebhookDeliveries returns a webhook's most recent deliveries, newest
+// first.
+func (d *Db) ListWebhookDeliveries(webhookID string, limit int) ([]*models.WebhookDelivery, error) {
+	rows, err := d.conn.Query(`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2`,
+		webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		dl, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, dl)
+	}
+	return deliveries, rows.Err()
+}
+
+// ClaimWebhookDelivery takes the oldest pending delivery that is due, and
+// the webhook to send it to, holding it until leaseUntil by pushing its next
+// attempt back. A delivery whose sender stopped is therefore retried once the
+// lease runs out. It returns nil if nothing is due.
+func (d *Db) ClaimWebhookDelivery(now, leaseUntil time.Time) (*models.WebhookDelivery, *models.Webhook, error) {
+	now = now.UTC()
+	for {
+		dl, err := scanDelivery(d.conn.QueryRow(`
+		SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE status = $1 AND next_attempt_at <= $2
+		ORDER BY next_attempt_at, id LIMIT 1`,
+			models.DeliveryPending, now))
+		if err == sql.ErrNoRows {
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Compare-and-set on the attempt count, so that senders sharing the
+		// database never make the same attempt twice.
+		result, err := d.conn.Exec(`
+		UPDATE webhook_deliveries
+		SET next_attempt_at = $1, attempts = attempts + 1
+		WHERE id = $2 AND status = $3 AND attempts = $4`,
+			leaseUntil.UTC(), dl.ID, models.DeliveryPending, dl.Attempts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return nil, nil, err
+		} else if n == 0 {
+			continue
+		}
+		dl.Attempts++
+		dl.NextAttemptAt = leaseUntil
+
+		w, err := scanWebhook(d.conn.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, dl.WebhookID))
+		if err == sql.ErrNoRows {
+			// Deleted since the event; DeleteWebhook removes the rest.
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return dl, w, nil
+	}
+}
+
+// FinishWebhookDelivery records the outcome of an attempt: its status, the
+// receiver's response and, while pending, when to try again.
+func (d *Db) FinishWebhookDelivery(dl *models.WebhookDelivery) error {
+	var deliveredAt *time.Time
+	if dl.DeliveredAt != nil {
+		t := dl.DeliveredAt.UTC()
+		deliveredAt = &t
+	}
+	_, err := d.conn.Exec(`
+	UPDATE webhook_deliveries
+	SET status = $1, response_status = $2, error = $3, duration_ms = $4, next_attempt_at = $5, delivered_at = $6
+	WHERE id = $7`,
+		dl.Status, dl.ResponseStatus, dl.Error, dl.DurationMs, dl.NextAttemptAt.UTC(), deliveredAt, dl.ID)
+	return err
+}
+
+func scanWebhook(row interface{ Scan(...any) error }) (*models.Webhook, error) {
+	w := &models.Webhook{}
+	var events string
+	err := row.Scan(&w.ID, &w.WorkspaceID, &w.JobID, &w.URL, &events, &w.Description, &w.Secret, &w.CreatedBy, &w.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	w.Events = []string{}
+	if events != "" {
+		w.Events = strings.Split(events, ",")
+	}
+	return w, nil
+}
+
+func scanDelivery(row interface{ Scan(...any) error }) (*models.WebhookDelivery, error) {
+	dl := &models.WebhookDelivery{}
+	var payload string
+	var deliveredAt sql.NullTime
+	err := row.Scan(&dl.ID, &dl.WebhookID, &dl.Event, &payload, &dl.Status, &dl.Attempts, &dl.ResponseStatus, &dl.Error,
+		&dl.DurationMs, &dl.NextAttemptAt, &dl.CreatedAt, &deliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	dl.Payload = []byte(payload)
+	if deliveredAt.Valid {
+		dl.DeliveredAt = &deliveredAt.Time
+	}
+	return dl, nil
+}
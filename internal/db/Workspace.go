@@ -0,0 +1,132 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"composer/internal/models"
+)
+
+// InsertWorkspace creates a workspace with ownerID as its first owner.
+func (d *Db) InsertWorkspace(ws *models.Workspace, ownerID string) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`INSERT INTO workspaces (name, created_at) VALUES ($1, $2) RETURNING id`, ws.Name, ws.CreatedAt).Scan(&ws.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO workspace_members (workspace_id, user_id, role, created_at)
+	VALUES ($1, $2, $3, $4)`, ws.ID, ownerID, models.RoleOwner, ws.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	ws.Role = models.RoleOwner
+	return tx.Commit()
+}
+
+func (d *Db) GetWorkspace(id string) (*models.Workspace, error) {
+	ws := &models.Workspace{}
+	err := d.conn.QueryRow(`SELECT id, name, created_at FROM workspaces WHERE id = $1`, id).Scan(&ws.ID, &ws.Name, &ws.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("workspace not found")
+		}
+		return nil, err
+	}
+
+	return ws, nil
+}
+
+// ListWorkspaces returns the workspaces a user belongs to, with their role in
+// each.
+func (d *Db) ListWorkspaces(userID string) ([]*models.Workspace, error) {
+	query := `
+	SELECT w.id, w.name, m.role, w.created_at
+	FROM workspaces w
+	JOIN workspace_members m ON m.workspace_id = w.id
+	WHERE m.user_id = $1
+	ORDER BY w.name`
+
+	rows, err := d.conn.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workspaces []*models.Workspace
+	for rows.Next() {
+		ws := &models.Workspace{}
+		if err := rows.Scan(&ws.ID, &ws.Name, &ws.Role, &ws.CreatedAt); err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, ws)
+	}
+
+	return workspaces, rows.Err()
+}
+
+// GetWorkspaceRole returns a user's role in a workspace, or "" if they are not
+// a member.
+func (d *Db) GetWorkspaceRole(workspaceID, userID string) (models.Role, error) {
+	var role models.Role
+	err := d.conn.QueryRow(`SELECT role FROM workspace_members WHERE workspace_id = $1 AND user_id = $2`, workspaceID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+func (d *Db) ListWorkspaceMembers(workspaceID string) ([]*models.WorkspaceMember, error) {
+	query := `
+	SELECT m.workspace_id, m.user_id, u.email, u.name, m.role, m.created_at
+	FROM workspace_members m
+	JOIN users u ON CAST(u.id AS TEXT) = m.user_id
+	WHERE m.workspace_id = $1
+	ORDER BY u.email`
+
+	rows, err := d.conn.Query(query, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []*models.WorkspaceMember
+	for rows.Next() {
+		m := &models.WorkspaceMember{}
+		if err := rows.Scan(&m.WorkspaceID, &m.UserID, &m.Email, &m.Name, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+
+	return members, rows.Err()
+}
+
+// SetWorkspaceMember adds a member or changes their role.
+func (d *Db) SetWorkspaceMember(m *models.WorkspaceMember) error {
+	query := `
+	INSERT INTO workspace_members (workspace_id, user_id, role, created_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = excluded.role`
+
+	_, err := d.conn.Exec(query, m.WorkspaceID, m.UserID, m.Role, m.CreatedAt)
+	return err
+}
+
+func (d *Db) RemoveWorkspaceMember(workspaceID, userID string) error {
+	_, err := d.conn.Exec(`DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2`, workspaceID, userID)
+	return err
+}
+
+func (d *Db) CountWorkspaceOwners(workspaceID string) (int, error) {
+	var n int
+	err := d.conn.QueryRow(`SELECT COUNT(*) FROM workspace_members WHERE workspace_id = $1 AND role = $2`, workspaceID, models.RoleOwner).Scan(&n)
+	return n, err
+}
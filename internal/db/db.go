@@ -2,13 +2,16 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
 
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 type Db struct {
-	conn *sql.DB
+	conn instrumentedConn
 }
 
 func New(relationDBToUse, connectionString string) (*Db, error) {
@@ -40,6 +43,272 @@ func New(relationDBToUse, connectionString string) (*Db, error) {
 		last_modified_by TEXT NOT NULL,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS attachments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT NOT NULL,
+		filename TEXT NOT NULL,
+		content_type TEXT,
+		size INTEGER,
+		blob_key TEXT NOT NULL,
+		extracted_text TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS knowledge_chunks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		workspace TEXT NOT NULL,
+		source TEXT NOT NULL,
+		chunk_index INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		metadata TEXT,
+		embedding BLOB NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (workspace, source, chunk_index)
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL DEFAULT '',
+		password_hash TEXT NOT NULL DEFAULT '',
+		oidc_issuer TEXT NOT NULL DEFAULT '',
+		oidc_subject TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS auth_sessions (
+		token_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS workspaces (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS workspace_members (
+		workspace_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (workspace_id, user_id)
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS comments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		body TEXT NOT NULL,
+		selected_text TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS shares (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		access TEXT NOT NULL,
+		created_by TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS share_accesses (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		share_id TEXT NOT NULL,
+		session_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		ip TEXT,
+		user_agent TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		workspace_id TEXT,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		last_used_at TIMESTAMP,
+		expires_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		actor_id TEXT NOT NULL,
+		actor_type TEXT NOT NULL,
+		credential_id TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL,
+		session_id TEXT NOT NULL DEFAULT '',
+		workspace_id TEXT NOT NULL DEFAULT '',
+		version INTEGER NOT NULL DEFAULT 0,
+		model TEXT NOT NULL DEFAULT '',
+		prompt_version TEXT NOT NULL DEFAULT '',
+		input_tokens INTEGER NOT NULL DEFAULT 0,
+		output_tokens INTEGER NOT NULL DEFAULT 0,
+		detail TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS redaction_policies (
+		workspace_id TEXT PRIMARY KEY,
+		enabled BOOLEAN NOT NULL,
+		detectors TEXT NOT NULL,
+		patterns TEXT NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS rate_counters (
+		key TEXT NOT NULL,
+		window_start TIMESTAMP NOT NULL,
+		count INTEGER NOT NULL,
+		PRIMARY KEY (key, window_start)
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS rate_slots (
+		key TEXT NOT NULL,
+		id TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (key, id)
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		status TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		api_key_id TEXT NOT NULL DEFAULT '',
+		input TEXT NOT NULL,
+		request_method TEXT NOT NULL,
+		request_path TEXT NOT NULL,
+		request_content_type TEXT NOT NULL DEFAULT '',
+		request_body_key TEXT NOT NULL DEFAULT '',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		max_attempts INTEGER NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		webhook_url TEXT NOT NULL DEFAULT '',
+		result_status INTEGER NOT NULL DEFAULT 0,
+		result_type TEXT NOT NULL DEFAULT '',
+		result_key TEXT NOT NULL DEFAULT '',
+		lease_owner TEXT NOT NULL DEFAULT '',
+		lease_expires_at TIMESTAMP,
+		run_after TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		started_at TIMESTAMP,
+		finished_at TIMESTAMP
+	)`,
+
+		`CREATE INDEX IF NOT EXISTS jobs_status_run_after ON jobs (status, run_after)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		workspace_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		events TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		secret TEXT NOT NULL,
+		created_by TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_status INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		delivered_at TIMESTAMP
+	)`,
+
+		`CREATE INDEX IF NOT EXISTS webhook_deliveries_status_next_attempt_at ON webhook_deliveries (status, next_attempt_at)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS usage_records (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL DEFAULT '',
+		api_key_id TEXT NOT NULL DEFAULT '',
+		session_id TEXT NOT NULL DEFAULT '',
+		workspace_id TEXT NOT NULL DEFAULT '',
+		model TEXT NOT NULL DEFAULT '',
+		input_tokens INTEGER NOT NULL DEFAULT 0,
+		output_tokens INTEGER NOT NULL DEFAULT 0,
+		cost REAL NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+		`CREATE INDEX IF NOT EXISTS usage_records_created_at ON usage_records (created_at)`,
+
+		`
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id TEXT NOT NULL,
+		key TEXT NOT NULL,
+		request_hash TEXT NOT NULL DEFAULT '',
+		session_id TEXT NOT NULL DEFAULT '',
+		response_status INTEGER NOT NULL DEFAULT 0,
+		response TEXT NOT NULL DEFAULT '',
+		locked_until TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, key)
+	)`,
+
+		// The audit log is append-only.
+		`
+	CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+	BEGIN SELECT RAISE(ABORT, 'audit_events is append-only'); END`,
+
+		`
+	CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+	BEGIN SELECT RAISE(ABORT, 'audit_events is append-only'); END`,
+	}
+
+	// Columns added after their table was first created. CREATE TABLE IF NOT
+	// EXISTS leaves existing tables alone, so these are added separately.
+	addColumns := []struct{ table, column, definition string }{
+		{"chat_messages", "citations", "TEXT"},
+		{"chat_messages", "guardrails", "TEXT"},
+		{"chat_messages", "user_id", "TEXT"},
+		{"chat_messages", "model", "TEXT"},
+		{"chat_messages", "input_tokens", "INTEGER"},
+		{"chat_messages", "output_tokens", "INTEGER"},
+		{"chat_messages", "cost", "REAL"},
+		{"chat_messages", "format", "TEXT"},
+		{"chat_sessions", "user_id", "TEXT"},
+		{"chat_sessions", "workspace_id", "TEXT"},
+		{"comments", "author_name", "TEXT"},
+		{"jobs", "webhook_secret", "TEXT NOT NULL DEFAULT ''"},
+		{"webhooks", "job_id", "TEXT NOT NULL DEFAULT ''"},
 	}
 
 	conn, err := sql.Open(relationDBToUse, connectionString)
@@ -54,7 +323,41 @@ func New(relationDBToUse, connectionString string) (*Db, error) {
 		}
 	}
 
+	for _, c := range addColumns {
+		if hasColumn(conn, c.table, c.column) {
+			continue
+		}
+		_, err := conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &Db{
-		conn: conn,
+		conn: instrumentedConn{DB: conn, system: dbSystem(relationDBToUse)},
 	}, nil
 }
+
+// Close closes the connection pool.
+func (d *Db) Close() error {
+	return d.conn.DB.Close()
+}
+
+// hasColumn works on both SQLite and Postgres: selecting a missing column
+// fails.
+func hasColumn(conn *sql.DB, table, column string) bool {
+	rows, err := conn.Query(fmt.Sprintf("SELECT %s FROM %s LIMIT 0", column, table))
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// dbSystem names the database for traces.
+func dbSystem(relationDBToUse string) attribute.KeyValue {
+	if relationDBToUse == "postgres" {
+		return semconv.DBSystemPostgreSQL
+	}
+	return semconv.DBSystemSqlite
+}
@@ -0,0 +1,431 @@
+package export
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"composer/internal/convert"
+	"composer/internal/sanitize"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type blockKind int
+
+const (
+	blockParagraph blockKind = iota
+	blockHeading
+	blockListItem
+	blockCode
+	blockTable
+	blockRule
+	blockImage
+)
+
+type run struct {
+	Text   string
+	Bold   bool
+	Italic bool
+	Code   bool
+	Link   string
+	Break  bool
+}
+
+// block is the flattened form of the artifact used by the paged renderers
+// (DOCX and PDF), which have no notion of nested markup.
+type block struct {
+	Kind    blockKind
+	Level   int
+	Ordered bool
+	Number  int
+	Quote   bool
+	ID      string
+	Runs    []run
+	Text    string
+	Rows    [][]string
+	Header  bool
+	Alt     string
+	Src     string
+}
+
+type heading struct {
+	Level  int
+	Indent int
+	Text   string
+	ID     string
+}
+
+type document struct {
+	root     *html.Node
+	headings []heading
+	blocks   []block
+}
+
+// Headings deeper than this are left out of the table of contents.
+const tocDepth = 3
+
+// toc lists the headings that make up the table of contents, indented
+// relative to the shallowest one.
+func (d *document) toc() []heading {
+	var out []heading
+	top := tocDepth
+	for _, h := range d.headings {
+		if h.Level <= tocDepth {
+			out = append(out, h)
+			top = min(top, h.Level)
+		}
+	}
+	for i := range out {
+		out[i].Indent = out[i].Level - top
+	}
+	return out
+}
+
+func (b block) plainText() string {
+	var s strings.Builder
+	for _, r := range b.Runs {
+		if r.Break {
+			s.WriteString("\n")
+			continue
+		}
+		s.WriteString(r.Text)
+	}
+	return s.String()
+}
+
+func parse(artifact string, from convert.Format, footnotes []Footnote) (*document, error) {
+	var source string
+	if from == convert.Markdown {
+		converted, err := convert.MarkdownToHTML(artifact)
+		if err != nil {
+			return nil, err
+		}
+		source = converted
+	} else {
+		source, _ = sanitize.Artifact(artifact, false)
+	}
+	source += footnotesHTML(footnotes)
+
+	root := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(source), root)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+
+	// Site-relative images (the editor's logo) can't be resolved outside the
+	// app; the branding header takes their place.
+	removeNodes(root, func(n *html.Node) bool {
+		if n.DataAtom != atom.Img {
+			return false
+		}
+		src := attr(n, "src")
+		return strings.HasPrefix(src, "/") && !strings.HasPrefix(src, "//")
+	})
+
+	doc := &document{root: root}
+	doc.assignHeadingIDs()
+
+	p := &blockParser{}
+	p.walk(root, false)
+	doc.blocks = p.blocks
+
+	return doc, nil
+}
+
+// footnotesHTML renders the sources section. It is added to the artifact
+// before parsing so every format picks it up, table of contents included.
+func footnotesHTML(footnotes []Footnote) string {
+	if len(footnotes) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n<h2>Sources</h2>\n")
+	for _, f := range footnotes {
+		fmt.Fprintf(&b, "<p>[%d] <strong>%s</strong>", f.Number, html.EscapeString(f.Source))
+		if f.Snippet != "" {
+			fmt.Fprintf(&b, " — <em>%s</em>", html.EscapeString(f.Snippet))
+		}
+		b.WriteString("</p>\n")
+	}
+	return b.String()
+}
+
+func (d *document) assignHeadingIDs() {
+	seen := map[string]int{}
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		if level := headingLevel(n); level > 0 {
+			text := strings.TrimSpace(collapse(textContent(n)))
+			id := slug(text)
+			if id == "" {
+				id = "section"
+			}
+			seen[id]++
+			if seen[id] > 1 {
+				id = fmt.Sprintf("%s-%d", id, seen[id])
+			}
+			setAttr(n, "id", id)
+			d.headings = append(d.headings, heading{Level: level, Text: text, ID: id})
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(d.root)
+}
+
+type blockParser struct {
+	blocks []block
+}
+
+func (p *blockParser) walk(n *html.Node, quote bool) {
+	var pending []run
+	flush := func() {
+		if runs := trimRuns(pending); len(runs) > 0 {
+			p.blocks = append(p.blocks, block{Kind: blockParagraph, Runs: runs, Quote: quote})
+		}
+		pending = nil
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			pending = append(pending, inlineRuns(c, run{})...)
+			continue
+		}
+
+		switch c.DataAtom {
+		case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+			flush()
+			p.blocks = append(p.blocks, block{Kind: blockHeading, Level: headingLevel(c), ID: attr(c, "id"), Runs: trimRuns(inlineRuns(c, run{}))})
+		case atom.P, atom.Figcaption, atom.Summary, atom.Dt, atom.Dd:
+			flush()
+			if runs := trimRuns(inlineRuns(c, run{})); len(runs) > 0 {
+				p.blocks = append(p.blocks, block{Kind: blockParagraph, Runs: runs, Quote: quote})
+			}
+		case atom.Ul, atom.Ol:
+			flush()
+			p.list(c, 1)
+		case atom.Pre:
+			flush()
+			p.blocks = append(p.blocks, block{Kind: blockCode, Text: strings.TrimSuffix(textContent(c), "\n")})
+		case atom.Table:
+			flush()
+			p.table(c)
+		case atom.Blockquote:
+			flush()
+			p.walk(c, true)
+		case atom.Hr:
+			flush()
+			p.blocks = append(p.blocks, block{Kind: blockRule})
+		case atom.Img:
+			flush()
+			p.blocks = append(p.blocks, block{Kind: blockImage, Alt: attr(c, "alt"), Src: attr(c, "src")})
+		case atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Main,
+			atom.Aside, atom.Figure, atom.Details, atom.Dl, atom.Nav:
+			flush()
+			p.walk(c, quote)
+		default:
+			pending = append(pending, inlineRuns(c, run{})...)
+		}
+	}
+	flush()
+}
+
+func (p *blockParser) list(n *html.Node, depth int) {
+	ordered := n.DataAtom == atom.Ol
+	number := 1
+	if start := attr(n, "start"); start != "" {
+		fmt.Sscanf(start, "%d", &number)
+	}
+
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.DataAtom != atom.Li {
+			continue
+		}
+
+		item := block{Kind: blockListItem, Level: depth, Ordered: ordered, Number: number}
+		number++
+		emitted := false
+		emit := func() {
+			if !emitted {
+				item.Runs = trimRuns(item.Runs)
+				p.blocks = append(p.blocks, item)
+				emitted = true
+			}
+		}
+
+		for c := li.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.Ul || c.DataAtom == atom.Ol {
+				emit()
+				p.list(c, depth+1)
+				continue
+			}
+			if c.DataAtom == atom.P && len(item.Runs) > 0 {
+				item.Runs = append(item.Runs, run{Break: true})
+			}
+			item.Runs = append(item.Runs, inlineRuns(c, run{})...)
+		}
+		emit()
+	}
+}
+
+func (p *blockParser) table(n *html.Node) {
+	b := block{Kind: blockTable}
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.DataAtom {
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				visit(c)
+			case atom.Tr:
+				var row []string
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+						if len(b.Rows) == 0 && cell.DataAtom == atom.Th {
+							b.Header = true
+						}
+						row = append(row, strings.TrimSpace(collapse(textContent(cell))))
+					}
+				}
+				b.Rows = append(b.Rows, row)
+			}
+		}
+	}
+	visit(n)
+
+	columns := 0
+	for _, row := range b.Rows {
+		columns = max(columns, len(row))
+	}
+	for i := range b.Rows {
+		for len(b.Rows[i]) < columns {
+			b.Rows[i] = append(b.Rows[i], "")
+		}
+	}
+	if columns > 0 {
+		p.blocks = append(p.blocks, b)
+	}
+}
+
+func inlineRuns(n *html.Node, style run) []run {
+	switch n.Type {
+	case html.TextNode:
+		text := collapse(n.Data)
+		if text == "" {
+			return nil
+		}
+		r := style
+		r.Text = text
+		return []run{r}
+	case html.ElementNode:
+	default:
+		return nil
+	}
+
+	switch n.DataAtom {
+	case atom.Strong, atom.B:
+		style.Bold = true
+	case atom.Em, atom.I:
+		style.Italic = true
+	case atom.Code, atom.Kbd:
+		style.Code = true
+	case atom.A:
+		style.Link = attr(n, "href")
+	case atom.Br:
+		return []run{{Break: true}}
+	case atom.Img:
+		if alt := attr(n, "alt"); alt != "" {
+			r := style
+			r.Text = "[" + alt + "]"
+			return []run{r}
+		}
+		return nil
+	}
+
+	var out []run
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		out = append(out, inlineRuns(c, style)...)
+	}
+	return out
+}
+
+// trimRuns drops the whitespace HTML would not render at the edges of a block.
+func trimRuns(runs []run) []run {
+	for len(runs) > 0 && !runs[0].Break && strings.TrimSpace(runs[0].Text) == "" {
+		runs = runs[1:]
+	}
+	for len(runs) > 0 && !runs[len(runs)-1].Break && strings.TrimSpace(runs[len(runs)-1].Text) == "" {
+		runs = runs[:len(runs)-1]
+	}
+	if len(runs) == 0 {
+		return nil
+	}
+
+	out := make([]run, len(runs))
+	copy(out, runs)
+	out[0].Text = strings.TrimLeft(out[0].Text, " ")
+	out[len(out)-1].Text = strings.TrimRight(out[len(out)-1].Text, " ")
+	return out
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+func collapse(s string) string {
+	return whitespace.ReplaceAllString(s, " ")
+}
+
+func headingLevel(n *html.Node) int {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return int(n.Data[1] - '0')
+	}
+	return 0
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func removeNodes(n *html.Node, match func(*html.Node) bool) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode && match(c) {
+			n.RemoveChild(c)
+		} else {
+			removeNodes(c, match)
+		}
+		c = next
+	}
+}
@@ -0,0 +1,301 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	_ "image/png"
+	"strings"
+	"time"
+)
+
+const (
+	wordNS = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"`
+	drawNS = `xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing" xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" xmlns:pic="http://schemas.openxmlformats.org/drawingml/2006/picture"`
+
+	relTypeBase = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/"
+
+	// English Metric Units per inch, used by DrawingML.
+	emuPerInch = 914400
+)
+
+// docxWriter accumulates the body of word/document.xml along with the
+// relationships its hyperlinks need.
+type docxWriter struct {
+	body      strings.Builder
+	rels      []string
+	bookmarks int
+}
+
+func (w *docxWriter) link(target string) string {
+	id := fmt.Sprintf("rIdLink%d", len(w.rels)+1)
+	w.rels = append(w.rels, fmt.Sprintf(`<Relationship Id="%s" Type="%shyperlink" Target="%s" TargetMode="External"/>`, id, relTypeBase, xmlEscape(target)))
+	return id
+}
+
+func renderDOCX(doc *document, meta Metadata, brand Branding) ([]byte, error) {
+	w := &docxWriter{}
+
+	w.paragraph("Title", "", textRun(meta.Title, ""))
+	for _, f := range meta.fields()[1:] {
+		w.paragraph("Subtitle", "", textRun(f[0]+": ", `<w:b/>`)+textRun(f[1], ""))
+	}
+
+	if toc := doc.toc(); len(toc) > 0 {
+		w.paragraph("TOCHeading", "", textRun("Contents", ""))
+		for _, h := range toc {
+			indent := fmt.Sprintf(`<w:ind w:left="%d"/>`, 360*h.Indent)
+			w.paragraph("TOC1", indent, fmt.Sprintf(`<w:hyperlink w:anchor="%s" w:history="1">%s</w:hyperlink>`, bookmarkName(h.ID), textRun(h.Text, `<w:rStyle w:val="Hyperlink"/>`)))
+		}
+	}
+
+	for _, b := range doc.blocks {
+		w.block(b)
+	}
+
+	header, headerRels, err := docxHeader(brand)
+	if err != nil {
+		return nil, err
+	}
+
+	w.body.WriteString(`<w:sectPr><w:headerReference w:type="default" r:id="rIdHeader"/>` +
+		`<w:pgSz w:w="11906" w:h="16838"/><w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440" w:header="708" w:footer="708" w:gutter="0"/></w:sectPr>`)
+
+	documentXML := xml.Header + `<w:document ` + wordNS + `><w:body>` + w.body.String() + `</w:body></w:document>`
+
+	documentRels := xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rIdStyles" Type="` + relTypeBase + `styles" Target="styles.xml"/>` +
+		`<Relationship Id="rIdHeader" Type="` + relTypeBase + `header" Target="header1.xml"/>` +
+		strings.Join(w.rels, "") + `</Relationships>`
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"[Content_Types].xml", []byte(docxContentTypes)},
+		{"_rels/.rels", []byte(docxPackageRels)},
+		{"docProps/core.xml", []byte(docxCoreProperties(meta))},
+		{"word/document.xml", []byte(documentXML)},
+		{"word/_rels/document.xml.rels", []byte(documentRels)},
+		{"word/styles.xml", []byte(docxStyles)},
+		{"word/header1.xml", []byte(header)},
+		{"word/_rels/header1.xml.rels", []byte(headerRels)},
+	}
+	if len(brand.Logo) > 0 {
+		files = append(files, struct {
+			name string
+			data []byte
+		}{"word/media/logo.png", brand.Logo})
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (w *docxWriter) paragraph(style, props, content string) {
+	w.body.WriteString(`<w:p><w:pPr>`)
+	if style != "" {
+		fmt.Fprintf(&w.body, `<w:pStyle w:val="%s"/>`, style)
+	}
+	w.body.WriteString(props + `</w:pPr>` + content + `</w:p>`)
+}
+
+func (w *docxWriter) block(b block) {
+	switch b.Kind {
+	case blockHeading:
+		w.bookmarks++
+		content := fmt.Sprintf(`<w:bookmarkStart w:id="%d" w:name="%s"/>%s<w:bookmarkEnd w:id="%d"/>`,
+			w.bookmarks, bookmarkName(b.ID), w.runs(b.Runs), w.bookmarks)
+		w.paragraph(fmt.Sprintf("Heading%d", b.Level), "", content)
+	case blockParagraph:
+		style := ""
+		if b.Quote {
+			style = "Quote"
+		}
+		w.paragraph(style, "", w.runs(b.Runs))
+	case blockListItem:
+		marker := "•"
+		if b.Ordered {
+			marker = fmt.Sprintf("%d.", b.Number)
+		}
+		indent := fmt.Sprintf(`<w:ind w:left="%d" w:hanging="360"/>`, 360+360*b.Level)
+		w.paragraph("ListParagraph", indent, textRun(marker+"\t", "")+w.runs(b.Runs))
+	case blockCode:
+		for _, line := range strings.Split(b.Text, "\n") {
+			w.paragraph("Code", "", textRun(line, ""))
+		}
+	case blockTable:
+		w.table(b)
+	case blockRule:
+		w.paragraph("", `<w:pBdr><w:bottom w:val="single" w:sz="6" w:space="1" w:color="999999"/></w:pBdr>`, "")
+	case blockImage:
+		w.paragraph("", "", textRun(fmt.Sprintf("[Image: %s]", b.Alt), `<w:i/>`))
+	}
+}
+
+func (w *docxWriter) table(b block) {
+	w.body.WriteString(`<w:tbl><w:tblPr><w:tblStyle w:val="TableGrid"/><w:tblW w:w="5000" w:type="pct"/></w:tblPr><w:tblGrid>`)
+	for range b.Rows[0] {
+		w.body.WriteString(`<w:gridCol/>`)
+	}
+	w.body.WriteString(`</w:tblGrid>`)
+	for i, row := range b.Rows {
+		w.body.WriteString(`<w:tr>`)
+		props := ""
+		if i == 0 && b.Header {
+			props = `<w:b/>`
+		}
+		for _, cell := range row {
+			fmt.Fprintf(&w.body, `<w:tc><w:tcPr><w:tcW w:w="0" w:type="auto"/></w:tcPr><w:p>%s</w:p></w:tc>`, textRun(cell, props))
+		}
+		w.body.WriteString(`</w:tr>`)
+	}
+	w.body.WriteString(`</w:tbl><w:p/>`)
+}
+
+func (w *docxWriter) runs(runs []run) string {
+	var b strings.Builder
+	for _, r := range runs {
+		if r.Break {
+			b.WriteString(`<w:r><w:br/></w:r>`)
+			continue
+		}
+
+		props := ""
+		if r.Bold {
+			props += `<w:b/>`
+		}
+		if r.Italic {
+			props += `<w:i/>`
+		}
+		if r.Code {
+			props += `<w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/>`
+		}
+
+		switch {
+		case strings.HasPrefix(r.Link, "#"):
+			fmt.Fprintf(&b, `<w:hyperlink w:anchor="%s">%s</w:hyperlink>`, bookmarkName(r.Link[1:]), textRun(r.Text, `<w:rStyle w:val="Hyperlink"/>`+props))
+		case r.Link != "":
+			fmt.Fprintf(&b, `<w:hyperlink r:id="%s">%s</w:hyperlink>`, w.link(r.Link), textRun(r.Text, `<w:rStyle w:val="Hyperlink"/>`+props))
+		default:
+			b.WriteString(textRun(r.Text, props))
+		}
+	}
+	return b.String()
+}
+
+func textRun(text, props string) string {
+	if props != "" {
+		props = `<w:rPr>` + props + `</w:rPr>`
+	}
+	return fmt.Sprintf(`<w:r>%s<w:t xml:space="preserve">%s</w:t></w:r>`, props, xmlEscape(text))
+}
+
+// Word limits bookmark names to 40 characters.
+func bookmarkName(id string) string {
+	name := "_" + strings.ReplaceAll(id, "-", "_")
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return name
+}
+
+func docxHeader(brand Branding) (string, string, error) {
+	rels := xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`
+	logo := ""
+
+	if len(brand.Logo) > 0 {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(brand.Logo))
+		if err != nil {
+			return "", "", fmt.Errorf("decoding branding logo: %w", err)
+		}
+		// Render the logo 0.4" tall, keeping its aspect ratio.
+		cy := emuPerInch * 4 / 10
+		cx := cy * cfg.Width / max(cfg.Height, 1)
+		logo = fmt.Sprintf(`<w:r><w:drawing><wp:inline distT="0" distB="0" distL="0" distR="0"><wp:extent cx="%[1]d" cy="%[2]d"/><wp:docPr id="1" name="Logo"/>`+
+			`<a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture"><pic:pic>`+
+			`<pic:nvPicPr><pic:cNvPr id="0" name="logo.png"/><pic:cNvPicPr/></pic:nvPicPr>`+
+			`<pic:blipFill><a:blip r:embed="rIdLogo"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`+
+			`<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%[1]d" cy="%[2]d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr>`+
+			`</pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing></w:r><w:r><w:t xml:space="preserve">  </w:t></w:r>`, cx, cy)
+		rels += `<Relationship Id="rIdLogo" Type="` + relTypeBase + `image" Target="media/logo.png"/>`
+	}
+
+	header := xml.Header + `<w:hdr ` + wordNS + ` ` + drawNS + `><w:p><w:pPr><w:pStyle w:val="Header"/></w:pPr>` +
+		logo + textRun(brand.Name, `<w:b/><w:color w:val="056DAE"/><w:sz w:val="28"/>`) + `</w:p></w:hdr>`
+
+	return header, rels + `</Relationships>`, nil
+}
+
+func docxCoreProperties(meta Metadata) string {
+	return xml.Header + `<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" ` +
+		`xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` +
+		`<dc:title>` + xmlEscape(meta.Title) + `</dc:title>` +
+		`<dc:creator>` + xmlEscape(meta.Author) + `</dc:creator>` +
+		fmt.Sprintf(`<cp:revision>%d</cp:revision>`, meta.Version) +
+		`<dcterms:created xsi:type="dcterms:W3CDTF">` + meta.Date.UTC().Format(time.RFC3339) + `</dcterms:created>` +
+		`</cp:coreProperties>`
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+const docxContentTypes = xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Default Extension="png" ContentType="image/png"/>` +
+	`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>` +
+	`<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>` +
+	`<Override PartName="/word/header1.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.header+xml"/>` +
+	`<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>` +
+	`</Types>`
+
+const docxPackageRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>` +
+	`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties" Target="docProps/core.xml"/>` +
+	`</Relationships>`
+
+const docxStyles = xml.Header + `<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri" w:hAnsi="Calibri" w:cs="Calibri"/><w:sz w:val="22"/></w:rPr></w:rPrDefault>` +
+	`<w:pPrDefault><w:pPr><w:spacing w:after="120" w:line="276" w:lineRule="auto"/></w:pPr></w:pPrDefault></w:docDefaults>` +
+	`<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:rPr><w:b/><w:color w:val="002D72"/><w:sz w:val="48"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Subtitle"><w:name w:val="Subtitle"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="0"/></w:pPr><w:rPr><w:color w:val="555555"/><w:sz w:val="20"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="360"/><w:outlineLvl w:val="0"/></w:pPr><w:rPr><w:b/><w:color w:val="002D72"/><w:sz w:val="36"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="240"/><w:outlineLvl w:val="1"/></w:pPr><w:rPr><w:b/><w:color w:val="002D72"/><w:sz w:val="30"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading3"><w:name w:val="heading 3"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="200"/><w:outlineLvl w:val="2"/></w:pPr><w:rPr><w:b/><w:sz w:val="26"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading4"><w:name w:val="heading 4"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="3"/></w:pPr><w:rPr><w:b/><w:sz w:val="24"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading5"><w:name w:val="heading 5"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="4"/></w:pPr><w:rPr><w:b/><w:i/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading6"><w:name w:val="heading 6"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="5"/></w:pPr><w:rPr><w:i/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="TOCHeading"><w:name w:val="TOC Heading"/><w:basedOn w:val="Heading1"/></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="TOC1"><w:name w:val="toc 1"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="60"/></w:pPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="ListParagraph"><w:name w:val="List Paragraph"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="60"/></w:pPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Quote"><w:name w:val="Quote"/><w:basedOn w:val="Normal"/><w:pPr><w:ind w:left="720"/></w:pPr><w:rPr><w:i/><w:color w:val="555555"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Code"><w:name w:val="Code"/><w:basedOn w:val="Normal"/><w:pPr><w:shd w:val="clear" w:color="auto" w:fill="F5F7FA"/><w:spacing w:after="0" w:line="240" w:lineRule="auto"/></w:pPr><w:rPr><w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/><w:sz w:val="20"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Header"><w:name w:val="header"/><w:basedOn w:val="Normal"/></w:style>` +
+	`<w:style w:type="character" w:styleId="Hyperlink"><w:name w:val="Hyperlink"/><w:rPr><w:color w:val="0563C1"/><w:u w:val="single"/></w:rPr></w:style>` +
+	`<w:style w:type="table" w:styleId="TableGrid"><w:name w:val="Table Grid"/><w:tblPr><w:tblBorders>` +
+	`<w:top w:val="single" w:sz="4" w:space="0" w:color="BBBBBB"/><w:left w:val="single" w:sz="4" w:space="0" w:color="BBBBBB"/>` +
+	`<w:bottom w:val="single" w:sz="4" w:space="0" w:color="BBBBBB"/><w:right w:val="single" w:sz="4" w:space="0" w:color="BBBBBB"/>` +
+	`<w:insideH w:val="single" w:sz="4" w:space="0" w:color="BBBBBB"/><w:insideV w:val="single" w:sz="4" w:space="0" w:color="BBBBBB"/>` +
+	`</w:tblBorders><w:tblCellMar><w:left w:w="108" w:type="dxa"/><w:right w:w="108" w:type="dxa"/></w:tblCellMar></w:tblPr></w:style>` +
+	`</w:styles>`
@@ -0,0 +1,113 @@
+package export
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"composer/internal/convert"
+)
+
+type Format string
+
+const (
+	DOCX     Format = "docx"
+	PDF      Format = "pdf"
+	Markdown Format = "md"
+	HTML     Format = "html"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToLower(s)) {
+	case DOCX:
+		return DOCX, nil
+	case PDF:
+		return PDF, nil
+	case Markdown, "markdown":
+		return Markdown, nil
+	case HTML:
+		return HTML, nil
+	}
+	return "", fmt.Errorf("unsupported export format %q, expected docx, pdf, md or html", s)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case DOCX:
+		return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	case PDF:
+		return "application/pdf"
+	case Markdown:
+		return "text/markdown; charset=utf-8"
+	}
+	return "text/html; charset=utf-8"
+}
+
+type Metadata struct {
+	Title     string
+	Author    string
+	Version   int
+	Date      time.Time
+	Footnotes []Footnote
+}
+
+// Footnote is listed under "Sources" at the end of the document; Number
+// matches the [n] marker in the text.
+type Footnote struct {
+	Number  int
+	Source  string
+	Snippet string
+}
+
+// Branding is rendered as a header on every exported document. Logo is a PNG
+// and may be empty.
+type Branding struct {
+	Name string
+	Logo []byte
+}
+
+// Export renders an artifact, in from, either of the editor formats, as a
+// standalone file.
+func Export(artifact string, from convert.Format, format Format, meta Metadata, brand Branding) ([]byte, error) {
+	doc, err := parse(artifact, from, meta.Footnotes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case DOCX:
+		return renderDOCX(doc, meta, brand)
+	case PDF:
+		return renderPDF(doc, meta, brand)
+	case Markdown:
+		return renderMarkdown(doc, meta, brand)
+	case HTML:
+		return renderHTML(doc, meta, brand)
+	}
+	return nil, fmt.Errorf("unsupported export format %q", format)
+}
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+func slug(s string) string {
+	return strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// Filename builds the download name for an exported version.
+func Filename(meta Metadata, format Format) string {
+	name := slug(meta.Title)
+	if name == "" {
+		name = "document"
+	}
+	return fmt.Sprintf("%s-v%d.%s", name, meta.Version, format)
+}
+
+func (m Metadata) fields() [][2]string {
+	return [][2]string{
+		{"Title", m.Title},
+		{"Author", m.Author},
+		{"Version", fmt.Sprintf("%d", m.Version)},
+		{"Date", m.Date.Format("2 January 2006")},
+	}
+}
@@ -0,0 +1,103 @@
+package export
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var htmlTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Meta.Title}}</title>
+<meta name="author" content="{{.Meta.Author}}">
+<meta name="generator" content="Composer">
+<style>
+body { font-family: Helvetica, Arial, sans-serif; max-width: 50rem; margin: 2rem auto; padding: 0 1rem; color: #1a1a1a; line-height: 1.5; }
+header.branding { display: flex; align-items: center; gap: .75rem; border-bottom: 2px solid #056dae; padding-bottom: .75rem; }
+header.branding img { height: 2.5rem; }
+header.branding span { font-size: 1.25rem; font-weight: bold; color: #056dae; }
+dl.metadata { display: grid; grid-template-columns: max-content auto; gap: .25rem 1rem; color: #555; font-size: .9rem; }
+dl.metadata dt { font-weight: bold; }
+dl.metadata dd { margin: 0; }
+nav.toc { background: #f5f7fa; padding: .5rem 1.5rem; border-radius: .25rem; }
+nav.toc ul { list-style: none; padding-left: 0; }
+nav.toc li.indent-1 { padding-left: 1rem; }
+nav.toc li.indent-2 { padding-left: 2rem; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: .25rem .5rem; }
+pre { background: #f5f7fa; padding: .75rem; overflow-x: auto; }
+blockquote { border-left: 3px solid #ccc; margin-left: 0; padding-left: 1rem; color: #555; }
+</style>
+</head>
+<body>
+<header class="branding">
+{{- if .Logo}}<img src="{{.Logo}}" alt="{{.Brand}}">{{end -}}
+<span>{{.Brand}}</span>
+</header>
+<dl class="metadata">
+{{- range .Fields}}
+<dt>{{index . 0}}</dt><dd>{{index . 1}}</dd>
+{{- end}}
+</dl>
+{{- if .TOC}}
+<nav class="toc">
+<h2>Contents</h2>
+<ul>
+{{- range .TOC}}
+<li class="indent-{{.Indent}}"><a href="#{{.ID}}">{{.Text}}</a></li>
+{{- end}}
+</ul>
+</nav>
+{{- end}}
+<main>
+{{.Body}}
+</main>
+</body>
+</html>
+`))
+
+func renderHTML(doc *document, meta Metadata, brand Branding) ([]byte, error) {
+	body, err := renderChildren(doc.root)
+	if err != nil {
+		return nil, err
+	}
+
+	data := struct {
+		Meta   Metadata
+		Brand  string
+		Logo   template.URL
+		Fields [][2]string
+		TOC    []heading
+		Body   template.HTML
+	}{
+		Meta:   meta,
+		Brand:  brand.Name,
+		Fields: meta.fields(),
+		TOC:    doc.toc(),
+		Body:   template.HTML(body),
+	}
+	if len(brand.Logo) > 0 {
+		data.Logo = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(brand.Logo))
+	}
+
+	var buf bytes.Buffer
+	if err := htmlTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderChildren(n *html.Node) (string, error) {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&b, c); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
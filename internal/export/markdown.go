@@ -0,0 +1,43 @@
+package export
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"composer/internal/convert"
+)
+
+func renderMarkdown(doc *document, meta Metadata, brand Branding) ([]byte, error) {
+	body, err := renderChildren(doc.root)
+	if err != nil {
+		return nil, err
+	}
+	content, err := convert.HTMLToMarkdown(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "title: %s\n", strconv.Quote(meta.Title))
+	fmt.Fprintf(&b, "author: %s\n", strconv.Quote(meta.Author))
+	fmt.Fprintf(&b, "version: %d\n", meta.Version)
+	fmt.Fprintf(&b, "date: %s\n", meta.Date.Format("2006-01-02"))
+	b.WriteString("---\n\n")
+
+	if brand.Name != "" {
+		fmt.Fprintf(&b, "**%s**\n\n", brand.Name)
+	}
+
+	if toc := doc.toc(); len(toc) > 0 {
+		b.WriteString("## Contents\n\n")
+		for _, h := range toc {
+			fmt.Fprintf(&b, "%s- [%s](#%s)\n", strings.Repeat("  ", h.Indent), h.Text, h.ID)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString(content)
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,287 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+)
+
+const (
+	pdfLineHeight = 5.5
+	pdfListIndent = 6.0
+)
+
+var pdfHeadingSizes = map[int]float64{1: 20, 2: 16, 3: 13, 4: 12, 5: 11, 6: 11}
+
+// renderPDF lays the document out twice. The first pass finds the page each
+// heading lands on so the second can print page numbers in the table of
+// contents; the contents page looks the same either way, so the numbers stay
+// correct.
+func renderPDF(doc *document, meta Metadata, brand Branding) ([]byte, error) {
+	pages := map[string]int{}
+	if _, err := layoutPDF(doc, meta, brand, pages); err != nil {
+		return nil, err
+	}
+
+	pdf, err := layoutPDF(doc, meta, brand, pages)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type pdfWriter struct {
+	pdf   *fpdf.Fpdf
+	tr    func(string) string
+	links map[string]int
+	pages map[string]int
+	width float64
+	left  float64
+}
+
+func layoutPDF(doc *document, meta Metadata, brand Branding, pages map[string]int) (*fpdf.Fpdf, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle(meta.Title, true)
+	pdf.SetAuthor(meta.Author, true)
+	pdf.SetSubject(fmt.Sprintf("Version %d", meta.Version), true)
+	pdf.SetCreator("Composer", true)
+	pdf.SetCreationDate(meta.Date)
+	pdf.SetModificationDate(meta.Date)
+	pdf.SetMargins(20, 25, 20)
+	pdf.SetAutoPageBreak(true, 20)
+
+	w := &pdfWriter{
+		pdf:   pdf,
+		tr:    pdf.UnicodeTranslatorFromDescriptor(""),
+		links: map[string]int{},
+		pages: pages,
+	}
+	pageWidth, _ := pdf.GetPageSize()
+	w.left, _, _, _ = pdf.GetMargins()
+	w.width = pageWidth - 2*w.left
+
+	if len(brand.Logo) > 0 {
+		pdf.RegisterImageOptionsReader("logo", fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(brand.Logo))
+	}
+	pdf.SetHeaderFunc(func() {
+		if len(brand.Logo) > 0 {
+			pdf.ImageOptions("logo", w.left, 8, 0, 10, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+			pdf.SetXY(w.left+12, 8)
+		} else {
+			pdf.SetXY(w.left, 8)
+		}
+		pdf.SetFont("Helvetica", "B", 13)
+		pdf.SetTextColor(5, 109, 174)
+		pdf.CellFormat(0, 10, w.tr(brand.Name), "", 0, "L", false, 0, "")
+		pdf.SetDrawColor(5, 109, 174)
+		pdf.Line(w.left, 19, w.left+w.width, 19)
+		pdf.SetTextColor(0, 0, 0)
+		pdf.SetDrawColor(0, 0, 0)
+		pdf.SetY(25)
+	})
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-15)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(0, 10, fmt.Sprintf("Page %d", pdf.PageNo()), "", 0, "C", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	})
+
+	for _, h := range doc.headings {
+		w.links[h.ID] = pdf.AddLink()
+	}
+
+	pdf.AddPage()
+	w.metadata(meta)
+	w.toc(doc.toc())
+	for _, b := range doc.blocks {
+		w.block(b)
+	}
+
+	return pdf, pdf.Error()
+}
+
+func (w *pdfWriter) metadata(meta Metadata) {
+	w.pdf.SetFont("Helvetica", "B", 22)
+	w.pdf.MultiCell(0, 10, w.tr(meta.Title), "", "L", false)
+	w.pdf.Ln(2)
+	for _, f := range meta.fields()[1:] {
+		w.pdf.SetFont("Helvetica", "B", 9)
+		w.pdf.CellFormat(20, 5, w.tr(f[0]), "", 0, "L", false, 0, "")
+		w.pdf.SetFont("Helvetica", "", 9)
+		w.pdf.CellFormat(0, 5, w.tr(f[1]), "", 1, "L", false, 0, "")
+	}
+	w.pdf.Ln(6)
+}
+
+func (w *pdfWriter) toc(toc []heading) {
+	if len(toc) == 0 {
+		return
+	}
+
+	w.pdf.SetFont("Helvetica", "B", 14)
+	w.pdf.CellFormat(0, 8, "Contents", "", 1, "L", false, 0, "")
+	w.pdf.SetFont("Helvetica", "", 10)
+	for _, h := range toc {
+		indent := float64(h.Indent) * pdfListIndent
+		page := ""
+		if n, ok := w.pages[h.ID]; ok {
+			page = fmt.Sprintf("%d", n)
+		}
+		w.pdf.SetX(w.left + indent)
+		w.pdf.CellFormat(w.width-indent-12, pdfLineHeight+0.5, w.tr(h.Text), "", 0, "L", false, w.links[h.ID], "")
+		w.pdf.CellFormat(12, pdfLineHeight+0.5, page, "", 1, "R", false, w.links[h.ID], "")
+	}
+	w.pdf.Ln(6)
+}
+
+func (w *pdfWriter) block(b block) {
+	pdf := w.pdf
+	pdf.SetLeftMargin(w.left)
+	pdf.SetX(w.left)
+
+	switch b.Kind {
+	case blockHeading:
+		pdf.Ln(3)
+		size := pdfHeadingSizes[b.Level]
+		pdf.SetFont("Helvetica", "B", size)
+		// Keep the heading on the same page as the text that follows it.
+		_, pageHeight := pdf.GetPageSize()
+		if pdf.GetY()+size > pageHeight-30 {
+			pdf.AddPage()
+		}
+		w.pages[b.ID] = pdf.PageNo()
+		if link, ok := w.links[b.ID]; ok {
+			pdf.SetLink(link, -1, -1)
+		}
+		pdf.MultiCell(0, size*0.5, w.tr(b.plainText()), "", "L", false)
+		pdf.Ln(1.5)
+	case blockParagraph:
+		if b.Quote {
+			pdf.SetLeftMargin(w.left + pdfListIndent)
+			pdf.SetX(w.left + pdfListIndent)
+			pdf.SetTextColor(85, 85, 85)
+		}
+		w.runs(b.Runs)
+		pdf.SetTextColor(0, 0, 0)
+		pdf.Ln(pdfLineHeight + 2)
+	case blockListItem:
+		indent := w.left + float64(b.Level-1)*pdfListIndent
+		marker := "•"
+		if b.Ordered {
+			marker = fmt.Sprintf("%d.", b.Number)
+		}
+		pdf.SetX(indent)
+		pdf.SetFont("Helvetica", "", 10.5)
+		pdf.CellFormat(pdfListIndent, pdfLineHeight, w.tr(marker), "", 0, "L", false, 0, "")
+		pdf.SetLeftMargin(indent + pdfListIndent)
+		w.runs(b.Runs)
+		pdf.Ln(pdfLineHeight + 0.5)
+	case blockCode:
+		pdf.SetFont("Courier", "", 9)
+		pdf.SetFillColor(245, 247, 250)
+		pdf.MultiCell(0, 4.5, w.tr(b.Text), "", "L", true)
+		pdf.Ln(3)
+	case blockTable:
+		w.table(b)
+	case blockRule:
+		y := pdf.GetY() + 2
+		pdf.SetDrawColor(170, 170, 170)
+		pdf.Line(w.left, y, w.left+w.width, y)
+		pdf.SetDrawColor(0, 0, 0)
+		pdf.Ln(5)
+	case blockImage:
+		pdf.SetFont("Helvetica", "I", 10)
+		pdf.MultiCell(0, pdfLineHeight, w.tr(fmt.Sprintf("[Image: %s]", b.Alt)), "", "L", false)
+		pdf.Ln(2)
+	}
+	pdf.SetLeftMargin(w.left)
+}
+
+func (w *pdfWriter) runs(runs []run) {
+	pdf := w.pdf
+	for _, r := range runs {
+		if r.Break {
+			pdf.Ln(pdfLineHeight)
+			continue
+		}
+
+		family, style := "Helvetica", ""
+		if r.Code {
+			family = "Courier"
+		}
+		if r.Bold {
+			style += "B"
+		}
+		if r.Italic {
+			style += "I"
+		}
+		if r.Link != "" {
+			style += "U"
+			pdf.SetTextColor(5, 99, 193)
+		}
+		pdf.SetFont(family, style, 10.5)
+
+		text := w.tr(r.Text)
+		switch {
+		case strings.HasPrefix(r.Link, "#") && w.links[r.Link[1:]] != 0:
+			pdf.WriteLinkID(pdfLineHeight, text, w.links[r.Link[1:]])
+		case r.Link != "" && !strings.HasPrefix(r.Link, "#"):
+			pdf.WriteLinkString(pdfLineHeight, text, r.Link)
+		default:
+			pdf.Write(pdfLineHeight, text)
+		}
+		if r.Link != "" {
+			pdf.SetTextColor(0, 0, 0)
+		}
+	}
+}
+
+func (w *pdfWriter) table(b block) {
+	pdf := w.pdf
+	columns := len(b.Rows[0])
+	colWidth := w.width / float64(columns)
+	_, pageHeight := pdf.GetPageSize()
+	lineHeight := 5.0
+
+	for i, row := range b.Rows {
+		header := i == 0 && b.Header
+		if header {
+			pdf.SetFont("Helvetica", "B", 9.5)
+		} else {
+			pdf.SetFont("Helvetica", "", 9.5)
+		}
+
+		lines := 1
+		for _, cell := range row {
+			lines = max(lines, len(pdf.SplitText(w.tr(cell), colWidth-2)))
+		}
+		height := float64(lines)*lineHeight + 2
+
+		if pdf.GetY()+height > pageHeight-20 {
+			pdf.AddPage()
+		}
+
+		y := pdf.GetY()
+		for j, cell := range row {
+			x := w.left + float64(j)*colWidth
+			if header {
+				pdf.SetFillColor(235, 240, 247)
+				pdf.Rect(x, y, colWidth, height, "FD")
+			} else {
+				pdf.Rect(x, y, colWidth, height, "D")
+			}
+			pdf.SetXY(x+1, y+1)
+			pdf.MultiCell(colWidth-2, lineHeight, w.tr(cell), "", "L", false)
+		}
+		pdf.SetXY(w.left, y+height)
+	}
+	pdf.Ln(4)
+}
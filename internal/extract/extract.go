@@ -0,0 +1,70 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/tmc/langchaingo/documentloaders"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// Extensions lists the file types text can be extracted from.
+var Extensions = []string{".txt", ".md", ".markdown", ".csv", ".pdf", ".html", ".htm"}
+
+func Supported(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, e := range Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// Documents loads a file with the matching langchaingo document loader. PDFs
+// yield one document per page and CSVs one per row.
+func Documents(ctx context.Context, filename string, data []byte) ([]schema.Document, error) {
+	var loader documentloaders.Loader
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".txt", ".md", ".markdown":
+		loader = documentloaders.NewText(bytes.NewReader(data))
+	case ".csv":
+		loader = documentloaders.NewCSV(bytes.NewReader(data))
+	case ".pdf":
+		loader = documentloaders.NewPDF(bytes.NewReader(data), int64(len(data)))
+	case ".html", ".htm":
+		loader = documentloaders.NewHTML(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported file type %q, expected one of %s", filepath.Ext(filename), strings.Join(Extensions, ", "))
+	}
+
+	docs, err := loader.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("extracting text from %s: %w", filename, err)
+	}
+	return docs, nil
+}
+
+// Text returns all of the text in a file. Files without any, such as scanned
+// PDFs, are reported as an error since they are useless as references.
+func Text(ctx context.Context, filename string, data []byte) (string, error) {
+	docs, err := Documents(ctx, filename, data)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, 0, len(docs))
+	for _, d := range docs {
+		if text := strings.TrimSpace(d.PageContent); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("%s contains no extractable text", filename)
+	}
+
+	return strings.Join(parts, "\n\n"), nil
+}
@@ -0,0 +1,31 @@
+package guardrails
+
+import "regexp"
+
+// injectionSignals are phrasings typical of text trying to take over the
+// model. They are heuristics: they catch the common cases, not a determined
+// attacker.
+var injectionSignals = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"asks to ignore instructions", regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\b[^.\n]{0,40}\b(previous|prior|above|earlier|all|system|your)\b[^.\n]{0,20}\b(instructions?|prompts?|rules|directions)\b`)},
+	{"asks to reveal the prompt", regexp.MustCompile(`(?i)\b(reveal|print|show|repeat|output)\b[^.\n]{0,30}\b(system prompt|your (instructions|prompt))\b`)},
+	{"reassigns the model's role", regexp.MustCompile(`(?i)\b(you are now|from now on,? you|act as an? (unrestricted|unfiltered))\b`)},
+	{"names a jailbreak", regexp.MustCompile(`(?i)\b(do anything now|jailbreak|developer mode)\b`)},
+	{"imitates a chat role", regexp.MustCompile(`(?im)^\s*(system|assistant)\s*:`)},
+	{"contains response format tags", regexp.MustCompile(`(?i)</?(artifact|explanation|edit|textToReplace|replacement|references|user_edits|selected_text|guardrails)\b[^>]*>`)},
+}
+
+// injectionSignal names the first signal found in text, or returns "".
+func injectionSignal(text string) string {
+	if text == "" {
+		return ""
+	}
+	for _, s := range injectionSignals {
+		if s.re.MatchString(text) {
+			return s.name
+		}
+	}
+	return ""
+}
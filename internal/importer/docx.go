@@ -0,0 +1,365 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+const relationshipsNS = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+
+// maxPartSize bounds the uncompressed size of each part read from a
+// document, so that a small upload can't expand into gigabytes. The text of
+// even very long documents is a fraction of this.
+const maxPartSize = 32 << 20
+
+// xmlNode is a generic element tree; WordprocessingML is easier to walk this
+// way than with typed structs for every element it can contain.
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Text    string     `xml:",chardata"`
+	Nodes   []xmlNode  `xml:",any"`
+}
+
+func (n *xmlNode) child(name string) *xmlNode {
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local == name {
+			return &n.Nodes[i]
+		}
+	}
+	return nil
+}
+
+func (n *xmlNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// docxToHTML extracts the structure of a Word document (headings, paragraphs,
+// lists, tables, emphasis and links) as HTML. Layout and styling beyond that
+// are dropped.
+func docxToHTML(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+
+	var document, rels, numbering *xmlNode
+	for _, f := range zr.File {
+		var target **xmlNode
+		switch f.Name {
+		case "word/document.xml":
+			target = &document
+		case "word/_rels/document.xml.rels":
+			target = &rels
+		case "word/numbering.xml":
+			target = &numbering
+		default:
+			continue
+		}
+		if *target, err = readXML(f); err != nil {
+			return "", fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	if document == nil {
+		return "", fmt.Errorf("not a Word document: word/document.xml is missing")
+	}
+
+	c := &docxConverter{
+		links:   map[string]string{},
+		ordered: orderedLists(numbering),
+	}
+	if rels != nil {
+		for _, r := range rels.Nodes {
+			if strings.HasSuffix(r.attr("Type"), "/hyperlink") {
+				c.links[r.attr("Id")] = r.attr("Target")
+			}
+		}
+	}
+
+	body := document.child("body")
+	if body == nil {
+		return "", nil
+	}
+	c.blocks(body.Nodes)
+	c.closeLists()
+
+	return c.out.String(), nil
+}
+
+func readXML(f *zip.File) (*xmlNode, error) {
+	tooLarge := fmt.Errorf("larger than %d MB uncompressed", maxPartSize>>20)
+	if f.UncompressedSize64 > maxPartSize {
+		return nil, tooLarge
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	// The size in the header is the archive's word for it; the reader is
+	// limited too.
+	raw, err := io.ReadAll(io.LimitReader(rc, maxPartSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > maxPartSize {
+		return nil, tooLarge
+	}
+
+	var n xmlNode
+	if err := xml.Unmarshal(raw, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// orderedLists maps "numId:level" to whether that list level is numbered
+// rather than bulleted.
+func orderedLists(numbering *xmlNode) map[string]bool {
+	ordered := map[string]bool{}
+	if numbering == nil {
+		return ordered
+	}
+
+	abstract := map[string]map[string]bool{}
+	for _, n := range numbering.Nodes {
+		if n.XMLName.Local != "abstractNum" {
+			continue
+		}
+		levels := map[string]bool{}
+		for _, lvl := range n.Nodes {
+			if lvl.XMLName.Local != "lvl" {
+				continue
+			}
+			if f := lvl.child("numFmt"); f != nil {
+				levels[lvl.attr("ilvl")] = f.attr("val") != "bullet" && f.attr("val") != "none"
+			}
+		}
+		abstract[n.attr("abstractNumId")] = levels
+	}
+
+	for _, n := range numbering.Nodes {
+		if n.XMLName.Local != "num" {
+			continue
+		}
+		if ref := n.child("abstractNumId"); ref != nil {
+			for level, isOrdered := range abstract[ref.attr("val")] {
+				ordered[n.attr("numId")+":"+level] = isOrdered
+			}
+		}
+	}
+	return ordered
+}
+
+type docxConverter struct {
+	out     strings.Builder
+	links   map[string]string
+	ordered map[string]bool
+	lists   []string
+}
+
+func (c *docxConverter) blocks(nodes []xmlNode) {
+	for i := range nodes {
+		n := &nodes[i]
+		switch n.XMLName.Local {
+		case "p":
+			c.paragraph(n)
+		case "tbl":
+			c.closeLists()
+			c.table(n)
+		case "sdt":
+			if content := n.child("sdtContent"); content != nil {
+				c.blocks(content.Nodes)
+			}
+		}
+	}
+}
+
+func (c *docxConverter) paragraph(p *xmlNode) {
+	content := strings.TrimSpace(c.inline(p.Nodes))
+	style, level, numID := "", -1, ""
+	if props := p.child("pPr"); props != nil {
+		if s := props.child("pStyle"); s != nil {
+			style = s.attr("val")
+		}
+		if num := props.child("numPr"); num != nil {
+			level = 0
+			if l := num.child("ilvl"); l != nil {
+				fmt.Sscanf(l.attr("val"), "%d", &level)
+			}
+			if id := num.child("numId"); id != nil {
+				numID = id.attr("val")
+			}
+		}
+	}
+
+	if level >= 0 && numID != "0" {
+		tag := "ul"
+		if c.ordered[fmt.Sprintf("%s:%d", numID, level)] {
+			tag = "ol"
+		}
+		c.listItem(level, tag, content)
+		return
+	}
+
+	c.closeLists()
+	if content == "" {
+		return
+	}
+
+	tag := "p"
+	switch {
+	case style == "Title":
+		tag = "h1"
+	case strings.HasPrefix(style, "Heading") && len(style) == len("Heading")+1:
+		if d := style[len(style)-1]; d >= '1' && d <= '6' {
+			tag = "h" + string(d)
+		}
+	case style == "Quote" || style == "IntenseQuote":
+		c.out.WriteString("<blockquote><p>" + content + "</p></blockquote>\n")
+		return
+	}
+	c.out.WriteString("<" + tag + ">" + content + "</" + tag + ">\n")
+}
+
+// listItem keeps a stack of open lists so that Word's flat, indented list
+// paragraphs become nested HTML lists.
+func (c *docxConverter) listItem(level int, tag, content string) {
+	for len(c.lists) > level+1 {
+		c.closeList()
+	}
+	if len(c.lists) == level+1 {
+		if c.lists[level] != tag {
+			c.closeList()
+		} else {
+			c.out.WriteString("</li>\n")
+		}
+	}
+	for len(c.lists) < level+1 {
+		c.out.WriteString("<" + tag + ">\n")
+		c.lists = append(c.lists, tag)
+	}
+	c.out.WriteString("<li>" + content)
+}
+
+func (c *docxConverter) closeList() {
+	tag := c.lists[len(c.lists)-1]
+	c.lists = c.lists[:len(c.lists)-1]
+	c.out.WriteString("</li>\n</" + tag + ">\n")
+}
+
+func (c *docxConverter) closeLists() {
+	for len(c.lists) > 0 {
+		c.closeList()
+	}
+}
+
+func (c *docxConverter) table(t *xmlNode) {
+	c.out.WriteString("<table>\n")
+	first := true
+	for _, row := range t.Nodes {
+		if row.XMLName.Local != "tr" {
+			continue
+		}
+		cellTag := "td"
+		if first {
+			cellTag = "th"
+			first = false
+		}
+		c.out.WriteString("<tr>")
+		for _, cell := range row.Nodes {
+			if cell.XMLName.Local != "tc" {
+				continue
+			}
+			var paras []string
+			for _, p := range cell.Nodes {
+				if p.XMLName.Local == "p" {
+					if text := strings.TrimSpace(c.inline(p.Nodes)); text != "" {
+						paras = append(paras, text)
+					}
+				}
+			}
+			c.out.WriteString("<" + cellTag + ">" + strings.Join(paras, "<br/>") + "</" + cellTag + ">")
+		}
+		c.out.WriteString("</tr>\n")
+	}
+	c.out.WriteString("</table>\n")
+}
+
+func (c *docxConverter) inline(nodes []xmlNode) string {
+	var b strings.Builder
+	for i := range nodes {
+		n := &nodes[i]
+		switch n.XMLName.Local {
+		case "r":
+			b.WriteString(c.run(n))
+		case "hyperlink":
+			text := c.inline(n.Nodes)
+			target := ""
+			for _, a := range n.Attrs {
+				if a.Name.Local == "id" && a.Name.Space == relationshipsNS {
+					target = c.links[a.Value]
+				}
+			}
+			if target == "" {
+				b.WriteString(text)
+				continue
+			}
+			fmt.Fprintf(&b, `<a href="%s">%s</a>`, html.EscapeString(target), text)
+		case "ins", "smartTag", "fldSimple":
+			b.WriteString(c.inline(n.Nodes))
+		}
+	}
+	return b.String()
+}
+
+func (c *docxConverter) run(r *xmlNode) string {
+	var text strings.Builder
+	for _, n := range r.Nodes {
+		switch n.XMLName.Local {
+		case "t":
+			text.WriteString(html.EscapeString(n.Text))
+		case "tab":
+			text.WriteString(" ")
+		case "br", "cr":
+			text.WriteString("<br/>")
+		}
+	}
+
+	out := text.String()
+	if strings.TrimSpace(out) == "" {
+		return out
+	}
+	if props := r.child("rPr"); props != nil {
+		if enabled(props.child("i")) {
+			out = "<em>" + out + "</em>"
+		}
+		if enabled(props.child("b")) {
+			out = "<strong>" + out + "</strong>"
+		}
+	}
+	return out
+}
+
+// enabled reports whether a toggle property such as <w:b/> is switched on.
+func enabled(n *xmlNode) bool {
+	if n == nil {
+		return false
+	}
+	switch n.attr("val") {
+	case "0", "false", "off":
+		return false
+	}
+	return true
+}
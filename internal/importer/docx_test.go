@@ -0,0 +1,68 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// docx zips files into a document.
+func docx(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const documentXML = `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:pPr><w:pStyle w:val="Heading1"/></w:pPr><w:r><w:t>Title</w:t></w:r></w:p>
+<w:p><w:r><w:rPr><w:b/></w:rPr><w:t>Bold</w:t></w:r><w:r><w:t> text</w:t></w:r></w:p>
+</w:body></w:document>`
+
+func TestDocxToHTML(t *testing.T) {
+	got, err := docxToHTML(docx(t, map[string]string{"word/document.xml": documentXML}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<h1>Title</h1>", "<strong>Bold</strong> text"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("docxToHTML() = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestDocxToHTMLRejectsLargeParts(t *testing.T) {
+	// Compresses to a few kilobytes.
+	huge := documentXML[:len(documentXML)-len("</w:body></w:document>")] + strings.Repeat(" ", maxPartSize) + "</w:body></w:document>"
+	data := docx(t, map[string]string{"word/document.xml": huge})
+	if len(data) > maxPartSize/100 {
+		t.Fatalf("test document is %d bytes, expected it to compress", len(data))
+	}
+
+	_, err := docxToHTML(data)
+	if err == nil || !strings.Contains(err.Error(), "uncompressed") {
+		t.Fatalf("docxToHTML() error = %v, want a size error", err)
+	}
+}
+
+func TestDocxToHTMLRequiresDocument(t *testing.T) {
+	if _, err := docxToHTML(docx(t, map[string]string{"word/styles.xml": "<styles/>"})); err == nil {
+		t.Fatal("docxToHTML() of a zip without word/document.xml succeeded")
+	}
+	if _, err := docxToHTML([]byte("not a zip")); err == nil {
+		t.Fatal("docxToHTML() of a non-zip succeeded")
+	}
+}
@@ -0,0 +1,74 @@
+package importer
+
+import (
+	"fmt"
+	"html"
+	"path/filepath"
+	"strings"
+
+	"composer/internal/convert"
+	"composer/internal/sanitize"
+)
+
+// Extensions lists the file types Import understands.
+var Extensions = []string{".docx", ".md", ".markdown", ".html", ".htm", ".txt"}
+
+// Import converts an uploaded document into an artifact in the given format.
+// The file type is taken from the file name's extension.
+func Import(filename string, data []byte, to convert.Format) (string, error) {
+	var (
+		source string
+		from   convert.Format
+		err    error
+	)
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".docx":
+		source, err = docxToHTML(data)
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", filename, err)
+		}
+		from = convert.HTML
+	case ".md", ".markdown":
+		source, from = string(data), convert.Markdown
+	case ".html", ".htm":
+		source, from = string(data), convert.HTML
+	case ".txt":
+		if to == convert.Markdown {
+			return normalizeNewlines(string(data)), nil
+		}
+		source, from = textToHTML(string(data)), convert.HTML
+	default:
+		return "", fmt.Errorf("unsupported file type %q, expected one of %s", filepath.Ext(filename), strings.Join(Extensions, ", "))
+	}
+
+	if from == convert.HTML {
+		source, _ = sanitize.Artifact(source, false)
+	}
+
+	switch {
+	case from == to:
+		return source, nil
+	case to == convert.Markdown:
+		return convert.HTMLToMarkdown(source)
+	default:
+		return convert.MarkdownToHTML(source)
+	}
+}
+
+func textToHTML(text string) string {
+	var b strings.Builder
+	for _, para := range strings.Split(normalizeNewlines(text), "\n\n") {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+		lines := strings.Split(html.EscapeString(para), "\n")
+		b.WriteString("<p>" + strings.Join(lines, "<br/>") + "</p>\n")
+	}
+	return b.String()
+}
+
+func normalizeNewlines(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
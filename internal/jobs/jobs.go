@@ -0,0 +1,293 @@
+// Package jobs runs generations, exports and imports in the background.
+//
+// Jobs are queued in the database and leased by a pool of workers, so that
+// instances sharing a database share the work, and a job whose worker died
+// is picked up again once its lease expires. A worker runs a job by replaying
+// the API request it was submitted as, on behalf of the job's user, so jobs
+// get the same permissions, limits and audit log as requests made directly.
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/blobstore"
+	"composer/internal/db"
+	"composer/internal/models"
+	"composer/internal/webhooks"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Config sizes the worker pool. A job is tried up to MaxAttempts times. A
+// worker holds a job for Lease at a time, renewing it while the job runs.
+type Config struct {
+	Workers     int
+	MaxAttempts int
+	Lease       time.Duration
+	Poll        time.Duration
+}
+
+const (
+	retryBase = 10 * time.Second
+	retryMax  = 10 * time.Minute
+)
+
+type Queue struct {
+	db       *db.Db
+	blobs    blobstore.Store
+	handler  http.Handler
+	cfg      Config
+	owner    string
+	wake     chan struct{}
+	webhooks *webhooks.Dispatcher
+}
+
+// New returns a queue whose jobs are replayed against handler, the API, and
+// whose outcomes are sent to their webhooks by dispatcher.
+func New(database *db.Db, blobs blobstore.Store, handler http.Handler, dispatcher *webhooks.Dispatcher, cfg Config) *Queue {
+	if cfg.Poll == 0 {
+		cfg.Poll = time.Second
+	}
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return &Queue{
+		db:       database,
+		blobs:    blobs,
+		handler:  handler,
+		cfg:      cfg,
+		owner:    host + "-" + hex.EncodeToString(b),
+		wake:     make(chan struct{}, 1),
+		webhooks: dispatcher,
+	}
+}
+
+// Submit queues j, a request to replay with body, on behalf of j.UserID and
+// j.APIKeyID.
+func (q *Queue) Submit(ctx context.Context, j *models.Job, body []byte) error {
+	if len(body) > 0 {
+		j.Request.BodyKey = blobstore.NewKey("jobs")
+		if err := q.blobs.Put(ctx, j.Request.BodyKey, body); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	j.Status = models.JobQueued
+	j.MaxAttempts = q.cfg.MaxAttempts
+	j.RunAfter, j.CreatedAt = now, now
+	if err := q.db.WithContext(ctx).InsertJob(j); err != nil {
+		return err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Result returns the response body of a succeeded job.
+func (q *Queue) Result(ctx context.Context, j *models.Job) ([]byte, error) {
+	return q.blobs.Get(ctx, j.ResultKey)
+}
+
+// Start runs the workers until ctx is done. Jobs interrupted by shutdown are
+// queued again.
+func (q *Queue) Start(ctx context.Context) {
+	if q.cfg.Workers <= 0 {
+		return
+	}
+	for i := 0; i < q.cfg.Workers; i++ {
+		go q.work(ctx)
+	}
+	go q.reap(ctx)
+	slog.Info("started job workers", "workers", q.cfg.Workers, "owner", q.owner)
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now()
+		j, err := q.db.ClaimJob(q.owner, now, now.Add(q.cfg.Lease))
+		if err != nil {
+			slog.Error("claiming a job", "error", err)
+		}
+		if j != nil {
+			q.run(ctx, j)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-time.After(q.cfg.Poll):
+		}
+	}
+}
+
+// reap fails jobs whose worker stopped during their last attempt.
+func (q *Queue) reap(ctx context.Context) {
+	ticker := time.NewTicker(q.cfg.Lease)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		failed, err := q.db.FailExhaustedJobs(time.Now())
+		if err != nil {
+			slog.Error("failing abandoned jobs", "error", err)
+		}
+		for _, j := range failed {
+			slog.Warn("job abandoned", "job_id", j.ID, "kind", j.Kind, "attempts", j.Attempts)
+			q.notify(ctx, j)
+		}
+	}
+}
+
+// run makes one attempt at j, renewing its lease until the attempt is over.
+func (q *Queue) run(ctx context.Context, j *models.Job) {
+	logger := slog.With("job_id", j.ID, "kind", j.Kind, "attempt", j.Attempts)
+	start := time.Now()
+
+	runCtx, cancel := context.WithCancel(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(q.cfg.Lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			ok, err := q.db.ExtendJobLease(j.ID, q.owner, time.Now().Add(q.cfg.Lease))
+			if err != nil {
+				logger.Error("renewing job lease", "error", err)
+			} else if !ok {
+				logger.Warn("lost job lease")
+				cancel()
+				return
+			}
+		}
+	}()
+
+	rec, err := q.replay(runCtx, j)
+	cancel()
+	<-renewed
+
+	now := time.Now()
+	switch {
+	case ctx.Err() != nil:
+		j.Status, j.Error, j.RunAfter = models.JobQueued, "interrupted by shutdown", now
+	case err != nil:
+		q.retry(j, err.Error(), 0, now)
+	case rec.Code < 300:
+		j.ResultKey = blobstore.NewKey("jobs")
+		if err := q.blobs.Put(context.WithoutCancel(ctx), j.ResultKey, rec.Body.Bytes()); err != nil {
+			q.retry(j, "storing the result: "+err.Error(), 0, now)
+			break
+		}
+		j.Status, j.Error = models.JobSucceeded, ""
+		j.ResultStatus, j.ResultType = rec.Code, rec.Header().Get(echo.HeaderContentType)
+	case rec.Code == http.StatusTooManyRequests || rec.Code >= 500,
+		// An earlier attempt whose lease was lost may still be running.
+		rec.Code == http.StatusConflict && rec.Header().Get("Retry-After") != "":
+		retryAfter, _ := strconv.Atoi(rec.Header().Get("Retry-After"))
+		q.retry(j, responseMessage(rec), time.Duration(retryAfter)*time.Second, now)
+	default:
+		// The request itself is wrong, so trying again won't help.
+		j.Status, j.Error, j.ResultStatus = models.JobFailed, responseMessage(rec), rec.Code
+	}
+
+	ok, err := q.db.FinishJob(j, q.owner)
+	if err != nil {
+		logger.Error("recording job outcome", "error", err)
+		return
+	}
+	if !ok {
+		logger.Warn("job lease lost before it finished; discarding its outcome")
+		if j.ResultKey != "" {
+			q.blobs.Delete(context.WithoutCancel(ctx), j.ResultKey)
+		}
+		return
+	}
+
+	logger.Info("ran job", "status", j.Status, "result_status", j.ResultStatus, "duration_ms", time.Since(start).Milliseconds())
+	if j.Done() {
+		q.notify(context.WithoutCancel(ctx), j)
+	}
+}
+
+// retry queues j again with exponential backoff, or fails it if it has no
+// attempts left.
+func (q *Queue) retry(j *models.Job, msg string, after time.Duration, now time.Time) {
+	j.Error = msg
+	if j.Attempts >= j.MaxAttempts {
+		j.Status = models.JobFailed
+		return
+	}
+
+	backoff := retryBase << (j.Attempts - 1)
+	if backoff > retryMax || backoff <= 0 {
+		backoff = retryMax
+	}
+	j.Status, j.RunAfter = models.JobQueued, now.Add(max(backoff, after))
+}
+
+// replay sends the job's request to the API on behalf of its user.
+func (q *Queue) replay(ctx context.Context, j *models.Job) (*httptest.ResponseRecorder, error) {
+	var body []byte
+	if j.Request.BodyKey != "" {
+		var err error
+		if body, err = q.blobs.Get(ctx, j.Request.BodyKey); err != nil {
+			return nil, fmt.Errorf("reading the request: %w", err)
+		}
+	}
+
+	ctx = auth.WithPrincipal(ctx, auth.Principal{UserID: j.UserID, APIKeyID: j.APIKeyID})
+	req := httptest.NewRequest(j.Request.Method, j.Request.Path, bytes.NewReader(body)).WithContext(ctx)
+	req.RemoteAddr = "127.0.0.1:0"
+	req.Header.Set(echo.HeaderXRequestID, fmt.Sprintf("job-%s-%d", j.ID, j.Attempts))
+	// Every attempt carries the same key, so that retrying a generation that
+	// failed after saving its session or paying for the model doesn't do so
+	// again. The API ignores it elsewhere.
+	req.Header.Set("Idempotency-Key", "job-"+j.ID)
+	if j.Request.ContentType != "" {
+		req.Header.Set(echo.HeaderContentType, j.Request.ContentType)
+	}
+
+	rec := httptest.NewRecorder()
+	q.handler.ServeHTTP(rec, req)
+	return rec, nil
+}
+
+// responseMessage is the error message of a failed API response.
+func responseMessage(rec *httptest.ResponseRecorder) string {
+	var body struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(rec.Body.Bytes(), &body) == nil && body.Message != "" {
+		return body.Message
+	}
+	if text := strings.TrimSpace(rec.Body.String()); text != "" && len(text) < 500 {
+		return text
+	}
+	return http.StatusText(rec.Code)
+}
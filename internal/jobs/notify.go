@@ -0,0 +1,35 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"composer/internal/models"
+)
+
+// notify queues the finished job for its webhook, if it has one. The webhook
+// is only created now, from the URL and secret the job was submitted with,
+// and delivered like any other: signed, logged, and retried until the
+// receiver accepts it.
+func (q *Queue) notify(ctx context.Context, j *models.Job) {
+	if j.WebhookURL == "" || q.webhooks == nil {
+		return
+	}
+	database := q.db.WithContext(ctx)
+	w := &models.Webhook{
+		JobID:     j.ID,
+		URL:       j.WebhookURL,
+		Events:    []string{models.EventJobSucceeded, models.EventJobFailed},
+		Secret:    j.WebhookSecret,
+		CreatedBy: j.UserID,
+		CreatedAt: time.Now(),
+	}
+	if err := database.InsertWebhook(w); err != nil {
+		slog.Error("creating job webhook", "job_id", j.ID, "error", err)
+		return
+	}
+	if _, err := q.webhooks.Send(ctx, w, "job."+j.Status, j); err != nil {
+		slog.Error("queueing job notification", "job_id", j.ID, "error", err)
+	}
+}
@@ -0,0 +1,88 @@
+package knowledge
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/tmc/langchaingo/embeddings"
+)
+
+const hashDimensions = 512
+
+// HashEmbedder embeds text by hashing its words and word pairs into a fixed
+// number of buckets. It needs no model or network access, which makes the
+// knowledge base usable offline, at the cost of only matching on shared
+// vocabulary.
+type HashEmbedder struct {
+	Dimensions int
+}
+
+var _ embeddings.Embedder = HashEmbedder{}
+
+func NewHashEmbedder() HashEmbedder {
+	return HashEmbedder{Dimensions: hashDimensions}
+}
+
+func (h HashEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = h.embed(text)
+	}
+	return vectors, nil
+}
+
+func (h HashEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	return h.embed(text), nil
+}
+
+func (h HashEmbedder) embed(text string) []float32 {
+	dims := h.Dimensions
+	if dims <= 0 {
+		dims = hashDimensions
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	counts := make([]float64, dims)
+	add := func(feature string) {
+		f := fnv.New64a()
+		f.Write([]byte(feature))
+		sum := f.Sum64()
+		// The top bit picks a sign so that collisions tend to cancel out.
+		sign := 1.0
+		if sum>>63 == 1 {
+			sign = -1.0
+		}
+		counts[sum%uint64(dims)] += sign
+	}
+	for i, w := range words {
+		add(w)
+		if i > 0 {
+			add(words[i-1] + " " + w)
+		}
+	}
+
+	var norm float64
+	for i, c := range counts {
+		// Dampen term frequency so repeated words don't dominate.
+		if c != 0 {
+			counts[i] = math.Copysign(1+math.Log(math.Abs(c)), c)
+		}
+		norm += counts[i] * counts[i]
+	}
+	norm = math.Sqrt(norm)
+
+	vector := make([]float32, dims)
+	if norm == 0 {
+		return vector
+	}
+	for i, c := range counts {
+		vector[i] = float32(c / norm)
+	}
+	return vector
+}
@@ -0,0 +1,213 @@
+package knowledge
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"composer/internal/convert"
+	"composer/internal/db"
+	"composer/internal/sanitize"
+
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/textsplitter"
+	"github.com/tmc/langchaingo/vectorstores"
+	"github.com/tmc/langchaingo/vectorstores/pgvector"
+)
+
+// DefaultWorkspace holds the knowledge base shared by every session.
+const DefaultWorkspace = "default"
+
+const (
+	metadataSource = "source"
+	metadataChunk  = "chunk"
+)
+
+// Extensions lists the file types that are ingested from a directory.
+var Extensions = []string{".md", ".markdown", ".html", ".htm"}
+
+// Opener returns the vector store holding a workspace's knowledge base.
+type Opener func(ctx context.Context, workspace string) (vectorstores.VectorStore, error)
+
+func SQLOpener(database *db.Db, embedder embeddings.Embedder) Opener {
+	return func(_ context.Context, workspace string) (vectorstores.VectorStore, error) {
+		return NewSQLStore(database, embedder, workspace), nil
+	}
+}
+
+// PgvectorOpener keeps each workspace in its own pgvector collection.
+// pgvector appends on every ingest, so re-ingesting a source duplicates it.
+func PgvectorOpener(connectionURL string, embedder embeddings.Embedder) Opener {
+	return func(ctx context.Context, workspace string) (vectorstores.VectorStore, error) {
+		return pgvector.New(ctx,
+			pgvector.WithConnectionURL(connectionURL),
+			pgvector.WithEmbedder(embedder),
+			pgvector.WithCollectionName("knowledge_"+workspace),
+		)
+	}
+}
+
+type Passage struct {
+	Source  string  `json:"source"`
+	Chunk   int     `json:"chunk"`
+	Content string  `json:"content"`
+	Score   float32 `json:"score"`
+}
+
+type Base struct {
+	open     Opener
+	splitter textsplitter.TextSplitter
+
+	mu     sync.Mutex
+	stores map[string]vectorstores.VectorStore
+}
+
+func New(open Opener) *Base {
+	return &Base{
+		open: open,
+		splitter: textsplitter.NewMarkdownTextSplitter(
+			textsplitter.WithChunkSize(1200),
+			textsplitter.WithChunkOverlap(150),
+			textsplitter.WithHeadingHierarchy(true),
+		),
+		stores: map[string]vectorstores.VectorStore{},
+	}
+}
+
+func (b *Base) store(ctx context.Context, workspace string) (vectorstores.VectorStore, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s, ok := b.stores[workspace]; ok {
+		return s, nil
+	}
+	s, err := b.open(ctx, workspace)
+	if err != nil {
+		return nil, err
+	}
+	b.stores[workspace] = s
+	return s, nil
+}
+
+// IngestDir chunks and embeds every Markdown and HTML file under dir. Sources
+// are recorded relative to root, so that passages cite stable paths.
+func (b *Base) IngestDir(ctx context.Context, workspace, root, dir string) (int, error) {
+	total := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !supported(path) {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		source, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		n, err := b.IngestFile(ctx, workspace, filepath.ToSlash(source), data)
+		if err != nil {
+			return fmt.Errorf("ingesting %s: %w", source, err)
+		}
+		total += n
+		return nil
+	})
+	return total, err
+}
+
+// IngestFile adds a single Markdown or HTML document and returns the number
+// of chunks stored for it.
+func (b *Base) IngestFile(ctx context.Context, workspace, source string, data []byte) (int, error) {
+	text := string(data)
+	switch strings.ToLower(filepath.Ext(source)) {
+	case ".html", ".htm":
+		cleaned, _ := sanitize.HTML(text)
+		converted, err := convert.HTMLToMarkdown(cleaned)
+		if err != nil {
+			return 0, err
+		}
+		text = converted
+	case ".md", ".markdown":
+	default:
+		return 0, fmt.Errorf("unsupported file type %q, expected one of %s", filepath.Ext(source), strings.Join(Extensions, ", "))
+	}
+
+	chunks, err := b.splitter.SplitText(text)
+	if err != nil {
+		return 0, err
+	}
+
+	docs := make([]schema.Document, 0, len(chunks))
+	for _, chunk := range chunks {
+		if strings.TrimSpace(chunk) == "" {
+			continue
+		}
+		docs = append(docs, schema.Document{
+			PageContent: chunk,
+			Metadata:    map[string]any{metadataSource: source, metadataChunk: len(docs)},
+		})
+	}
+	if len(docs) == 0 {
+		return 0, nil
+	}
+
+	s, err := b.store(ctx, workspace)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.AddDocuments(ctx, docs); err != nil {
+		return 0, err
+	}
+	return len(docs), nil
+}
+
+// Search returns the k passages most similar to query.
+func (b *Base) Search(ctx context.Context, workspace, query string, k int) ([]Passage, error) {
+	s, err := b.store(ctx, workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	docs, err := s.SimilaritySearch(ctx, query, k, vectorstores.WithScoreThreshold(minScore))
+	if err != nil {
+		return nil, err
+	}
+
+	passages := make([]Passage, len(docs))
+	for i, d := range docs {
+		passages[i] = Passage{Content: d.PageContent, Score: d.Score}
+		passages[i].Source, _ = d.Metadata[metadataSource].(string)
+		// The chunk number is an int when it comes from SQLStore and a JSON
+		// number when it has been round-tripped through pgvector.
+		switch chunk := d.Metadata[metadataChunk].(type) {
+		case int:
+			passages[i].Chunk = chunk
+		case float64:
+			passages[i].Chunk = int(chunk)
+		}
+	}
+	return passages, nil
+}
+
+// Passages scoring below this are too loosely related to help the model.
+const minScore = 0.1
+
+func supported(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package knowledge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"composer/internal/db"
+	"composer/internal/models"
+
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/vectorstores"
+)
+
+// SQLStore is a vectorstores.VectorStore over the knowledge_chunks table. It
+// scores every chunk of a workspace in process, which is fine for a team's
+// documentation but won't scale to millions of chunks; use pgvector for that.
+type SQLStore struct {
+	database  *db.Db
+	embedder  embeddings.Embedder
+	workspace string
+}
+
+var _ vectorstores.VectorStore = SQLStore{}
+
+func NewSQLStore(database *db.Db, embedder embeddings.Embedder, workspace string) SQLStore {
+	return SQLStore{database: database, embedder: embedder, workspace: workspace}
+}
+
+// AddDocuments stores documents grouped by their "source" metadata. Adding a
+// source replaces everything previously stored for it, so re-ingesting a
+// directory doesn't duplicate chunks.
+func (s SQLStore) AddDocuments(ctx context.Context, docs []schema.Document, options ...vectorstores.Option) ([]string, error) {
+	opts := s.options(options)
+
+	texts := make([]string, len(docs))
+	for i, d := range docs {
+		texts[i] = d.PageContent
+	}
+	vectors, err := opts.Embedder.EmbedDocuments(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != len(docs) {
+		return nil, errors.New("embedder returned the wrong number of vectors")
+	}
+
+	var order []string
+	bySource := map[string][]*models.KnowledgeChunk{}
+	for i, d := range docs {
+		source, _ := d.Metadata[metadataSource].(string)
+		if _, ok := bySource[source]; !ok {
+			order = append(order, source)
+		}
+		bySource[source] = append(bySource[source], &models.KnowledgeChunk{
+			Workspace:  opts.NameSpace,
+			Source:     source,
+			ChunkIndex: len(bySource[source]),
+			Content:    d.PageContent,
+			Metadata:   d.Metadata,
+			Embedding:  vectors[i],
+		})
+	}
+
+	var ids []string
+	for _, source := range order {
+		if err := s.database.WithContext(ctx).ReplaceKnowledgeSource(opts.NameSpace, source, bySource[source]); err != nil {
+			return nil, fmt.Errorf("storing %s: %w", source, err)
+		}
+		for _, chunk := range bySource[source] {
+			ids = append(ids, chunk.ID)
+		}
+	}
+	return ids, nil
+}
+
+func (s SQLStore) SimilaritySearch(ctx context.Context, query string, numDocuments int, options ...vectorstores.Option) ([]schema.Document, error) {
+	opts := s.options(options)
+
+	vector, err := opts.Embedder.EmbedQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := s.database.WithContext(ctx).ListKnowledgeChunks(opts.NameSpace)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []schema.Document
+	for _, chunk := range chunks {
+		if len(chunk.Embedding) != len(vector) {
+			continue
+		}
+		score := cosine(vector, chunk.Embedding)
+		if score < opts.ScoreThreshold {
+			continue
+		}
+
+		metadata := map[string]any{}
+		for k, v := range chunk.Metadata {
+			metadata[k] = v
+		}
+		metadata[metadataSource] = chunk.Source
+		metadata[metadataChunk] = chunk.ChunkIndex
+		docs = append(docs, schema.Document{PageContent: chunk.Content, Metadata: metadata, Score: score})
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool { return docs[i].Score > docs[j].Score })
+	if len(docs) > numDocuments {
+		docs = docs[:numDocuments]
+	}
+	return docs, nil
+}
+
+func (s SQLStore) options(options []vectorstores.Option) vectorstores.Options {
+	opts := vectorstores.Options{NameSpace: s.workspace, Embedder: s.embedder}
+	for _, o := range options {
+		o(&opts)
+	}
+	return opts
+}
+
+func cosine(a, b []float32) float32 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(na) * math.Sqrt(nb)))
+}
@@ -0,0 +1,85 @@
+// Package logging sets up structured logging with log/slog.
+//
+// Documents, prompts and model output are confidential, so they are only
+// logged through Content, which withholds them unless content logging was
+// explicitly turned on for debugging.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// Config sets the level (debug, info, warn or error), the format (json or
+// text) and whether to log content.
+type Config struct {
+	Level   string
+	Format  string
+	Content bool
+}
+
+var logContent atomic.Bool
+
+// Setup makes a logger for cfg the default, for both log/slog and the log
+// package. By default it logs JSON at info level, without content.
+func Setup(w io.Writer, cfg Config) error {
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return fmt.Errorf("log level: %w", err)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "json", "":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("log format must be json or text, not %q", cfg.Format)
+	}
+
+	logContent.Store(cfg.Content)
+	slog.SetDefault(slog.New(handler))
+	if cfg.Content {
+		slog.Warn("content logging is on: documents and prompts will be written to the logs")
+	}
+	return nil
+}
+
+// Content wraps document, prompt or model text for logging. Unless content
+// logging is on, only its length is logged.
+func Content(text string) slog.LogValuer {
+	return content(text)
+}
+
+type content string
+
+func (c content) LogValue() slog.Value {
+	if logContent.Load() {
+		return slog.StringValue(string(c))
+	}
+	return slog.StringValue(fmt.Sprintf("[withheld, %d bytes]", len(c)))
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context carrying logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger of a request, with its request and session
+// IDs, or the default logger outside of requests.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
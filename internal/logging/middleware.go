@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware gives each request a logger carrying its request ID, the trace
+// ID and, on session routes, the session ID, and logs the request once it is
+// served. The request ID is the caller's X-Request-ID if it sent one, and is
+// returned in the same header.
+//
+// Requests are logged by route pattern rather than path, since paths can
+// hold share tokens.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			req := c.Request()
+
+			id := req.Header.Get(echo.HeaderXRequestID)
+			if id == "" || len(id) > 64 {
+				id = newRequestID()
+			}
+			c.Response().Header().Set(echo.HeaderXRequestID, id)
+
+			attrs := []any{"request_id", id}
+			if sc := trace.SpanContextFromContext(req.Context()); sc.IsValid() {
+				attrs = append(attrs, "trace_id", sc.TraceID().String())
+			}
+			if strings.HasPrefix(c.Path(), "/api/chat-sessions/:id") {
+				attrs = append(attrs, "session_id", c.Param("id"))
+			}
+			logger := slog.Default().With(attrs...)
+			c.SetRequest(req.WithContext(WithLogger(req.Context(), logger)))
+
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			fields := []any{
+				"method", req.Method,
+				"route", c.Path(),
+				"status", status,
+				"duration_ms", time.Since(start).Milliseconds(),
+				"bytes_out", c.Response().Size,
+				"remote_ip", c.RealIP(),
+			}
+			if user, ok := c.Get("user").(*models.User); ok {
+				fields = append(fields, "user_id", user.ID)
+			}
+			if err != nil && status >= http.StatusInternalServerError {
+				fields = append(fields, "error", err)
+			}
+			logger.Log(req.Context(), level, "request", fields...)
+			return err
+		}
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,153 @@
+// Package metrics defines Composer's Prometheus metrics and serves them on
+// /metrics of an address of their own.
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"time"
+
+	"composer/internal/usage"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Generations take seconds to minutes, far longer than the default buckets
+// cover.
+var generationBuckets = []float64{0.25, 0.5, 1, 2.5, 5, 10, 20, 40, 60, 120, 300}
+
+var (
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "composer_http_request_duration_seconds",
+		Help: "Time to serve HTTP requests, by route and status.",
+	}, []string{"method", "route", "status"})
+
+	generationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "composer_generation_duration_seconds",
+		Help:    "Time from sending a generation request to the model until its response ends.",
+		Buckets: generationBuckets,
+	}, []string{"model"})
+
+	timeToFirstToken = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "composer_generation_time_to_first_token_seconds",
+		Help:    "Time from sending a generation request to the model until its first chunk arrives.",
+		Buckets: generationBuckets,
+	}, []string{"model"})
+
+	tokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "composer_llm_tokens_total",
+		Help: "Tokens sent to and received from models.",
+	}, []string{"model", "direction"})
+
+	edits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "composer_edits_total",
+		Help: "Edits returned by the model, by whether their text was found in the artifact.",
+	}, []string{"result"})
+
+	llmErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "composer_llm_errors_total",
+		Help: "Failed model calls, by provider.",
+	}, []string{"provider"})
+
+	dbDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "composer_db_query_duration_seconds",
+		Help:    "Time to run database statements, by operation and table.",
+		Buckets: []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+	}, []string{"operation", "table"})
+)
+
+// Server serves the metrics in the Prometheus text format on addr, at
+// /metrics. It is kept apart from the API, which anyone can reach, so that
+// the metrics can be left to the internal network.
+func Server(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+	return &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+}
+
+// Middleware times requests. Routes are labelled with their pattern, such as
+// /api/chat-sessions/:id, so that IDs don't multiply the series.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			status := c.Response().Status
+			if err != nil && !c.Response().Committed {
+				status = http.StatusInternalServerError
+				var he *echo.HTTPError
+				if errors.As(err, &he) {
+					status = he.Code
+				}
+			}
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			httpDuration.WithLabelValues(c.Request().Method, route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// Generation records a finished generation. firstToken is zero if the
+// response wasn't streamed or was empty.
+func Generation(model string, duration, firstToken time.Duration) {
+	generationDuration.WithLabelValues(model).Observe(duration.Seconds())
+	if firstToken > 0 {
+		timeToFirstToken.WithLabelValues(model).Observe(firstToken.Seconds())
+	}
+}
+
+// Tokens records the tokens of a model call.
+func Tokens(model string, t usage.Tokens) {
+	tokens.WithLabelValues(model, "input").Add(float64(t.Input))
+	tokens.WithLabelValues(model, "output").Add(float64(t.Output))
+}
+
+// Edit records whether the text an edit replaces was found in the artifact.
+func Edit(applied bool) {
+	result := "applied"
+	if !applied {
+		result = "not_found"
+	}
+	edits.WithLabelValues(result).Inc()
+}
+
+// LLMError records a failed call to model.
+func LLMError(model llms.Model) {
+	llmErrors.WithLabelValues(Provider(model)).Inc()
+}
+
+// DBQuery records the time a database statement took.
+func DBQuery(operation, table string, d time.Duration) {
+	dbDuration.WithLabelValues(operation, table).Observe(d.Seconds())
+}
+
+// Provider names the provider behind a model by the langchaingo package that
+// implements it, such as "vertex" or "anthropic", unless the model names it
+// with a Provider method.
+func Provider(model llms.Model) string {
+	if p, ok := model.(interface{ Provider() string }); ok {
+		return p.Provider()
+	}
+	t := reflect.TypeOf(model)
+	if t == nil {
+		return "unknown"
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.PkgPath() == "" {
+		return "unknown"
+	}
+	return path.Base(t.PkgPath())
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+type Attachment struct {
+	ID          string    `json:"id"`
+	SessionID   string    `json:"session_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	BlobKey     string    `json:"-"`
+	Text        string    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
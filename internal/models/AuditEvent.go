@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// AuditEvent records who changed a document, or which model produced text,
+// and when. Events are never updated or deleted.
+type AuditEvent struct {
+	ID            string    `json:"id"`
+	ActorID       string    `json:"actor_id"`
+	ActorType     string    `json:"actor_type"`
+	CredentialID  string    `json:"credential_id,omitempty"`
+	Action        string    `json:"action"`
+	SessionID     string    `json:"session_id,omitempty"`
+	WorkspaceID   string    `json:"workspace_id,omitempty"`
+	Version       int       `json:"version,omitempty"`
+	Model         string    `json:"model,omitempty"`
+	PromptVersion string    `json:"prompt_version,omitempty"`
+	InputTokens   int       `json:"input_tokens,omitempty"`
+	OutputTokens  int       `json:"output_tokens,omitempty"`
+	Detail        string    `json:"detail,omitempty"`
+	IP            string    `json:"ip,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Actor types.
+const (
+	ActorUser   = "user"
+	ActorAPIKey = "api_key"
+	ActorShare  = "share"
+)
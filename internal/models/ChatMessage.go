@@ -3,12 +3,50 @@ package models
 import "time"
 
 type ChatMessage struct {
-	ID           string    `json:"id"`
-	SessionID    string    `json:"session_id"`
-	Role         string    `json:"role"`
-	Content      string    `json:"content"`
-	Doc          string    `json:"doc"`
-	Diff         string    `json:"diff"`
-	CreatedAt    time.Time `json:"created_at"`
-	SelectedText string    `json:"selectedText"`
+	ID        string `json:"id"`
+	SessionID string `json:"session_id"`
+	Role      string `json:"role"`
+	Content   string `json:"content"`
+	Doc       string `json:"doc"`
+	// Format is Doc's, html or markdown. Messages stored before it was
+	// recorded have none.
+	Format       string     `json:"format,omitempty"`
+	Diff         string     `json:"diff"`
+	CreatedAt    time.Time  `json:"created_at"`
+	SelectedText string     `json:"selectedText"`
+	Citations    []Citation `json:"citations,omitempty"`
+	// Guardrails holds the findings of the guardrail checks on the request
+	// and on the response, on AI messages.
+	Guardrails []GuardrailFinding `json:"guardrails,omitempty"`
+	// UserID is who sent the message, or whose request an AI message
+	// answers.
+	UserID string `json:"user_id,omitempty"`
+	// Model, token counts and the estimated cost in US dollars are recorded
+	// on AI messages.
+	Model        string  `json:"model,omitempty"`
+	InputTokens  int     `json:"input_tokens,omitempty"`
+	OutputTokens int     `json:"output_tokens,omitempty"`
+	Cost         float64 `json:"cost,omitempty"`
+}
+
+// GuardrailFinding is a guardrail rule that matched a request (Stage
+// "input") or a response ("output"), and the action that was taken.
+type GuardrailFinding struct {
+	Rule   string `json:"rule"`
+	Kind   string `json:"kind"`
+	Stage  string `json:"stage"`
+	Action string `json:"action"`
+	Source string `json:"source,omitempty"`
+	Detail string `json:"detail"`
+}
+
+// Citation ties a numbered marker in an artifact to the reference it came
+// from. ID is the reference's ID in the turn that produced it (R<n> for an
+// attachment, K<n> for a knowledge base passage).
+type Citation struct {
+	Number  int     `json:"number,omitempty"`
+	ID      string  `json:"id"`
+	Source  string  `json:"source"`
+	Snippet string  `json:"snippet"`
+	Score   float32 `json:"score,omitempty"`
 }
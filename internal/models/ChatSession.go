@@ -3,7 +3,9 @@ package models
 import "time"
 
 type ChatSession struct {
-    ID        string    `json:"id"`
-    Title     string    `json:"title"`
-    CreatedAt time.Time `json:"created_at"`
-}
\ No newline at end of file
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	UserID      string    `json:"user_id"`
+	WorkspaceID string    `json:"workspace_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
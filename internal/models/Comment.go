@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Comment is left by a user, or through a share link by a guest, in which
+// case UserID is empty and AuthorName is what the guest gave.
+type Comment struct {
+	ID           string    `json:"id"`
+	SessionID    string    `json:"session_id"`
+	UserID       string    `json:"user_id"`
+	AuthorName   string    `json:"author_name,omitempty"`
+	Body         string    `json:"body"`
+	SelectedText string    `json:"selectedText"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -3,9 +3,12 @@ package models
 import "time"
 
 type Document struct {
-	ID             string    `json:"id"`
-	Contents       string    `json:"contents"`
-	LastModifiedBy string    `json:"last_modified_by"`
-	ChatMessageID  string    `json:"chat_message_id"`
-	CreatedAt      time.Time `json:"created_at"`
+	ID             string     `json:"id"`
+	Version        int        `json:"version"`
+	Contents       string     `json:"contents"`
+	Format         string     `json:"format"`
+	LastModifiedBy string     `json:"last_modified_by"`
+	ChatMessageID  string     `json:"chat_message_id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Citations      []Citation `json:"citations,omitempty"`
 }
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// IdempotencyKey remembers a request made with an Idempotency-Key header, so
+// that repeating it returns the first response instead of running again.
+// RequestHash identifies the request the key was first used with, SessionID
+// is the session the first request created, if any, and Response is empty
+// until it completed.
+type IdempotencyKey struct {
+	UserID         string
+	Key            string
+	RequestHash    string
+	SessionID      string
+	ResponseStatus int
+	Response       []byte
+	CreatedAt      time.Time
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Job kinds.
+const (
+	JobGenerate = "generate"
+	JobExport   = "export"
+	JobImport   = "import"
+)
+
+// Job statuses. Jobs are queued until a worker leases them, and go back to
+// queued if an attempt fails and attempts are left.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job is work done in the background on behalf of a user, and of the API
+// key the job was submitted with, if any. A worker runs it by replaying
+// Request against the API as them; the response is kept in the blob store.
+// When it finishes, WebhookURL is notified, signed with WebhookSecret.
+type Job struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Status      string          `json:"status"`
+	UserID      string          `json:"user_id"`
+	APIKeyID    string          `json:"api_key_id,omitempty"`
+	Input       json.RawMessage `json:"input"`
+	Request     JobRequest      `json:"-"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	Error       string          `json:"error,omitempty"`
+
+	WebhookURL    string `json:"webhook_url,omitempty"`
+	WebhookSecret string `json:"-"`
+
+	ResultStatus int    `json:"result_status,omitempty"`
+	ResultType   string `json:"result_type,omitempty"`
+	ResultKey    string `json:"-"`
+
+	LeaseOwner     string     `json:"-"`
+	LeaseExpiresAt *time.Time `json:"-"`
+	RunAfter       time.Time  `json:"run_after"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+// JobRequest is the API request a job replays. Its body is in the blob
+// store under BodyKey.
+type JobRequest struct {
+	Method      string
+	Path        string
+	ContentType string
+	BodyKey     string
+}
+
+// Done reports whether the job has finished, successfully or not.
+func (j *Job) Done() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed
+}
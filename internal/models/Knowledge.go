@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+type KnowledgeChunk struct {
+	ID         string         `json:"id"`
+	Workspace  string         `json:"workspace"`
+	Source     string         `json:"source"`
+	ChunkIndex int            `json:"chunk_index"`
+	Content    string         `json:"content"`
+	Metadata   map[string]any `json:"metadata"`
+	Embedding  []float32      `json:"-"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
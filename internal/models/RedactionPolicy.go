@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// RedactionPolicy controls what is redacted from a workspace's sessions
+// before it is sent to the model. Detectors names built-in detectors, and
+// Patterns adds the workspace's own.
+type RedactionPolicy struct {
+	WorkspaceID string             `json:"workspace_id"`
+	Enabled     bool               `json:"enabled"`
+	Detectors   []string           `json:"detectors"`
+	Patterns    []RedactionPattern `json:"patterns"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+type RedactionPattern struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Share is a link granting read-only (viewer) or comment-only (commenter)
+// access to a session without an account. Only a hash of its token is stored.
+type Share struct {
+	ID        string     `json:"id"`
+	SessionID string     `json:"session_id"`
+	TokenHash string     `json:"-"`
+	Access    Role       `json:"access"`
+	CreatedBy string     `json:"created_by"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Active reports whether the link can still be used.
+func (s *Share) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// ShareAccess records one use of a share link.
+type ShareAccess struct {
+	ID        string    `json:"id"`
+	ShareID   string    `json:"share_id"`
+	SessionID string    `json:"session_id"`
+	Action    string    `json:"action"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
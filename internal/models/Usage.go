@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// UsageTotal sums the model calls of one group, e.g. one user or one model.
+type UsageTotal struct {
+	Key          string  `json:"key"`
+	Requests     int     `json:"requests"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Cost         float64 `json:"cost"`
+}
+
+// UsageRecord is what one model call cost, and who and what it was for.
+// SessionID is empty for generations that weren't saved.
+type UsageRecord struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"user_id"`
+	APIKeyID     string    `json:"api_key_id,omitempty"`
+	SessionID    string    `json:"session_id,omitempty"`
+	WorkspaceID  string    `json:"workspace_id,omitempty"`
+	Model        string    `json:"model"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	Cost         float64   `json:"cost"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// User is an account that signs in with a local password, an OIDC identity, or
+// both. OIDC users have no password hash.
+type User struct {
+	ID           string    `json:"id"`
+	Email        string    `json:"email"`
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"-"`
+	OIDCIssuer   string    `json:"-"`
+	OIDCSubject  string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AuthSession is a signed-in browser or client. Only a hash of the token is
+// stored.
+type AuthSession struct {
+	TokenHash string    `json:"-"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Events a webhook can subscribe to. WebhookPing is only sent on request,
+// to check that a receiver works.
+const (
+	EventSessionCreated         = "session.created"
+	EventArtifactVersionCreated = "artifact.version_created"
+	EventGenerationCompleted    = "generation.completed"
+	EventCommentAdded           = "comment.added"
+	WebhookPing                 = "ping"
+)
+
+// Events sent to the webhook a job was submitted with, when it finishes.
+const (
+	EventJobSucceeded = "job." + JobSucceeded
+	EventJobFailed    = "job." + JobFailed
+)
+
+var WebhookEvents = []string{EventSessionCreated, EventArtifactVersionCreated, EventGenerationCompleted, EventCommentAdded}
+
+// Webhook subscribes URL to events in a workspace, or, with a JobID, to the
+// outcome of that job. Deliveries are signed with Secret, which is only shown
+// when the webhook or job is created.
+type Webhook struct {
+	ID          string    `json:"id"`
+	WorkspaceID string    `json:"workspace_id"`
+	JobID       string    `json:"job_id,omitempty"`
+	URL         string    `json:"url"`
+	Events      []string  `json:"events"`
+	Description string    `json:"description,omitempty"`
+	Secret      string    `json:"-"`
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (w *Webhook) Subscribed(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery statuses. A delivery is pending until the receiver accepts it or
+// it runs out of attempts.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event sent, or to be sent, to a webhook, and the
+// outcome of its last attempt.
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	WebhookID      string          `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	DurationMs     int64           `json:"duration_ms,omitempty"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
@@ -0,0 +1,284 @@
+// Package ratelimit enforces per-user, per-API-key and per-workspace limits
+// on generation: requests per minute, tokens per day and concurrent
+// generations.
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"composer/internal/logging"
+	"composer/internal/usage"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// A generation holds its concurrency slots for slotTTL and renews them
+	// every slotTTL/3 while it runs, so that slots left by an instance that
+	// stopped mid-request free up soon after.
+	slotTTL = time.Minute
+	// retention is how long counters are kept after their window starts.
+	retention = 48 * time.Hour
+	// concurrencyRetry is the Retry-After for requests over the concurrency
+	// limit, since there is no way to know when a generation will end.
+	concurrencyRetry = 5 * time.Second
+)
+
+// Limits are the limits of one kind of subject. Zero means unlimited.
+type Limits struct {
+	RequestsPerMinute int `json:"requests_per_minute" yaml:"requests_per_minute" toml:"requests_per_minute"`
+	TokensPerDay      int `json:"tokens_per_day" yaml:"tokens_per_day" toml:"tokens_per_day"`
+	Concurrent        int `json:"concurrent" yaml:"concurrent" toml:"concurrent" help:"concurrent generations"`
+}
+
+// Config holds the limits per kind of subject.
+type Config struct {
+	User      Limits `json:"user"`
+	APIKey    Limits `json:"api_key"`
+	Workspace Limits `json:"workspace"`
+}
+
+// DefaultConfig keeps a single user or key from monopolizing the model.
+var DefaultConfig = Config{
+	User:   Limits{RequestsPerMinute: 20, Concurrent: 2},
+	APIKey: Limits{RequestsPerMinute: 20, Concurrent: 2},
+}
+
+// Load reads a JSON Config from path, or returns the defaults if path is
+// empty.
+func Load(path string) (Config, error) {
+	if path == "" {
+		return DefaultConfig, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return Config{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Subject kinds.
+const (
+	User      = "user"
+	APIKey    = "api_key"
+	Workspace = "workspace"
+)
+
+// Subject is who a request is counted against.
+type Subject struct {
+	Kind string
+	ID   string
+}
+
+type Limiter struct {
+	store  Store
+	config Config
+}
+
+func New(store Store, config Config) *Limiter {
+	return &Limiter{store: store, config: config}
+}
+
+func (l *Limiter) limits(kind string) Limits {
+	switch kind {
+	case User:
+		return l.config.User
+	case APIKey:
+		return l.config.APIKey
+	case Workspace:
+		return l.config.Workspace
+	}
+	return Limits{}
+}
+
+// Middleware limits a route. subjects names who each request counts
+// against. Handlers report the tokens they used by setting "usage" to a
+// usage.Tokens in the context.
+//
+// Every response carries X-RateLimit-* headers for requests per minute and
+// X-TokenQuota-* headers for tokens per day, for the subject closest to its
+// limit. If the store fails, requests are let through.
+func (l *Limiter) Middleware(subjects func(c echo.Context) []Subject) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := c.Request().Context()
+			now := time.Now().UTC()
+			subs := subjects(c)
+
+			check := checker{now: now, header: c.Response().Header(), requestsLeft: -1, tokensLeft: -1}
+			slot := newSlotID()
+			var acquired []string
+			release := func() {
+				for _, key := range acquired {
+					if err := l.store.Release(context.WithoutCancel(ctx), key, slot); err != nil {
+						logging.FromContext(ctx).Error("releasing rate limit slot", "key", key, "error", err)
+					}
+				}
+			}
+
+			for _, s := range subs {
+				limits := l.limits(s.Kind)
+				prefix := s.Kind + ":" + s.ID
+
+				if limits.TokensPerDay > 0 {
+					day := now.Truncate(24 * time.Hour)
+					used, err := l.store.Get(ctx, "tokens:"+prefix, day)
+					if err != nil {
+						logging.FromContext(ctx).Error("reading token quota", "subject", prefix, "error", err)
+					} else if !check.tokens(limits.TokensPerDay, used, day.Add(24*time.Hour)) {
+						release()
+						return check.reject(s, "tokens per day")
+					}
+				}
+
+				if limits.RequestsPerMinute > 0 {
+					minute := now.Truncate(time.Minute)
+					count, err := l.store.Add(ctx, "requests:"+prefix, minute, 1)
+					if err != nil {
+						logging.FromContext(ctx).Error("counting requests", "subject", prefix, "error", err)
+					} else if !check.requests(limits.RequestsPerMinute, count, minute.Add(time.Minute)) {
+						release()
+						return check.reject(s, "requests per minute")
+					}
+				}
+
+				if limits.Concurrent > 0 {
+					key := "concurrent:" + prefix
+					count, err := l.store.Hold(ctx, key, slot, now, now.Add(slotTTL))
+					if err != nil {
+						logging.FromContext(ctx).Error("counting concurrent generations", "subject", prefix, "error", err)
+						continue
+					}
+					acquired = append(acquired, key)
+					if count > limits.Concurrent {
+						release()
+						check.retryAfter = now.Add(concurrencyRetry)
+						return check.reject(s, "concurrent generations")
+					}
+				}
+			}
+			stop := l.renew(ctx, acquired, slot)
+			defer func() {
+				stop()
+				release()
+			}()
+
+			err := next(c)
+
+			if tokens, ok := c.Get("usage").(usage.Tokens); ok {
+				day := now.Truncate(24 * time.Hour)
+				for _, s := range subs {
+					if l.limits(s.Kind).TokensPerDay == 0 {
+						continue
+					}
+					prefix := s.Kind + ":" + s.ID
+					if _, err := l.store.Add(context.WithoutCancel(ctx), "tokens:"+prefix, day, tokens.Input+tokens.Output); err != nil {
+						logging.FromContext(ctx).Error("recording tokens", "subject", prefix, "error", err)
+					}
+				}
+			}
+			return err
+		}
+	}
+}
+
+// renew extends the slot in each of keys every slotTTL/3 until the returned
+// function is called, which waits for it to stop so that a slot isn't
+// renewed after it is released.
+func (l *Limiter) renew(ctx context.Context, keys []string, slot string) func() {
+	if len(keys) == 0 {
+		return func() {}
+	}
+	ctx = context.WithoutCancel(ctx)
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(slotTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				for _, key := range keys {
+					if _, err := l.store.Hold(ctx, key, slot, now.UTC(), now.UTC().Add(slotTTL)); err != nil {
+						logging.FromContext(ctx).Error("renewing rate limit slot", "key", key, "error", err)
+					}
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// newSlotID names the slots of one request.
+func newSlotID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// checker compares counts with limits, keeping the headers for the subject
+// with the least left.
+type checker struct {
+	now          time.Time
+	header       http.Header
+	requestsLeft int
+	tokensLeft   int
+	retryAfter   time.Time
+}
+
+func (k *checker) requests(limit, count int, reset time.Time) bool {
+	remaining := max(limit-count, 0)
+	if k.requestsLeft < 0 || remaining < k.requestsLeft {
+		k.requestsLeft = remaining
+		k.header.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+		k.header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		k.header.Set("X-RateLimit-Reset", strconv.Itoa(seconds(reset.Sub(k.now))))
+	}
+	if count > limit {
+		k.retryAfter = reset
+		return false
+	}
+	return true
+}
+
+func (k *checker) tokens(limit, used int, reset time.Time) bool {
+	remaining := max(limit-used, 0)
+	if k.tokensLeft < 0 || remaining < k.tokensLeft {
+		k.tokensLeft = remaining
+		k.header.Set("X-TokenQuota-Limit", strconv.Itoa(limit))
+		k.header.Set("X-TokenQuota-Remaining", strconv.Itoa(remaining))
+		k.header.Set("X-TokenQuota-Reset", strconv.Itoa(seconds(reset.Sub(k.now))))
+	}
+	if used >= limit {
+		k.retryAfter = reset
+		return false
+	}
+	return true
+}
+
+func (k *checker) reject(s Subject, limit string) error {
+	k.header.Set("Retry-After", strconv.Itoa(seconds(k.retryAfter.Sub(k.now))))
+	return echo.NewHTTPError(http.StatusTooManyRequests, fmt.Sprintf("%s limit of %s exceeded", s.Kind, limit))
+}
+
+// seconds rounds d up to whole seconds, and at least 1.
+func seconds(d time.Duration) int {
+	return max(int((d+time.Second-1)/time.Second), 1)
+}
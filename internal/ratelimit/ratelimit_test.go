@@ -0,0 +1,276 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"composer/internal/db"
+	"composer/internal/usage"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newTestServer limits a route with config. Requests are counted against
+// the user named in their X-User header, and the workspace in X-Workspace if
+// set. The handler reports X-Tokens tokens and, for requests with X-Block,
+// signals entered and waits for release to close.
+func newTestServer(config Config, entered, release chan struct{}) *echo.Echo {
+	e := echo.New()
+	subjects := func(c echo.Context) []Subject {
+		subs := []Subject{{User, c.Request().Header.Get("X-User")}}
+		if ws := c.Request().Header.Get("X-Workspace"); ws != "" {
+			subs = append(subs, Subject{Workspace, ws})
+		}
+		return subs
+	}
+	e.GET("/generate", func(c echo.Context) error {
+		if c.Request().Header.Get("X-Block") != "" {
+			entered <- struct{}{}
+			<-release
+		}
+		if n, err := strconv.Atoi(c.Request().Header.Get("X-Tokens")); err == nil {
+			c.Set("usage", usage.Tokens{Input: n - 1, Output: 1})
+		}
+		return c.NoContent(http.StatusOK)
+	}, New(NewMemoryStore(), config).Middleware(subjects))
+	return e
+}
+
+func get(e *echo.Echo, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/generate", nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// sameMinute runs f until it starts and ends within one minute, since the
+// counters it checks reset when the minute does.
+func sameMinute(t *testing.T, f func(t *testing.T) bool) {
+	for range 3 {
+		start := time.Now().UTC().Truncate(time.Minute)
+		ok := f(t)
+		if time.Now().UTC().Truncate(time.Minute).Equal(start) {
+			if !ok {
+				t.FailNow()
+			}
+			return
+		}
+	}
+	t.Fatal("couldn't run within one minute")
+}
+
+func TestRequestsPerMinute(t *testing.T) {
+	sameMinute(t, func(t *testing.T) bool {
+		e := newTestServer(Config{User: Limits{RequestsPerMinute: 2}}, nil, nil)
+		ok := true
+		for i, want := range []struct {
+			status    int
+			remaining string
+		}{{http.StatusOK, "1"}, {http.StatusOK, "0"}, {http.StatusTooManyRequests, "0"}} {
+			rec := get(e, "X-User", "1")
+			if rec.Code != want.status || rec.Header().Get("X-RateLimit-Remaining") != want.remaining {
+				t.Errorf("request %d = %d with %s remaining, want %d with %s", i+1, rec.Code, rec.Header().Get("X-RateLimit-Remaining"), want.status, want.remaining)
+				ok = false
+			}
+			if want.status == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+				t.Errorf("request %d has no Retry-After", i+1)
+				ok = false
+			}
+		}
+		if rec := get(e, "X-User", "2"); rec.Code != http.StatusOK {
+			t.Errorf("another user's request = %d, want %d", rec.Code, http.StatusOK)
+			ok = false
+		}
+		return ok
+	})
+}
+
+func TestTokensPerDay(t *testing.T) {
+	e := newTestServer(Config{User: Limits{TokensPerDay: 250}}, nil, nil)
+
+	// 101 tokens each: the third request starts with 202 used and is let
+	// through, going over; the fourth isn't.
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rec := get(e, "X-User", "1", "X-Tokens", "101")
+		if rec.Code != want {
+			t.Fatalf("request %d = %d, want %d", i+1, rec.Code, want)
+		}
+		if i == 2 && rec.Header().Get("X-TokenQuota-Remaining") != "48" {
+			t.Errorf("request 3 X-TokenQuota-Remaining = %q, want 48", rec.Header().Get("X-TokenQuota-Remaining"))
+		}
+	}
+	if rec := get(e, "X-User", "2"); rec.Code != http.StatusOK {
+		t.Errorf("another user's request = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	e := newTestServer(Config{User: Limits{Concurrent: 1}, Workspace: Limits{Concurrent: 1}}, entered, release)
+
+	done := make(chan int, 2)
+	go func() { done <- get(e, "X-User", "1", "X-Block", "1").Code }()
+	<-entered
+	rec := get(e, "X-User", "1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "5" {
+		t.Errorf("second generation = %d, Retry-After %q; want %d, 5", rec.Code, rec.Header().Get("Retry-After"), http.StatusTooManyRequests)
+	}
+
+	go func() { done <- get(e, "X-User", "2", "X-Workspace", "w", "X-Block", "1").Code }()
+	<-entered
+	if rec := get(e, "X-User", "3", "X-Workspace", "w"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("generation in a busy workspace = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	// Rejected by the workspace, user 3 must have given its own slot back.
+	if rec := get(e, "X-User", "3"); rec.Code != http.StatusOK {
+		t.Errorf("user 3 outside the workspace = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	close(release)
+	for range 2 {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("blocked generation = %d, want %d", code, http.StatusOK)
+		}
+	}
+	for _, headers := range [][]string{{"X-User", "1"}, {"X-User", "2", "X-Workspace", "w"}} {
+		if rec := get(e, headers...); rec.Code != http.StatusOK {
+			t.Errorf("%v after the generations finished = %d, want %d", headers, rec.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestStores(t *testing.T) {
+	database, err := db.New("sqlite3", filepath.Join(t.TempDir(), "composer.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "sql": NewSQLStore(database)} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			window := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			steps := []struct {
+				key    string
+				window time.Time
+				n      int
+				want   int
+			}{
+				{"a", window, 1, 1},
+				{"a", window, 2, 3},
+				{"a", window, -1, 2},
+				{"a", window.Add(time.Minute), 1, 1},
+				{"b", window, 5, 5},
+			}
+			for _, s := range steps {
+				got, err := store.Add(ctx, s.key, s.window, s.n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != s.want {
+					t.Errorf("Add(%s, %s, %d) = %d, want %d", s.key, s.window.Format(time.TimeOnly), s.n, got, s.want)
+				}
+			}
+			if got, err := store.Get(ctx, "a", window); err != nil || got != 2 {
+				t.Errorf("Get(a) = %d, %v; want 2", got, err)
+			}
+			if got, err := store.Get(ctx, "c", window); err != nil || got != 0 {
+				t.Errorf("Get(c) = %d, %v; want 0", got, err)
+			}
+		})
+	}
+}
+
+func TestStoreSlots(t *testing.T) {
+	database, err := db.New("sqlite3", filepath.Join(t.TempDir(), "composer.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "sql": NewSQLStore(database)} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Date(2024, 5, 1, 12, 9, 59, 0, time.UTC)
+			steps := []struct {
+				id   string
+				now  time.Time
+				ttl  time.Duration
+				want int
+			}{
+				{"x", now, time.Minute, 1},
+				{"y", now, 10 * time.Second, 2},
+				// Renewing doesn't take another slot, however the clock
+				// reads.
+				{"x", now.Add(time.Second), time.Minute, 2},
+				// y wasn't renewed: its slot is gone once it expires.
+				{"z", now.Add(10 * time.Second), time.Minute, 2},
+				{"x", now.Add(50 * time.Second), time.Minute, 2},
+			}
+			for _, s := range steps {
+				got, err := store.Hold(ctx, "a", s.id, s.now, s.now.Add(s.ttl))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != s.want {
+					t.Errorf("Hold(a, %s) at %s = %d, want %d", s.id, s.now.Format(time.TimeOnly), got, s.want)
+				}
+			}
+			if got, _ := store.Hold(ctx, "b", "x", now, now.Add(time.Minute)); got != 1 {
+				t.Errorf("Hold(b, x) = %d, want 1", got)
+			}
+
+			for _, id := range []string{"x", "y"} {
+				if err := store.Release(ctx, "a", id); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got, _ := store.Hold(ctx, "a", "w", now.Add(time.Minute), now.Add(2*time.Minute)); got != 2 {
+				t.Errorf("Hold(a, w) after releasing x = %d, want 2", got)
+			}
+		})
+	}
+}
+
+func TestSeconds(t *testing.T) {
+	for _, tt := range []struct {
+		d    time.Duration
+		want int
+	}{{0, 1}, {-time.Second, 1}, {time.Millisecond, 1}, {time.Second, 1}, {1001 * time.Millisecond, 2}, {time.Minute, 60}} {
+		if got := seconds(tt.d); got != tt.want {
+			t.Errorf("seconds(%s) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if cfg, err := Load(""); err != nil || cfg != DefaultConfig {
+		t.Errorf("Load(\"\") = %v, %v; want the defaults", cfg, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "limits.json")
+	if err := os.WriteFile(path, []byte(`{"user": {"requests_per_minute": 5}, "workspace": {"tokens_per_day": 1000}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	want := Config{User: Limits{RequestsPerMinute: 5}, Workspace: Limits{TokensPerDay: 1000}}
+	if cfg, err := Load(path); err != nil || cfg != want {
+		t.Errorf("Load() = %v, %v; want %v", cfg, err, want)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"user": `), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("Load() of invalid JSON = %v, want an error naming the file", err)
+	}
+}
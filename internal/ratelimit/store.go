@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"composer/internal/db"
+)
+
+// Store keeps counters per key and fixed time window, and concurrency slots
+// per key. Windows are identified by their start.
+type Store interface {
+	// Add adds n, which may be negative, and returns the new count.
+	Add(ctx context.Context, key string, window time.Time, n int) (int, error)
+	Get(ctx context.Context, key string, window time.Time) (int, error)
+
+	// Hold holds the slot id of key until expires, or extends it, and
+	// returns how many of key's slots are held at now, this one included.
+	Hold(ctx context.Context, key, id string, now, expires time.Time) (int, error)
+	// Release gives the slot id of key back.
+	Release(ctx context.Context, key, id string) error
+}
+
+// MemoryStore keeps counters in process. Limits then apply per instance.
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[counterKey]int
+	slots    map[string]map[string]time.Time
+	pruned   time.Time
+}
+
+type counterKey struct {
+	key    string
+	window time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: map[counterKey]int{}, slots: map[string]map[string]time.Time{}}
+}
+
+func (s *MemoryStore) Add(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(time.Now())
+	k := counterKey{key, window}
+	s.counters[k] += n
+	return s.counters[k], nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string, window time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.counters[counterKey{key, window}], nil
+}
+
+func (s *MemoryStore) Hold(ctx context.Context, key, id string, now, expires time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slots := s.slots[key]
+	if slots == nil {
+		slots = map[string]time.Time{}
+		s.slots[key] = slots
+	}
+	slots[id] = expires
+	for id, expires := range slots {
+		if !expires.After(now) {
+			delete(slots, id)
+		}
+	}
+	return len(slots), nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.slots[key], id)
+	if len(s.slots[key]) == 0 {
+		delete(s.slots, key)
+	}
+	return nil
+}
+
+// prune drops counters of windows that ended long ago, at most once a minute.
+func (s *MemoryStore) prune(now time.Time) {
+	if now.Sub(s.pruned) < time.Minute {
+		return
+	}
+	s.pruned = now
+	for k := range s.counters {
+		if now.Sub(k.window) > retention {
+			delete(s.counters, k)
+		}
+	}
+}
+
+// SQLStore keeps counters in the application database, so that limits apply
+// across instances.
+type SQLStore struct {
+	database *db.Db
+
+	mu     sync.Mutex
+	pruned time.Time
+}
+
+func NewSQLStore(database *db.Db) *SQLStore {
+	return &SQLStore{database: database}
+}
+
+func (s *SQLStore) Add(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	s.prune(ctx, time.Now())
+	return s.database.WithContext(ctx).AddRateCounter(key, window, n)
+}
+
+func (s *SQLStore) Get(ctx context.Context, key string, window time.Time) (int, error) {
+	return s.database.WithContext(ctx).GetRateCounter(key, window)
+}
+
+func (s *SQLStore) Hold(ctx context.Context, key, id string, now, expires time.Time) (int, error) {
+	return s.database.WithContext(ctx).HoldRateSlot(key, id, now, expires)
+}
+
+func (s *SQLStore) Release(ctx context.Context, key, id string) error {
+	return s.database.WithContext(ctx).ReleaseRateSlot(key, id)
+}
+
+func (s *SQLStore) prune(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.pruned) < time.Hour {
+		s.mu.Unlock()
+		return
+	}
+	s.pruned = now
+	s.mu.Unlock()
+
+	s.database.WithContext(ctx).PruneRateCounters(now.Add(-retention))
+}
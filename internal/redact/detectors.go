@@ -0,0 +1,85 @@
+package redact
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Built-in detector names, as used in workspace policies.
+const (
+	Email         = "email"
+	Phone         = "phone"
+	AccountNumber = "account_number"
+	APIKey        = "api_key"
+)
+
+// Builtin lists the detectors that are enabled unless a policy says
+// otherwise.
+var Builtin = []string{Email, Phone, AccountNumber, APIKey}
+
+var builtins = map[string][]Detector{
+	Email: {
+		&regexDetector{kind: "EMAIL", re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	},
+	Phone: {
+		&regexDetector{
+			kind:  "PHONE",
+			re:    regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?)?(?:\(\d{2,4}\)[\s.-]?|\b\d{2,4}[\s.-]?)\d{3,4}[\s.-]?\d{3,4}\b`),
+			valid: digitsBetween(9, 15),
+		},
+	},
+	AccountNumber: {
+		// IBANs, e.g. GB82 WEST 1234 5698 7654 32.
+		&regexDetector{kind: "IBAN", re: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?:\s?[A-Z0-9]{4}){2,7}(?:\s?[A-Z0-9]{1,3})?\b`)},
+		// Payment cards, checked with the Luhn algorithm.
+		&regexDetector{kind: "CARD", re: regexp.MustCompile(`\b\d(?:[\s-]?\d){12,18}\b`), valid: luhn},
+		// Bank account and other long reference numbers.
+		&regexDetector{kind: "ACCOUNT", re: regexp.MustCompile(`\b\d{9,18}\b`)},
+	},
+	APIKey: {
+		&regexDetector{kind: "SECRET", re: regexp.MustCompile(`\b(?:sk-[A-Za-z0-9_-]{20,}|AKIA[0-9A-Z]{16}|AIza[0-9A-Za-z_-]{35}|gh[pousr]_[A-Za-z0-9]{36,}|xox[abpr]-[A-Za-z0-9-]{10,}|cmp_[A-Za-z0-9_-]{20,})`)},
+		&regexDetector{kind: "SECRET", re: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+		&regexDetector{kind: "SECRET", re: regexp.MustCompile(`(?i)\b(?:api[_-]?key|secret|token|password|passwd)\s*[:=]\s*["']?([^\s"'<>]{8,})`)},
+	},
+}
+
+// Lookup returns the detectors for a built-in name.
+func Lookup(name string) ([]Detector, bool) {
+	d, ok := builtins[name]
+	return d, ok
+}
+
+func digitsBetween(min, max int) func(string) bool {
+	return func(s string) bool {
+		n := countDigits(s)
+		return n >= min && n <= max
+	}
+}
+
+func countDigits(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+	return n
+}
+
+func luhn(s string) bool {
+	s = strings.NewReplacer(" ", "", "-", "").Replace(s)
+	sum := 0
+	double := false
+	for i := len(s) - 1; i >= 0; i-- {
+		d := int(s[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
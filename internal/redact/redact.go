@@ -0,0 +1,176 @@
+// Package redact replaces personal data and secrets in text bound for the
+// model with placeholders, and puts the original values back into what the
+// model returns.
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Detector finds values to redact. Find returns the [start, end) byte offsets
+// of each value in text.
+type Detector interface {
+	Kind() string
+	Find(text string) [][]int
+}
+
+// regexDetector redacts the first capture group of each match, or the whole
+// match when the pattern has no groups. Matches rejected by valid are kept.
+type regexDetector struct {
+	kind  string
+	re    *regexp.Regexp
+	valid func(string) bool
+}
+
+func (d *regexDetector) Kind() string { return d.kind }
+
+func (d *regexDetector) Find(text string) [][]int {
+	group := 0
+	if d.re.NumSubexp() > 0 {
+		group = 1
+	}
+
+	var spans [][]int
+	for _, m := range d.re.FindAllStringSubmatchIndex(text, -1) {
+		start, end := m[2*group], m[2*group+1]
+		if start < 0 || start == end {
+			continue
+		}
+		if d.valid != nil && !d.valid(text[start:end]) {
+			continue
+		}
+		spans = append(spans, []int{start, end})
+	}
+	return spans
+}
+
+var kindCleaner = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// Pattern returns a detector for a custom regular expression. If the
+// expression has capture groups, only the first one is redacted, so context
+// such as "Employee ID: (\d+)" stays visible to the model.
+func Pattern(kind, expr string) (Detector, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("pattern %q: %w", kind, err)
+	}
+	if re.MatchString("") {
+		return nil, fmt.Errorf("pattern %q matches empty text", kind)
+	}
+	kind = strings.Trim(kindCleaner.ReplaceAllString(strings.ToUpper(kind), "_"), "_")
+	if kind == "" {
+		kind = "CUSTOM"
+	}
+	return &regexDetector{kind: kind, re: re}, nil
+}
+
+// Redactor replaces detected values with placeholders such as [EMAIL_1]. A
+// value keeps its placeholder for the Redactor's lifetime, so one Redactor
+// should be used for every message of a request and the response to it.
+type Redactor struct {
+	detectors    []Detector
+	placeholders map[string]string
+	originals    map[string]string
+	counts       map[string]int
+	restorer     *strings.Replacer
+}
+
+func New(detectors ...Detector) *Redactor {
+	return &Redactor{
+		detectors:    detectors,
+		placeholders: map[string]string{},
+		originals:    map[string]string{},
+		counts:       map[string]int{},
+	}
+}
+
+// Redact returns text with every detected value replaced. Where detectors
+// overlap, the match that starts first wins, then the longest.
+func (r *Redactor) Redact(text string) string {
+	type span struct {
+		start, end int
+		kind       string
+	}
+	var spans []span
+	for _, d := range r.detectors {
+		for _, s := range d.Find(text) {
+			spans = append(spans, span{s[0], s[1], d.Kind()})
+		}
+	}
+	if len(spans) == 0 {
+		return text
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end > spans[j].end
+	})
+
+	var b strings.Builder
+	last := 0
+	for _, s := range spans {
+		if s.start < last {
+			continue
+		}
+		b.WriteString(text[last:s.start])
+		b.WriteString(r.placeholder(s.kind, text[s.start:s.end]))
+		last = s.end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func (r *Redactor) placeholder(kind, value string) string {
+	if p, ok := r.placeholders[value]; ok {
+		return p
+	}
+	r.counts[kind]++
+	p := fmt.Sprintf("[%s_%d]", kind, r.counts[kind])
+	r.placeholders[value] = p
+	r.originals[p] = value
+	r.restorer = nil
+	return p
+}
+
+// Restore puts the original values back in place of placeholders.
+func (r *Redactor) Restore(text string) string {
+	if len(r.originals) == 0 {
+		return text
+	}
+	if r.restorer == nil {
+		pairs := make([]string, 0, 2*len(r.originals))
+		for p, v := range r.originals {
+			pairs = append(pairs, p, v)
+		}
+		r.restorer = strings.NewReplacer(pairs...)
+	}
+	return r.restorer.Replace(text)
+}
+
+// Count is the number of distinct values redacted so far.
+func (r *Redactor) Count() int {
+	return len(r.originals)
+}
+
+// Messages redacts the text parts of messages, leaving other parts as they
+// are.
+func (r *Redactor) Messages(messages []llms.MessageContent) []llms.MessageContent {
+	out := make([]llms.MessageContent, len(messages))
+	for i, m := range messages {
+		parts := make([]llms.ContentPart, len(m.Parts))
+		for j, p := range m.Parts {
+			if text, ok := p.(llms.TextContent); ok {
+				p = llms.TextContent{Text: r.Redact(text.Text)}
+			}
+			parts[j] = p
+		}
+		out[i] = llms.MessageContent{Role: m.Role, Parts: parts}
+	}
+	return out
+}
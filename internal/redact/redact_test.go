@@ -0,0 +1,173 @@
+package redact
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+func builtin(t *testing.T, names ...string) *Redactor {
+	t.Helper()
+	var detectors []Detector
+	for _, name := range names {
+		d, ok := Lookup(name)
+		if !ok {
+			t.Fatalf("no built-in detector %q", name)
+		}
+		detectors = append(detectors, d...)
+	}
+	return New(detectors...)
+}
+
+func pattern(t *testing.T, kind, expr string) Detector {
+	t.Helper()
+	d, err := Pattern(kind, expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		r    *Redactor
+		text string
+		want string
+	}{
+		{"email", builtin(t, Email), "Mail ana@example.com today", "Mail [EMAIL_1] today"},
+		{"phone", builtin(t, Phone), "Call +1 (555) 123-4567.", "Call [PHONE_1]."},
+		{"iban", builtin(t, AccountNumber), "Pay GB82 WEST 1234 5698 7654 32 now", "Pay [IBAN_1] now"},
+		{"card", builtin(t, AccountNumber), "Card 4111 1111 1111 1111.", "Card [CARD_1]."},
+		{"secret", builtin(t, APIKey), "api_key=abcd1234efgh", "api_key=[SECRET_1]"},
+		{"nothing", builtin(t, Builtin...), "Nothing to see here", "Nothing to see here"},
+
+		// A card number is an account number too; the card detector comes
+		// first and the match is the same length, so it wins.
+		{"same span, first detector", builtin(t, AccountNumber), "4111111111111111", "[CARD_1]"},
+		{"overlap, longest", New(pattern(t, "short", "abc"), pattern(t, "long", "abcdef")), "xxabcdefyy", "xx[LONG_1]yy"},
+		{"overlap, first to start", New(pattern(t, "later", "bcd"), pattern(t, "earlier", "abc")), "abcd", "[EARLIER_1]d"},
+
+		{"first capture group", New(pattern(t, "Employee ID", `Employee ID: (\d+)`)), "Employee ID: 12345", "Employee ID: [EMPLOYEE_ID_1]"},
+		{"kind cleaned", New(pattern(t, "--", `\d+`)), "7", "[CUSTOM_1]"},
+
+		// Rejected by luhn, the number is still an account number.
+		{"luhn", builtin(t, AccountNumber), "4111111111111112", "[ACCOUNT_1]"},
+		{"too few digits for a phone", builtin(t, Phone), "Call 12 345 678", "Call 12 345 678"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.r.Redact(tt.text)
+			if got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			if restored := tt.r.Restore(got); restored != tt.text {
+				t.Errorf("Restore(%q) = %q, want %q", got, restored, tt.text)
+			}
+		})
+	}
+}
+
+func TestPlaceholdersAreStable(t *testing.T) {
+	r := builtin(t, Email)
+	first := r.Redact("From ana@example.com to bo@example.com")
+	second := r.Redact("Reply to bo@example.com, cc ana@example.com and cy@example.com")
+
+	if want := "From [EMAIL_1] to [EMAIL_2]"; first != want {
+		t.Errorf("first message = %q, want %q", first, want)
+	}
+	if want := "Reply to [EMAIL_2], cc [EMAIL_1] and [EMAIL_3]"; second != want {
+		t.Errorf("second message = %q, want %q", second, want)
+	}
+	if r.Count() != 3 {
+		t.Errorf("Count() = %d, want 3", r.Count())
+	}
+
+	// The model's answer uses the placeholders it was given.
+	answer := "I wrote to [EMAIL_3] and [EMAIL_1]; [EMAIL_9] is unknown."
+	if got, want := r.Restore(answer), "I wrote to cy@example.com and ana@example.com; [EMAIL_9] is unknown."; got != want {
+		t.Errorf("Restore() = %q, want %q", got, want)
+	}
+}
+
+func TestPattern(t *testing.T) {
+	for _, expr := range []string{`(`, `a*`, `(x?)`} {
+		if _, err := Pattern("bad", expr); err == nil {
+			t.Errorf("Pattern(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestValidators(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want bool
+	}{
+		{"4111 1111 1111 1111", true},
+		{"4111-1111-1111-1111", true},
+		{"79927398713", true},
+		{"4111 1111 1111 1112", false},
+		{"79927398710", false},
+	} {
+		if got := luhn(tt.s); got != tt.want {
+			t.Errorf("luhn(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+
+	valid := digitsBetween(9, 15)
+	for _, tt := range []struct {
+		s    string
+		want bool
+	}{
+		{"12 345 678", false},
+		{"+1 (555) 123-4567", true},
+		{"123456789012345", true},
+		{"1234567890123456", false},
+	} {
+		if got := valid(tt.s); got != tt.want {
+			t.Errorf("digitsBetween(9, 15)(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestMessages(t *testing.T) {
+	r := builtin(t, Email, APIKey)
+	image := llms.ImageURLContent{URL: "https://example.com/ana@example.com.png"}
+	messages := []llms.MessageContent{
+		{Role: llms.ChatMessageTypeSystem, Parts: []llms.ContentPart{llms.TextContent{Text: "You write documents."}}},
+		{Role: llms.ChatMessageTypeHuman, Parts: []llms.ContentPart{
+			llms.TextContent{Text: "Invite ana@example.com"},
+			image,
+			llms.TextContent{Text: "token: sk-abcdefghijklmnopqrstuvwxyz"},
+		}},
+	}
+
+	redacted := r.Messages(messages)
+	want := []llms.MessageContent{
+		{Role: llms.ChatMessageTypeSystem, Parts: []llms.ContentPart{llms.TextContent{Text: "You write documents."}}},
+		{Role: llms.ChatMessageTypeHuman, Parts: []llms.ContentPart{
+			llms.TextContent{Text: "Invite [EMAIL_1]"},
+			image,
+			llms.TextContent{Text: "token: [SECRET_1]"},
+		}},
+	}
+	if !reflect.DeepEqual(redacted, want) {
+		t.Fatalf("Messages() = %+v, want %+v", redacted, want)
+	}
+	if messages[1].Parts[0].(llms.TextContent).Text != "Invite ana@example.com" {
+		t.Error("Messages() changed its input")
+	}
+
+	for i, m := range redacted {
+		for j, p := range m.Parts {
+			text, ok := p.(llms.TextContent)
+			if !ok {
+				continue
+			}
+			if got, orig := r.Restore(text.Text), messages[i].Parts[j].(llms.TextContent).Text; got != orig {
+				t.Errorf("message %d part %d restored to %q, want %q", i, j, got, orig)
+			}
+		}
+	}
+}
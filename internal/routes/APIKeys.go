@@ -0,0 +1,123 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/db"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func RegisterAPIKeyRoutes(e *echo.Echo, database *db.Db) {
+	e.POST("/api/api-keys", createAPIKey(database))
+	e.GET("/api/api-keys", listAPIKeys(database))
+	e.DELETE("/api/api-keys/:keyId", deleteAPIKey(database))
+}
+
+type apiKeyRequest struct {
+	Name          string   `json:"name"`
+	WorkspaceID   string   `json:"workspace_id"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+type apiKeyResponse struct {
+	*models.APIKey
+	Key string `json:"key"`
+}
+
+// createAPIKey returns the key once; only its hash is kept.
+func createAPIKey(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		var req apiKeyRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "name is required")
+		}
+		if len(req.Scopes) == 0 {
+			req.Scopes = []string{models.ScopeRead}
+		}
+		for _, s := range req.Scopes {
+			if s != models.ScopeRead && s != models.ScopeWrite {
+				return echo.NewHTTPError(http.StatusBadRequest, "scopes must be read or write")
+			}
+		}
+		if req.ExpiresInDays < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "expires_in_days must not be negative")
+		}
+		if req.WorkspaceID != "" {
+			if _, err := authorizeWorkspace(c, database, req.WorkspaceID, models.RoleViewer); err != nil {
+				return err
+			}
+		}
+
+		key, prefix, hash, err := auth.NewAPIKey()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		now := time.Now()
+		apiKey := models.APIKey{
+			UserID:      auth.CurrentUser(c).ID,
+			WorkspaceID: req.WorkspaceID,
+			Name:        req.Name,
+			Prefix:      prefix,
+			KeyHash:     hash,
+			Scopes:      req.Scopes,
+			CreatedAt:   now,
+		}
+		if req.ExpiresInDays > 0 {
+			expiresAt := now.AddDate(0, 0, req.ExpiresInDays)
+			apiKey.ExpiresAt = &expiresAt
+		}
+		if err := database.InsertAPIKey(&apiKey); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusCreated, apiKeyResponse{APIKey: &apiKey, Key: key})
+	}
+}
+
+func listAPIKeys(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		keys, err := database.ListAPIKeys(auth.CurrentUser(c).ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, keys)
+	}
+}
+
+func deleteAPIKey(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		found, err := database.DeleteAPIKey(auth.CurrentUser(c).ID, c.Param("keyId"))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		if !found {
+			return echo.NewHTTPError(http.StatusNotFound, "api key not found")
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// keyWorkspace is the workspace the request's API key is bound to, if any.
+func keyWorkspace(c echo.Context) string {
+	if key := auth.CurrentAPIKey(c); key != nil {
+		return key.WorkspaceID
+	}
+	return ""
+}
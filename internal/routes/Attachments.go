@@ -0,0 +1,148 @@
+package routes
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"composer/internal/blobstore"
+	"composer/internal/db"
+	"composer/internal/extract"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+const maxAttachmentSize = 20 << 20
+
+func RegisterAttachmentRoutes(e *echo.Echo, database *db.Db, blobs blobstore.Store) {
+	e.POST("/api/chat-sessions/:id/attachments", createAttachment(database, blobs))
+	e.GET("/api/chat-sessions/:id/attachments", listAttachments(database))
+	e.GET("/api/chat-sessions/:id/attachments/:attachmentId", downloadAttachment(database, blobs))
+	e.DELETE("/api/chat-sessions/:id/attachments/:attachmentId", deleteAttachment(database, blobs))
+}
+
+func createAttachment(database *db.Db, blobs blobstore.Store) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		sessionID := c.Param("id")
+
+		if _, err := authorizeSession(c, database, sessionID, models.RoleEditor); err != nil {
+			return err
+		}
+
+		fh, err := c.FormFile("file")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "expected a multipart upload with a file field")
+		}
+		if fh.Size > maxAttachmentSize {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("file exceeds %d MB", maxAttachmentSize>>20))
+		}
+		if !extract.Supported(fh.Filename) {
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType, fmt.Sprintf("expected one of %s", strings.Join(extract.Extensions, ", ")))
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		defer f.Close()
+
+		data, err := io.ReadAll(io.LimitReader(f, maxAttachmentSize))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+
+		text, err := extract.Text(c.Request().Context(), fh.Filename, data)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+
+		attachment := models.Attachment{
+			SessionID:   sessionID,
+			Filename:    fh.Filename,
+			ContentType: fh.Header.Get(echo.HeaderContentType),
+			Size:        int64(len(data)),
+			BlobKey:     blobstore.NewKey("sessions/" + sessionID),
+			Text:        text,
+			CreatedAt:   time.Now(),
+		}
+
+		if err := blobs.Put(c.Request().Context(), attachment.BlobKey, data); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		if err := database.InsertAttachment(&attachment); err != nil {
+			blobs.Delete(c.Request().Context(), attachment.BlobKey)
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusCreated, attachment)
+	}
+}
+
+func listAttachments(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		if _, err := authorizeSession(c, database, c.Param("id"), models.RoleViewer); err != nil {
+			return err
+		}
+
+		attachments, err := database.ListAttachments(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, attachments)
+	}
+}
+
+func downloadAttachment(database *db.Db, blobs blobstore.Store) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		if _, err := authorizeSession(c, database, c.Param("id"), models.RoleViewer); err != nil {
+			return err
+		}
+
+		attachment, err := database.GetAttachment(c.Param("id"), c.Param("attachmentId"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, err)
+		}
+
+		data, err := blobs.Get(c.Request().Context(), attachment.BlobKey)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		contentType := attachment.ContentType
+		if contentType == "" {
+			contentType = echo.MIMEOctetStream
+		}
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", attachment.Filename))
+		return c.Blob(http.StatusOK, contentType, data)
+	}
+}
+
+func deleteAttachment(database *db.Db, blobs blobstore.Store) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		if _, err := authorizeSession(c, database, c.Param("id"), models.RoleEditor); err != nil {
+			return err
+		}
+
+		attachment, err := database.GetAttachment(c.Param("id"), c.Param("attachmentId"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, err)
+		}
+
+		if err := database.DeleteAttachment(attachment.SessionID, attachment.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		if err := blobs.Delete(c.Request().Context(), attachment.BlobKey); err != nil {
+			logger(c).Error("deleting attachment blob", "blob_key", attachment.BlobKey, "attachment_id", attachment.ID, "error", err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,228 @@
+package routes
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/db"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultAuditLimit = 1000
+	maxAuditLimit     = 50000
+)
+
+// Audited actions.
+const (
+	auditSessionCreate   = "session.create"
+	auditSessionUpdate   = "session.update"
+	auditSessionDelete   = "session.delete"
+	auditMessageCreate   = "message.create"
+	auditAIGenerate      = "ai.generate"
+	auditAITitle         = "ai.title"
+	auditArtifactConvert = "artifact.convert"
+	auditArtifactImport  = "artifact.import"
+	auditVersionRestore  = "version.restore"
+)
+
+var auditColumns = []string{
+	"id", "created_at", "actor_type", "actor_id", "credential_id", "action", "session_id", "workspace_id",
+	"version", "model", "prompt_version", "input_tokens", "output_tokens", "detail", "ip",
+}
+
+func RegisterAuditRoutes(e *echo.Echo, database *db.Db) {
+	e.GET("/api/audit", listAuditEvents(database))
+}
+
+// listAuditEvents returns the audit trail a user may see: what they did
+// themselves, everything in sessions they own and in workspaces they own.
+// format=csv and format=jsonl download the same rows for export.
+func listAuditEvents(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		filter := db.AuditFilter{
+			ActorID:   c.QueryParam("actor_id"),
+			Action:    c.QueryParam("action"),
+			SessionID: c.QueryParam("session_id"),
+			Limit:     defaultAuditLimit,
+		}
+
+		var err error
+		if filter.Since, err = queryTime(c, "since"); err != nil {
+			return err
+		}
+		if filter.Until, err = queryTime(c, "until"); err != nil {
+			return err
+		}
+		if limit := c.QueryParam("limit"); limit != "" {
+			filter.Limit, err = strconv.Atoi(limit)
+			if err != nil || filter.Limit <= 0 || filter.Limit > maxAuditLimit {
+				return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxAuditLimit))
+			}
+		}
+
+		if filter.Visible, err = ownerScope(c, database); err != nil {
+			return err
+		}
+
+		events, err := database.ListAuditEvents(filter)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		switch format := c.QueryParam("format"); format {
+		case "", "json":
+			if events == nil {
+				events = []*models.AuditEvent{}
+			}
+			return c.JSON(http.StatusOK, events)
+		case "jsonl":
+			return writeAuditJSONL(c, events)
+		case "csv":
+			return writeAuditCSV(c, events)
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "format must be json, jsonl or csv")
+		}
+	}
+}
+
+// ownerScope is what a user may audit and account for: their own actions,
+// their personal sessions and the workspaces they own. Keys bound to a workspace see
+// only that workspace, and only if their user owns it.
+func ownerScope(c echo.Context, database *db.Db) (db.Scope, error) {
+	user := auth.CurrentUser(c)
+
+	if bound := keyWorkspace(c); bound != "" {
+		if _, err := authorizeWorkspace(c, database, bound, models.RoleOwner); err != nil {
+			return db.Scope{}, err
+		}
+		return db.Scope{WorkspaceIDs: []string{bound}}, nil
+	}
+
+	scope := db.Scope{UserID: user.ID}
+
+	sessions, err := database.ListChatSessions(user.ID, "")
+	if err != nil {
+		return db.Scope{}, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	for _, s := range sessions {
+		if s.UserID == user.ID && s.WorkspaceID == "" {
+			scope.SessionIDs = append(scope.SessionIDs, s.ID)
+		}
+	}
+
+	workspaces, err := database.ListWorkspaces(user.ID)
+	if err != nil {
+		return db.Scope{}, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	for _, ws := range workspaces {
+		if ws.Role == models.RoleOwner {
+			scope.WorkspaceIDs = append(scope.WorkspaceIDs, ws.ID)
+		}
+	}
+	return scope, nil
+}
+
+func queryTime(c echo.Context, name string) (time.Time, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, echo.NewHTTPError(http.StatusBadRequest, name+" must be an RFC 3339 timestamp")
+	}
+	return t, nil
+}
+
+func writeAuditJSONL(c echo.Context, events []*models.AuditEvent) error {
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	w.Header().Set(echo.HeaderContentDisposition, `attachment; filename="audit.jsonl"`)
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeAuditCSV(c echo.Context, events []*models.AuditEvent) error {
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	w.Header().Set(echo.HeaderContentDisposition, `attachment; filename="audit.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(auditColumns); err != nil {
+		return err
+	}
+	for _, e := range events {
+		err := cw.Write([]string{
+			e.ID, e.CreatedAt.UTC().Format(time.RFC3339Nano), e.ActorType, e.ActorID, e.CredentialID, e.Action, e.SessionID, e.WorkspaceID,
+			strconv.Itoa(e.Version), e.Model, e.PromptVersion, strconv.Itoa(e.InputTokens), strconv.Itoa(e.OutputTokens), e.Detail, e.IP,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// recordAudit appends event to the audit log on behalf of the caller. A
+// failure is logged rather than failing a change that has already been made.
+func recordAudit(c echo.Context, database *db.Db, session *models.ChatSession, event models.AuditEvent) {
+	if user := auth.CurrentUser(c); user != nil {
+		event.ActorType, event.ActorID = models.ActorUser, user.ID
+	}
+	if key := auth.CurrentAPIKey(c); key != nil {
+		event.ActorType, event.CredentialID = models.ActorAPIKey, key.ID
+	}
+	if session != nil {
+		event.SessionID, event.WorkspaceID = session.ID, session.WorkspaceID
+	}
+	event.IP = c.RealIP()
+	event.CreatedAt = time.Now().UTC()
+
+	if err := database.InsertAuditEvent(&event); err != nil {
+		logger(c).Error("recording audit event", "action", event.Action, "session_id", event.SessionID, "error", err)
+	}
+
+	// Every audited action that creates a session or an artifact version
+	// is also a webhook event.
+	switch {
+	case event.Action == auditSessionCreate:
+		emitEvent(c, session, models.EventSessionCreated, session)
+	case event.Version > 0:
+		emitEvent(c, session, models.EventArtifactVersionCreated, versionEvent{Version: event.Version, Action: event.Action})
+	}
+}
+
+// versionEvent is the data of an artifact.version_created event. Action is
+// the audited action that created the version, such as ai.generate.
+type versionEvent struct {
+	Version int    `json:"version"`
+	Action  string `json:"action"`
+}
+
+// latestVersion is the number of the session's newest artifact version, or
+// 0 if it has none.
+func latestVersion(c echo.Context, database *db.Db, sessionID string) int {
+	versions, err := database.ListArtifactVersions(sessionID)
+	if err != nil {
+		logger(c).Error("listing versions", "session_id", sessionID, "error", err)
+		return 0
+	}
+	return len(versions)
+}
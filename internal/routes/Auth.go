@@ -0,0 +1,233 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/db"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	oidcStateCookie = "composer_oidc_state"
+	oidcNonceCookie = "composer_oidc_nonce"
+)
+
+// RegisterAuthRoutes adds local sign-in, and OIDC sign-in when provider is
+// configured. registration decides who may create an account.
+func RegisterAuthRoutes(e *echo.Echo, database *db.Db, provider *auth.OIDC, registration auth.Registration) {
+	e.POST("/api/auth/register", register(database, registration))
+	e.POST("/api/auth/login", login(database))
+	e.POST("/api/auth/logout", logout(database))
+	e.GET("/api/auth/me", me)
+
+	if provider != nil {
+		e.GET("/api/auth/oidc/login", oidcLogin(provider))
+		e.GET("/api/auth/oidc/callback", oidcCallback(database, provider, registration))
+	}
+}
+
+type credentials struct {
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type loginResponse struct {
+	Token     string       `json:"token"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	User      *models.User `json:"user"`
+}
+
+func register(database *db.Db, registration auth.Registration) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		if !registration.Open {
+			return echo.NewHTTPError(http.StatusForbidden, auth.ErrRegistrationClosed.Error())
+		}
+		var req credentials
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+
+		req.Email = strings.TrimSpace(req.Email)
+		if !strings.Contains(req.Email, "@") {
+			return echo.NewHTTPError(http.StatusBadRequest, "a valid email is required")
+		}
+		if !registration.AllowsEmail(req.Email) {
+			return echo.NewHTTPError(http.StatusForbidden, auth.ErrDomainNotAllowed.Error())
+		}
+		if _, err := database.GetUserByEmail(req.Email); err == nil {
+			return echo.NewHTTPError(http.StatusConflict, "an account with this email already exists")
+		}
+
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		user := &models.User{
+			Email:        req.Email,
+			Name:         req.Name,
+			PasswordHash: hash,
+			CreatedAt:    time.Now(),
+		}
+		if err := database.InsertUser(user); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return signIn(c, database, user, http.StatusCreated)
+	}
+}
+
+func login(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		var req credentials
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+
+		user, err := database.GetUserByEmail(strings.TrimSpace(req.Email))
+		if err != nil && !errors.Is(err, db.ErrUserNotFound) {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		if user == nil || auth.CheckPassword(user, req.Password) != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, auth.ErrInvalidCredentials.Error())
+		}
+
+		return signIn(c, database, user, http.StatusOK)
+	}
+}
+
+func signIn(c echo.Context, database *db.Db, user *models.User, status int) error {
+	token, session, err := auth.StartSession(c, database, user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.JSON(status, loginResponse{Token: token, ExpiresAt: session.ExpiresAt, User: user})
+}
+
+func logout(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		if err := auth.EndSession(c, database); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func me(c echo.Context) error {
+	return c.JSON(http.StatusOK, auth.CurrentUser(c))
+}
+
+func oidcLogin(provider *auth.OIDC) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		state, _, err := auth.NewToken()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		nonce, _, err := auth.NewToken()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		setFlowCookie(c, oidcStateCookie, state, 600)
+		setFlowCookie(c, oidcNonceCookie, nonce, 600)
+		return c.Redirect(http.StatusFound, provider.AuthCodeURL(state, nonce))
+	}
+}
+
+// oidcCallback finishes the authorization code flow. Users are matched on
+// their OIDC identity first; an existing local account is linked only when
+// the provider has verified the email address. New users need an allowed
+// email domain, which the provider must have verified too.
+func oidcCallback(database *db.Db, provider *auth.OIDC, registration auth.Registration) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		state, err := c.Cookie(oidcStateCookie)
+		if err != nil || state.Value == "" || c.QueryParam("state") != state.Value {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid OIDC state")
+		}
+		nonce, err := c.Cookie(oidcNonceCookie)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid OIDC state")
+		}
+		setFlowCookie(c, oidcStateCookie, "", -1)
+		setFlowCookie(c, oidcNonceCookie, "", -1)
+
+		if msg := c.QueryParam("error"); msg != "" {
+			return echo.NewHTTPError(http.StatusUnauthorized, "sign in failed: "+msg)
+		}
+
+		claims, err := provider.Exchange(c.Request().Context(), c.QueryParam("code"), nonce.Value)
+		if err != nil {
+			logger(c).Error("completing OIDC sign in", "error", err)
+			return echo.NewHTTPError(http.StatusUnauthorized, "sign in failed")
+		}
+
+		user, err := oidcUser(database, provider.Issuer, claims, registration)
+		if err != nil {
+			return err
+		}
+
+		if _, _, err := auth.StartSession(c, database, user); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		return c.Redirect(http.StatusFound, "/")
+	}
+}
+
+func oidcUser(database *db.Db, issuer string, claims *auth.Claims, registration auth.Registration) (*models.User, error) {
+	user, err := database.GetUserByOIDC(issuer, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, db.ErrUserNotFound) {
+		return nil, err
+	}
+
+	user, err = database.GetUserByEmail(claims.Email)
+	switch {
+	case err == nil:
+		if !claims.EmailVerified || user.OIDCSubject != "" {
+			return nil, echo.NewHTTPError(http.StatusConflict, "an account with this email already exists")
+		}
+		user.OIDCIssuer, user.OIDCSubject = issuer, claims.Subject
+		return user, database.LinkUserOIDC(user)
+	case !errors.Is(err, db.ErrUserNotFound):
+		return nil, err
+	}
+
+	if len(registration.Domains) > 0 && (!claims.EmailVerified || !registration.AllowsEmail(claims.Email)) {
+		return nil, echo.NewHTTPError(http.StatusForbidden, auth.ErrDomainNotAllowed.Error())
+	}
+
+	user = &models.User{
+		Email:       claims.Email,
+		Name:        claims.Name,
+		OIDCIssuer:  issuer,
+		OIDCSubject: claims.Subject,
+		CreatedAt:   time.Now(),
+	}
+	return user, database.InsertUser(user)
+}
+
+// setFlowCookie stores short-lived state for the OIDC redirect round trip.
+func setFlowCookie(c echo.Context, name, value string, maxAge int) {
+	c.SetCookie(&http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/api/auth/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
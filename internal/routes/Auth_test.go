@@ -0,0 +1,276 @@
+package routes
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/db"
+
+	"github.com/labstack/echo/v4"
+)
+
+// mockIssuer is an OIDC provider whose token endpoint answers any code with
+// an ID token for claims, carrying nonce.
+type mockIssuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	nonce  string
+	claims map[string]any
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, claims: map[string]any{
+		"sub":            "alice",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+	}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   b64(key.N.Bytes()),
+			"e":   b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     m.idToken(t),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (m *mockIssuer) idToken(t *testing.T) string {
+	claims := map[string]any{
+		"iss":   m.URL,
+		"aud":   "composer",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": m.nonce,
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Error(err)
+	}
+	return signed + "." + b64(sig)
+}
+
+func newAuthServer(t *testing.T, provider *auth.OIDC, registration auth.Registration) (*echo.Echo, *db.Db) {
+	t.Helper()
+	database := newTestDb(t)
+	e := echo.New()
+	RegisterAuthRoutes(e, database, provider, registration)
+	return e, database
+}
+
+func serve(e *echo.Echo, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func cookie(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// startOIDC signs in up to the redirect to the provider, and returns the
+// state and nonce cookies set for the callback.
+func startOIDC(t *testing.T, e *echo.Echo) (state, nonce *http.Cookie) {
+	t.Helper()
+	rec := serve(e, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login = %d, want %d", rec.Code, http.StatusFound)
+	}
+	state, nonce = cookie(rec, oidcStateCookie), cookie(rec, oidcNonceCookie)
+	if state == nil || nonce == nil || state.Value == "" || nonce.Value == "" {
+		t.Fatalf("login set cookies %v, want state and nonce", rec.Result().Cookies())
+	}
+
+	redirect, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := redirect.Query(); q.Get("state") != state.Value || q.Get("nonce") != nonce.Value {
+		t.Fatalf("redirect %s doesn't carry the state and nonce of the cookies", redirect)
+	}
+	return state, nonce
+}
+
+func callback(state string, cookies ...*http.Cookie) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?code=code&state="+url.QueryEscape(state), nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestOIDCCallback(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider, err := auth.NewOIDC(context.Background(), issuer.URL, "composer", "secret", "http://composer.test/api/auth/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		req    func(state, nonce *http.Cookie) *http.Request
+		nonce  func(nonce *http.Cookie) string
+		status int
+	}{
+		{
+			name:   "signs in",
+			req:    func(state, nonce *http.Cookie) *http.Request { return callback(state.Value, state, nonce) },
+			status: http.StatusFound,
+		},
+		{
+			name:   "state mismatch",
+			req:    func(state, nonce *http.Cookie) *http.Request { return callback("forged", state, nonce) },
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "no state cookie",
+			req:    func(state, nonce *http.Cookie) *http.Request { return callback(state.Value, nonce) },
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "no nonce cookie",
+			req:    func(state, nonce *http.Cookie) *http.Request { return callback(state.Value, state) },
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "nonce mismatch",
+			req:    func(state, nonce *http.Cookie) *http.Request { return callback(state.Value, state, nonce) },
+			nonce:  func(*http.Cookie) string { return "replayed" },
+			status: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _ := newAuthServer(t, provider, auth.Registration{})
+			state, nonce := startOIDC(t, e)
+			issuer.nonce = nonce.Value
+			if tt.nonce != nil {
+				issuer.nonce = tt.nonce(nonce)
+			}
+
+			rec := serve(e, tt.req(state, nonce))
+			if rec.Code != tt.status {
+				t.Fatalf("callback = %d %s, want %d", rec.Code, rec.Body, tt.status)
+			}
+			if signedIn := cookie(rec, auth.CookieName) != nil; signedIn != (tt.status == http.StatusFound) {
+				t.Fatalf("callback set a session cookie: %v", signedIn)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackAllowedDomains(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider, err := auth.NewOIDC(context.Background(), issuer.URL, "composer", "secret", "http://composer.test/api/auth/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		email    string
+		verified bool
+		status   int
+	}{
+		{"alice@example.com", true, http.StatusFound},
+		{"alice@EXAMPLE.com", true, http.StatusFound},
+		{"alice@example.com", false, http.StatusForbidden},
+		{"alice@example.org", true, http.StatusForbidden},
+		{"alice@mail.example.com", true, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			e, _ := newAuthServer(t, provider, auth.Registration{Domains: []string{"example.com"}})
+			issuer.claims["email"], issuer.claims["email_verified"] = tt.email, tt.verified
+			state, nonce := startOIDC(t, e)
+			issuer.nonce = nonce.Value
+
+			if rec := serve(e, callback(state.Value, state, nonce)); rec.Code != tt.status {
+				t.Fatalf("callback = %d %s, want %d", rec.Code, rec.Body, tt.status)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name         string
+		registration auth.Registration
+		email        string
+		status       int
+	}{
+		{"open", auth.Registration{Open: true}, "bo@example.org", http.StatusCreated},
+		{"closed", auth.Registration{}, "bo@example.org", http.StatusForbidden},
+		{"allowed domain", auth.Registration{Open: true, Domains: []string{"example.org"}}, "bo@Example.org", http.StatusCreated},
+		{"other domain", auth.Registration{Open: true, Domains: []string{"example.org"}}, "bo@example.com", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _ := newAuthServer(t, nil, tt.registration)
+			body := `{"email": "` + tt.email + `", "name": "Bo", "password": "correct horse"}`
+			req := httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if rec := serve(e, req); rec.Code != tt.status {
+				t.Fatalf("register = %d %s, want %d", rec.Code, rec.Body, tt.status)
+			}
+		})
+	}
+}
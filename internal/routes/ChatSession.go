@@ -1,29 +1,48 @@
 package routes
 
 import (
+	"fmt"
 	"net/http"
 
+	"composer/internal/auth"
+	"composer/internal/blobstore"
 	"composer/internal/db"
 	"composer/internal/models"
 
 	"github.com/labstack/echo/v4"
 )
 
-func RegisterChatSessionRoutes(e *echo.Echo, database *db.Db) {
+func RegisterChatSessionRoutes(e *echo.Echo, database *db.Db, blobs blobstore.Store) {
 	e.POST("/api/chat-sessions", createChatSession(database))
 	e.GET("/api/chat-sessions", listChatSessions(database))
 	e.GET("/api/chat-sessions/:id", getChatSession(database))
 	e.PUT("/api/chat-sessions/:id", updateChatSession(database))
-	e.DELETE("/api/chat-sessions/:id", deleteChatSession(database))
+	e.DELETE("/api/chat-sessions/:id", deleteChatSession(database, blobs))
 }
 
 func createChatSession(database *db.Db) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		session := models.ChatSession{}
+		database := database.WithContext(c.Request().Context())
+		var req models.ChatSession
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+
+		if req.WorkspaceID == "" {
+			req.WorkspaceID = keyWorkspace(c)
+		}
+
+		session := models.ChatSession{UserID: auth.CurrentUser(c).ID, WorkspaceID: req.WorkspaceID}
+		if session.WorkspaceID != "" {
+			if _, err := authorizeWorkspace(c, database, session.WorkspaceID, models.RoleEditor); err != nil {
+				return err
+			}
+		}
 
 		if err := database.InsertChatSession(&session); err != nil {
 			return c.JSON(http.StatusInternalServerError, err)
 		}
+		recordAudit(c, database, &session, models.AuditEvent{Action: auditSessionCreate})
 
 		return c.JSON(http.StatusCreated, session)
 	}
@@ -31,7 +50,16 @@ func createChatSession(database *db.Db) echo.HandlerFunc {
 
 func listChatSessions(database *db.Db) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		chatSessions, err := database.ListChatSessions()
+		database := database.WithContext(c.Request().Context())
+		workspaceID := c.QueryParam("workspace_id")
+		if ws := keyWorkspace(c); ws != "" {
+			if workspaceID != "" && workspaceID != ws {
+				return echo.NewHTTPError(http.StatusNotFound, "workspace not found")
+			}
+			workspaceID = ws
+		}
+
+		chatSessions, err := database.ListChatSessions(auth.CurrentUser(c).ID, workspaceID)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, err)
 		}
@@ -42,11 +70,12 @@ func listChatSessions(database *db.Db) echo.HandlerFunc {
 
 func getChatSession(database *db.Db) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
 		id := c.Param("id")
 
-		chatSession, err := database.GetChatSession(id)
+		chatSession, err := authorizeSession(c, database, id, models.RoleViewer)
 		if err != nil {
-			return c.JSON(http.StatusNotFound, err)
+			return err
 		}
 
 		return c.JSON(http.StatusOK, chatSession)
@@ -55,30 +84,93 @@ func getChatSession(database *db.Db) echo.HandlerFunc {
 
 func updateChatSession(database *db.Db) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
 		id := c.Param("id")
 
-		var chatSession models.ChatSession
-		if err := c.Bind(&chatSession); err != nil {
+		chatSession, err := authorizeSession(c, database, id, models.RoleEditor)
+		if err != nil {
+			return err
+		}
+
+		var update models.ChatSession
+		if err := c.Bind(&update); err != nil {
 			return c.JSON(http.StatusBadRequest, err)
 		}
 
-		chatSession.ID = id
-		if err := database.UpdateChatSession(&chatSession); err != nil {
+		chatSession.Title = update.Title
+		if err := database.UpdateChatSession(chatSession); err != nil {
 			return c.JSON(http.StatusInternalServerError, err)
 		}
+		recordAudit(c, database, chatSession, models.AuditEvent{Action: auditSessionUpdate, Detail: "title"})
 
 		return c.JSON(http.StatusOK, chatSession)
 	}
 }
 
-func deleteChatSession(database *db.Db) echo.HandlerFunc {
+func deleteChatSession(database *db.Db, blobs blobstore.Store) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
 		id := c.Param("id")
 
-		if err := database.DeleteChatSession(id); err != nil {
+		chatSession, err := authorizeSession(c, database, id, models.RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		blobKeys, err := database.DeleteChatSession(id)
+		if err != nil {
 			return c.JSON(http.StatusInternalServerError, err)
 		}
+		for _, key := range blobKeys {
+			if err := blobs.Delete(c.Request().Context(), key); err != nil {
+				logger(c).Error("deleting attachment blob", "blob_key", key, "session_id", id, "error", err)
+			}
+		}
+		recordAudit(c, database, chatSession, models.AuditEvent{Action: auditSessionDelete})
 
 		return c.NoContent(http.StatusNoContent)
 	}
 }
+
+// authorizeSession loads a chat session for the signed-in user and checks
+// that their role allows at least min. Users with no access at all get a 404
+// rather than a 403, so that a session's existence isn't revealed.
+func authorizeSession(c echo.Context, database *db.Db, id string, min models.Role) (*models.ChatSession, error) {
+	chatSession, err := database.GetChatSession(id)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if ws := keyWorkspace(c); ws != "" && chatSession.WorkspaceID != ws {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "chat session not found")
+	}
+
+	role, err := sessionRole(database, chatSession, auth.CurrentUser(c))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if role == "" {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "chat session not found")
+	}
+	if !role.AtLeast(min) {
+		return nil, echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("this requires the %s role, you are a %s", min, role))
+	}
+
+	return chatSession, nil
+}
+
+// sessionRole is the user's role in the session's workspace. A personal
+// session is owned by its creator and closed to everyone else. Creating a
+// workspace session grants nothing by itself, so that a member who is removed
+// or demoted loses access to the sessions they made too.
+func sessionRole(database *db.Db, chatSession *models.ChatSession, user *models.User) (models.Role, error) {
+	if user == nil {
+		return "", nil
+	}
+	if chatSession.WorkspaceID != "" {
+		return database.GetWorkspaceRole(chatSession.WorkspaceID, user.ID)
+	}
+	if chatSession.UserID == user.ID {
+		return models.RoleOwner, nil
+	}
+	return "", nil
+}
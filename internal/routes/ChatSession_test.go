@@ -0,0 +1,120 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"composer/internal/db"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newTestDb(t *testing.T) *db.Db {
+	t.Helper()
+	database, err := db.New("sqlite3", filepath.Join(t.TempDir(), "composer.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func newTestUser(t *testing.T, database *db.Db, email string) *models.User {
+	t.Helper()
+	user := &models.User{Email: email, Name: email, CreatedAt: time.Now()}
+	if err := database.InsertUser(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestAuthorizeSession(t *testing.T) {
+	database := newTestDb(t)
+	owner := newTestUser(t, database, "owner@example.com")
+	viewer := newTestUser(t, database, "viewer@example.com")
+	editor := newTestUser(t, database, "editor@example.com")
+	former := newTestUser(t, database, "former@example.com")
+	stranger := newTestUser(t, database, "stranger@example.com")
+
+	ws := &models.Workspace{Name: "Team", CreatedAt: time.Now()}
+	if err := database.InsertWorkspace(ws, owner.ID); err != nil {
+		t.Fatal(err)
+	}
+	for user, role := range map[*models.User]models.Role{viewer: models.RoleViewer, editor: models.RoleEditor} {
+		if err := database.SetWorkspaceMember(&models.WorkspaceMember{WorkspaceID: ws.ID, UserID: user.ID, Role: role, CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	personal := &models.ChatSession{Title: "Personal", UserID: owner.ID}
+	// Created by someone who has since left the workspace.
+	shared := &models.ChatSession{Title: "Shared", UserID: former.ID, WorkspaceID: ws.ID}
+	for _, s := range []*models.ChatSession{personal, shared} {
+		if err := database.InsertChatSession(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		user    *models.User
+		key     *models.APIKey
+		session *models.ChatSession
+		min     models.Role
+		role    models.Role
+		status  int
+	}{
+		{"creator owns a personal session", owner, nil, personal, models.RoleOwner, models.RoleOwner, 0},
+		{"personal session hidden from others", stranger, nil, personal, models.RoleViewer, "", http.StatusNotFound},
+		{"personal session hidden from workspace members", editor, nil, personal, models.RoleViewer, "", http.StatusNotFound},
+		{"workspace owner", owner, nil, shared, models.RoleOwner, models.RoleOwner, 0},
+		{"workspace editor", editor, nil, shared, models.RoleEditor, models.RoleEditor, 0},
+		{"workspace viewer can read", viewer, nil, shared, models.RoleViewer, models.RoleViewer, 0},
+		{"workspace viewer can't edit", viewer, nil, shared, models.RoleEditor, models.RoleViewer, http.StatusForbidden},
+		{"creator who left", former, nil, shared, models.RoleViewer, "", http.StatusNotFound},
+		{"non-member", stranger, nil, shared, models.RoleViewer, "", http.StatusNotFound},
+		{"signed out", nil, nil, shared, models.RoleViewer, "", http.StatusNotFound},
+		{"key bound to the workspace", editor, &models.APIKey{WorkspaceID: ws.ID}, shared, models.RoleEditor, models.RoleEditor, 0},
+		{"key bound to another workspace", editor, &models.APIKey{WorkspaceID: "other"}, shared, models.RoleViewer, models.RoleEditor, http.StatusNotFound},
+		{"workspace key on a personal session", owner, &models.APIKey{WorkspaceID: ws.ID}, personal, models.RoleViewer, models.RoleOwner, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, err := sessionRole(database, tt.session, tt.user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if role != tt.role {
+				t.Errorf("sessionRole() = %q, want %q", role, tt.role)
+			}
+
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+			if tt.user != nil {
+				c.Set("user", tt.user)
+			}
+			if tt.key != nil {
+				c.Set("api_key", tt.key)
+			}
+			got, err := authorizeSession(c, database, tt.session.ID, tt.min)
+			if tt.status == 0 {
+				if err != nil || got.ID != tt.session.ID {
+					t.Fatalf("authorizeSession() = %v, %v; want session %s", got, err, tt.session.ID)
+				}
+				return
+			}
+			var httpErr *echo.HTTPError
+			if !errors.As(err, &httpErr) || httpErr.Code != tt.status {
+				t.Fatalf("authorizeSession() error = %v, want status %d", err, tt.status)
+			}
+		})
+	}
+
+	if _, err := authorizeSession(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), nil), database, "404", models.RoleViewer); err == nil {
+		t.Error("authorizeSession() of a missing session succeeded")
+	}
+}
@@ -0,0 +1,120 @@
+package routes
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"composer/internal/models"
+	"composer/internal/sanitize"
+)
+
+// citationMarker matches the markers the model is asked to place after a
+// claim, e.g. [cite:K2].
+var citationMarker = regexp.MustCompile(`\[cite:\s*([A-Za-z]\d+)\s*\]`)
+
+// citationResolver turns the model's [cite:ID] markers into numbered
+// footnote markers. IDs are only meaningful within the turn that retrieved
+// the references, so numbers are what the artifact keeps from turn to turn.
+// Numbering continues from the citations already in the artifact.
+type citationResolver struct {
+	refs       map[string]reference
+	previous   []models.Citation
+	numbers    map[string]int
+	added      []models.Citation
+	next       int
+	Violations []sanitize.Violation
+}
+
+func newCitationResolver(refs []reference, previous []models.Citation) *citationResolver {
+	r := &citationResolver{
+		refs:     map[string]reference{},
+		previous: previous,
+		numbers:  map[string]int{},
+		next:     1,
+	}
+	for _, ref := range refs {
+		r.refs[ref.ID] = ref
+	}
+	for _, c := range previous {
+		r.next = max(r.next, c.Number+1)
+	}
+	return r
+}
+
+// resolve replaces every marker in text. Markers naming a reference that was
+// not supplied this turn are removed and reported, so that no claim points at
+// a source the model never saw.
+func (r *citationResolver) resolve(text string, isHTML bool) string {
+	return citationMarker.ReplaceAllStringFunc(text, func(m string) string {
+		id := strings.ToUpper(citationMarker.FindStringSubmatch(m)[1])
+		ref, ok := r.refs[id]
+		if !ok {
+			r.Violations = append(r.Violations, sanitize.Violation{Kind: "unknown_citation", Detail: fmt.Sprintf("removed citation of %s, which is not one of the supplied references", id)})
+			return ""
+		}
+
+		n, ok := r.numbers[id]
+		if !ok {
+			n, ok = r.previousNumber(ref.Name)
+		}
+		if ok {
+			r.numbers[id] = n
+		} else {
+			n = r.next
+			r.next++
+			r.numbers[id] = n
+			r.added = append(r.added, models.Citation{Number: n, ID: id, Source: ref.Name, Snippet: snippet(ref.Text)})
+		}
+		return citationText(n, isHTML)
+	})
+}
+
+// previousNumber reuses the footnote of a source the artifact already cites.
+func (r *citationResolver) previousNumber(source string) (int, bool) {
+	for _, c := range r.previous {
+		if c.Source == source {
+			return c.Number, true
+		}
+	}
+	return 0, false
+}
+
+// citations lists the footnotes of the resolved artifact: earlier citations
+// that are still referenced, followed by the ones added this turn.
+func (r *citationResolver) citations(artifact string) []models.Citation {
+	out := citedIn(artifact, r.previous)
+	out = append(out, r.added...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Number < out[j].Number })
+	return out
+}
+
+func citationText(n int, isHTML bool) string {
+	if isHTML {
+		return fmt.Sprintf(`<sup class="citation" data-citation="%d">[%d]</sup>`, n, n)
+	}
+	return fmt.Sprintf("[%d]", n)
+}
+
+// citedIn returns the citations whose marker still appears in artifact.
+func citedIn(artifact string, citations []models.Citation) []models.Citation {
+	var out []models.Citation
+	for _, c := range citations {
+		if strings.Contains(artifact, fmt.Sprintf(`data-citation="%d"`, c.Number)) || strings.Contains(artifact, fmt.Sprintf("[%d]", c.Number)) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// latestCitations returns the citations of the most recent AI message with an
+// artifact; user edits don't change the sources an artifact cites.
+func latestCitations(history []*models.ChatMessage) []models.Citation {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == "ai" && history[i].Doc != "" {
+			return history[i].Citations
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/db"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func RegisterCommentRoutes(e *echo.Echo, database *db.Db) {
+	e.GET("/api/chat-sessions/:id/comments", listComments(database))
+	e.POST("/api/chat-sessions/:id/comments", createComment(database))
+	e.DELETE("/api/chat-sessions/:id/comments/:commentId", deleteComment(database))
+}
+
+func listComments(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		if _, err := authorizeSession(c, database, c.Param("id"), models.RoleViewer); err != nil {
+			return err
+		}
+
+		comments, err := database.ListComments(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, comments)
+	}
+}
+
+func createComment(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		sessionID := c.Param("id")
+		session, err := authorizeSession(c, database, sessionID, models.RoleCommenter)
+		if err != nil {
+			return err
+		}
+
+		var req models.Comment
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		if strings.TrimSpace(req.Body) == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "body is required")
+		}
+
+		user := auth.CurrentUser(c)
+		comment := models.Comment{
+			SessionID:    sessionID,
+			UserID:       user.ID,
+			AuthorName:   displayName(user),
+			Body:         req.Body,
+			SelectedText: req.SelectedText,
+			CreatedAt:    time.Now(),
+		}
+		if err := database.InsertComment(&comment); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		emitEvent(c, session, models.EventCommentAdded, comment)
+
+		return c.JSON(http.StatusCreated, comment)
+	}
+}
+
+// deleteComment lets authors remove their own comments and editors remove
+// anyone's.
+func deleteComment(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		sessionID := c.Param("id")
+		if _, err := authorizeSession(c, database, sessionID, models.RoleCommenter); err != nil {
+			return err
+		}
+
+		comment, err := database.GetComment(sessionID, c.Param("commentId"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		if comment.UserID != auth.CurrentUser(c).ID {
+			if _, err := authorizeSession(c, database, sessionID, models.RoleEditor); err != nil {
+				return err
+			}
+		}
+
+		if err := database.DeleteComment(sessionID, comment.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func displayName(user *models.User) string {
+	if user.Name != "" {
+		return user.Name
+	}
+	return user.Email
+}
@@ -0,0 +1,79 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/convert"
+	"composer/internal/db"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func RegisterConvertRoutes(e *echo.Echo, database *db.Db) {
+	e.POST("/api/chat-sessions/:id/convert", convertArtifact(database))
+}
+
+// convertArtifact converts the latest artifact of a session between HTML and
+// Markdown and records the result as a new version, so switching editors
+// doesn't need a round trip through the model.
+func convertArtifact(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		sessionID := c.Param("id")
+
+		to, err := convert.ParseFormat(c.QueryParam("to"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		session, err := authorizeSession(c, database, sessionID, models.RoleEditor)
+		if err != nil {
+			return err
+		}
+
+		versions, err := database.ListArtifactVersions(sessionID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		if len(versions) == 0 {
+			return echo.NewHTTPError(http.StatusNotFound, "session has no artifact to convert")
+		}
+		latest := versions[len(versions)-1]
+
+		from := convert.Stored(latest.Format, latest.Contents)
+		if from == to {
+			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("artifact is already %s", to))
+		}
+
+		converted, err := convert.Convert(latest.Contents, from, to)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		// Conversions are stored as an AI turn so the next createMessage diffs
+		// user edits against the converted artifact rather than the original.
+		msg := models.ChatMessage{
+			SessionID: sessionID,
+			Role:      "ai",
+			Content:   fmt.Sprintf("Converted the artifact to %s.", to),
+			Doc:       converted,
+			Format:    string(to),
+			UserID:    auth.CurrentUser(c).ID,
+			CreatedAt: time.Now(),
+		}
+		if err := database.InsertChatMessage(&msg); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		recordAudit(c, database, session, models.AuditEvent{
+			Action:  auditArtifactConvert,
+			Version: latestVersion(c, database, sessionID),
+			Detail:  string(to),
+		})
+
+		return c.JSON(http.StatusCreated, msg)
+	}
+}
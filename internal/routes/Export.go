@@ -0,0 +1,111 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"composer/internal/auth"
+	"composer/internal/convert"
+	"composer/internal/db"
+	"composer/internal/export"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func RegisterExportRoutes(e *echo.Echo, database *db.Db, brand export.Branding) {
+	e.GET("/api/chat-sessions/:id/export", exportArtifact(database, brand))
+}
+
+func exportArtifact(database *db.Db, brand export.Branding) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		sessionID := c.Param("id")
+
+		format, err := export.ParseFormat(c.QueryParam("format"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		session, err := authorizeSession(c, database, sessionID, models.RoleViewer)
+		if err != nil {
+			return err
+		}
+
+		versions, err := database.ListArtifactVersions(sessionID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		version, err := selectVersion(versions, c.QueryParam("version"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+
+		title := session.Title
+		if title == "" {
+			title = "Untitled document"
+		}
+		meta := export.Metadata{
+			Title:   title,
+			Author:  exportAuthor(database, session, auth.CurrentUser(c), brand),
+			Version: version.Version,
+			Date:    version.CreatedAt,
+		}
+		for _, cite := range versionCitations(versions, version) {
+			meta.Footnotes = append(meta.Footnotes, export.Footnote{Number: cite.Number, Source: cite.Source, Snippet: cite.Snippet})
+		}
+
+		file, err := export.Export(version.Contents, convert.Stored(version.Format, version.Contents), format, meta, brand)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", export.Filename(meta, format)))
+		return c.Blob(http.StatusOK, format.ContentType(), file)
+	}
+}
+
+// exportAuthor is the name of the session's owner, or of the user exporting
+// it, or else the organization's.
+func exportAuthor(database *db.Db, session *models.ChatSession, user *models.User, brand export.Branding) string {
+	if session.UserID != "" {
+		if owner, err := database.GetUser(session.UserID); err == nil && owner.Name != "" {
+			return owner.Name
+		}
+	}
+	if user != nil && user.Name != "" {
+		return user.Name
+	}
+	return brand.Name
+}
+
+// selectVersion picks version n (1-based) of a session's artifact, or the
+// latest one when no version is given.
+func selectVersion(versions []*models.Document, param string) (*models.Document, error) {
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("session has no artifact")
+	}
+	if param == "" {
+		return versions[len(versions)-1], nil
+	}
+
+	n, err := strconv.Atoi(param)
+	if err != nil || n < 1 || n > len(versions) {
+		return nil, fmt.Errorf("version %s not found, session has %d versions", param, len(versions))
+	}
+	return versions[n-1], nil
+}
+
+// versionCitations returns the sources cited by a version. Versions saved from
+// user edits carry no citations of their own, so they inherit those of the
+// closest earlier version that does, as far as the markers survived the edit.
+func versionCitations(versions []*models.Document, version *models.Document) []models.Citation {
+	for i := version.Version - 1; i >= 0; i-- {
+		if len(versions[i].Citations) > 0 {
+			return citedIn(version.Contents, versions[i].Citations)
+		}
+	}
+	return nil
+}
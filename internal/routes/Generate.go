@@ -0,0 +1,329 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/convert"
+	"composer/internal/db"
+	"composer/internal/models"
+	"composer/internal/ratelimit"
+	"composer/internal/sanitize"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// headerIdempotencyKey names a generation, so that sending it again, after a
+// timeout say, returns the first response rather than generating twice. A
+// generation holds its key for idempotencyLock at most; a request repeated
+// while it runs is told to retry after idempotencyRetry.
+const (
+	headerIdempotencyKey = "Idempotency-Key"
+	maxIdempotencyKey    = 255
+	idempotencyLock      = 10 * time.Minute
+	idempotencyRetry     = 5 * time.Second
+)
+
+// maxGenerateRequest bounds the body of /api/generate, which is read whole
+// before generating: an artifact to edit, and the prompt.
+const maxGenerateRequest = "4M"
+
+// RegisterGenerateRoutes adds headless generation, for automation that
+// wants a finished document rather than a stream. Templates are read from
+// templatesDir.
+func RegisterGenerateRoutes(e *echo.Echo, limiter *ratelimit.Limiter, templatesDir string) {
+	e.POST("/api/generate", generateDocument(templatesDir), middleware.BodyLimit(maxGenerateRequest), limiter.Middleware(headlessSubjects))
+	e.GET("/api/templates", listTemplates(templatesDir))
+}
+
+type generateRequest struct {
+	Prompt   string `json:"prompt"`
+	Artifact string `json:"artifact,omitempty"`
+	// Format is html or markdown, the default.
+	Format   string `json:"format"`
+	Template string `json:"template,omitempty"`
+
+	// Save keeps the request and the response as a new session, in
+	// WorkspaceID if given.
+	Save        bool   `json:"save"`
+	WorkspaceID string `json:"workspace_id,omitempty"`
+}
+
+type generateResponse struct {
+	Artifact    string                    `json:"artifact"`
+	Explanation string                    `json:"explanation"`
+	Edits       []appliedEdit             `json:"edits"`
+	Citations   []models.Citation         `json:"citations,omitempty"`
+	Violations  []sanitize.Violation      `json:"violations,omitempty"`
+	Guardrails  []models.GuardrailFinding `json:"guardrails,omitempty"`
+	Withheld    bool                      `json:"withheld,omitempty"`
+	Usage       generateUsage             `json:"usage"`
+	SessionID   string                    `json:"session_id,omitempty"`
+	Version     int                       `json:"version,omitempty"`
+}
+
+type generateUsage struct {
+	Model        string  `json:"model"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Cost         float64 `json:"cost"`
+}
+
+// generateDocument runs one turn of the same pipeline as createMessage to
+// completion and returns the result as JSON.
+func generateDocument(templatesDir string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := c.Get("db").(*db.Db)
+
+		raw, err := io.ReadAll(c.Request().Body)
+		if errors.Is(err, echo.ErrStatusRequestEntityTooLarge) {
+			return err
+		}
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		c.Request().Body = io.NopCloser(bytes.NewReader(raw))
+		var req generateRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		if strings.TrimSpace(req.Prompt) == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "prompt is required")
+		}
+
+		format := convert.Markdown
+		if req.Format != "" {
+			var err error
+			if format, err = convert.ParseFormat(req.Format); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+		}
+
+		var template string
+		if req.Template != "" {
+			var err error
+			if template, err = loadTemplate(templatesDir, req.Template, format); err != nil {
+				return err
+			}
+		}
+
+		if req.WorkspaceID == "" {
+			req.WorkspaceID = keyWorkspace(c)
+		}
+		session := &models.ChatSession{UserID: auth.CurrentUser(c).ID, WorkspaceID: req.WorkspaceID}
+		if session.WorkspaceID != "" {
+			if _, err := authorizeWorkspace(c, database, session.WorkspaceID, models.RoleEditor); err != nil {
+				return err
+			}
+		}
+
+		idem, claimed, err := claimIdempotencyKey(c, database, raw)
+		if err != nil {
+			return err
+		}
+		if idem != nil && idem.ResponseStatus != 0 {
+			return c.JSONBlob(idem.ResponseStatus, idem.Response)
+		}
+		if idem != nil && !claimed {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(idempotencyRetry/time.Second)))
+			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("a request with this %s is still running", headerIdempotencyKey))
+		}
+		if idem != nil {
+			// Failed attempts give the key back at once, so that a retry
+			// needn't wait for the lock to expire.
+			defer func() {
+				if idem.ResponseStatus == 0 {
+					if err := database.ReleaseIdempotencyKey(idem); err != nil {
+						logger(c).Error("releasing the idempotency key", "error", err)
+					}
+				}
+			}()
+		}
+
+		if req.Save && idem != nil && idem.SessionID != "" {
+			// An earlier attempt created the session before failing.
+			if session, err = authorizeSession(c, database, idem.SessionID, models.RoleEditor); err != nil {
+				return err
+			}
+		} else if req.Save {
+			if err := database.InsertChatSession(session); err != nil {
+				return c.JSON(http.StatusInternalServerError, err)
+			}
+			recordAudit(c, database, session, models.AuditEvent{Action: auditSessionCreate})
+			if idem != nil {
+				idem.SessionID = session.ID
+				if err := database.SetIdempotencySession(idem); err != nil {
+					return c.JSON(http.StatusInternalServerError, err)
+				}
+			}
+		}
+
+		result, err := runTurn(c, database, turn{
+			session: session,
+			request: requestBody{
+				Content:          req.Prompt,
+				Artifact:         req.Artifact,
+				IsDocumentEditor: format == convert.HTML,
+			},
+			template: template,
+			persist:  req.Save,
+		})
+		var blocked *requestBlocked
+		if errors.As(err, &blocked) {
+			return c.JSON(http.StatusUnprocessableEntity, guardrailsResponse{
+				Message:    "The request was blocked by the content policy.",
+				Guardrails: blocked.findings,
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		res := generateResponse{
+			Artifact:    result.Response.Artifact,
+			Explanation: result.Response.Message,
+			Edits:       result.Edits,
+			Citations:   result.Response.Citations,
+			Violations:  result.Response.Violations,
+			Guardrails:  result.Response.Guardrails,
+			Withheld:    result.Withheld,
+			Usage: generateUsage{
+				Model:        modelName(c),
+				InputTokens:  result.Tokens.Input,
+				OutputTokens: result.Tokens.Output,
+				Cost:         result.Cost,
+			},
+			SessionID: session.ID,
+			Version:   result.Version,
+		}
+		if res.Edits == nil {
+			res.Edits = []appliedEdit{}
+		}
+
+		body, err := json.Marshal(res)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		if idem != nil {
+			// The generation is done and paid for, so failing to remember it
+			// only risks a second one on retry.
+			idem.ResponseStatus, idem.Response = http.StatusOK, body
+			if err := database.FinishIdempotencyKey(idem); err != nil {
+				logger(c).Error("recording the idempotent response", "error", err)
+				idem.ResponseStatus = 0
+			}
+		}
+		return c.JSONBlob(http.StatusOK, body)
+	}
+}
+
+// claimIdempotencyKey returns the caller's record of the request's
+// Idempotency-Key, or nil if it has none, and whether this attempt holds it.
+// Reusing a key for a different body is an error.
+func claimIdempotencyKey(c echo.Context, database *db.Db, body []byte) (*models.IdempotencyKey, bool, error) {
+	key := c.Request().Header.Get(headerIdempotencyKey)
+	if key == "" {
+		return nil, false, nil
+	}
+	if len(key) > maxIdempotencyKey {
+		return nil, false, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be at most %d characters", headerIdempotencyKey, maxIdempotencyKey))
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	now := time.Now()
+	idem, claimed, err := database.ClaimIdempotencyKey(auth.CurrentUser(c).ID, key, hash, now, now.Add(idempotencyLock))
+	if err != nil {
+		return nil, false, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if idem.RequestHash != hash {
+		return nil, false, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("%s was already used for a different request", headerIdempotencyKey))
+	}
+	return idem, claimed, nil
+}
+
+// headlessSubjects counts /api/generate against the caller and the
+// workspace named in the request body, which is put back for the handler. A
+// body over the limit is left for the handler to fail on.
+func headlessSubjects(c echo.Context) []ratelimit.Subject {
+	workspaceID := keyWorkspace(c)
+	if workspaceID == "" {
+		raw, err := io.ReadAll(c.Request().Body)
+		if err == nil {
+			c.Request().Body = io.NopCloser(bytes.NewReader(raw))
+			var req generateRequest
+			if json.Unmarshal(raw, &req) == nil {
+				workspaceID = req.WorkspaceID
+			}
+		}
+	}
+	return limitSubjects(c, workspaceID)
+}
+
+var templateName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// loadTemplate reads the outline called name from dir: name.html or name.md,
+// preferring the one in format.
+func loadTemplate(dir, name string, format convert.Format) (string, error) {
+	if !templateName.MatchString(name) {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "template names may only contain letters, digits, - and _")
+	}
+
+	exts := []string{".md", ".html"}
+	if format == convert.HTML {
+		slices.Reverse(exts)
+	}
+	for _, ext := range exts {
+		b, err := os.ReadFile(filepath.Join(dir, name+ext))
+		if err == nil {
+			return string(b), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", echo.NewHTTPError(http.StatusBadRequest, "unknown template "+name)
+}
+
+// templatePrompt asks the model to follow an outline.
+func templatePrompt(template string) string {
+	return "Structure the artifact after the outline in the <template> tag, filling in every section.\n" +
+		"<template>\n" + template + "\n</template>"
+}
+
+// listTemplates returns the names of the templates /api/generate accepts.
+func listTemplates(templatesDir string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		entries, err := os.ReadDir(templatesDir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		names := []string{}
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			name := strings.TrimSuffix(entry.Name(), ext)
+			if entry.IsDir() || (ext != ".md" && ext != ".html") || !templateName.MatchString(name) {
+				continue
+			}
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		return c.JSON(http.StatusOK, names)
+	}
+}
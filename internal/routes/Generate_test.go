@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"composer/internal/ratelimit"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestGenerateBodyLimit(t *testing.T) {
+	database := newTestDb(t)
+	user := newTestUser(t, database, "user@example.com")
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("db", database)
+			c.Set("user", user)
+			return next(c)
+		}
+	})
+	RegisterGenerateRoutes(e, ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Config{}), t.TempDir())
+
+	body := `{"prompt": "` + strings.Repeat("a", 5<<20) + `"}`
+	for name, req := range map[string]*http.Request{
+		"with a length": httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body)),
+		// Without a Content-Length, the body is cut off as it is read.
+		"chunked": httptest.NewRequest(http.MethodPost, "/api/generate", io.MultiReader(strings.NewReader(body))),
+	} {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if rec := serve(e, req); rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: request of %d bytes = %d, want %d", name, len(body), rec.Code, http.StatusRequestEntityTooLarge)
+		}
+	}
+}
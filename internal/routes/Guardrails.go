@@ -0,0 +1,49 @@
+package routes
+
+import (
+	"strings"
+
+	"composer/internal/guardrails"
+	"composer/internal/models"
+)
+
+const auditGuardrailBlock = "guardrail.block"
+
+type guardrailsResponse struct {
+	Message    string                    `json:"message"`
+	Guardrails []models.GuardrailFinding `json:"guardrails"`
+}
+
+// guardrailsRequest collects what a createMessage request sends to the
+// model. userEdits is the diff of the user's changes to the artifact.
+func guardrailsRequest(rb requestBody, userEdits string, refs []reference) guardrails.Request {
+	req := guardrails.Request{
+		Message:      rb.Content,
+		SelectedText: rb.SelectedText,
+		UserEdits:    userEdits,
+	}
+	for _, ref := range refs {
+		req.References = append(req.References, guardrails.Reference{Source: "reference " + ref.Name, Text: ref.Text})
+	}
+	return req
+}
+
+// guardrailsPrompt passes the notes of annotated findings to the model.
+func guardrailsPrompt(notes []string) string {
+	return "<guardrails>\n" + strings.Join(notes, "\n") + "\n</guardrails>"
+}
+
+func findingsSummary(findings []models.GuardrailFinding) string {
+	var details []string
+	for _, f := range findings {
+		if f.Action != guardrails.Block {
+			continue
+		}
+		detail := f.Rule + ": " + f.Detail
+		if f.Source != "" {
+			detail += " (" + f.Source + ")"
+		}
+		details = append(details, detail)
+	}
+	return strings.Join(details, "; ")
+}
@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/convert"
+	"composer/internal/db"
+	"composer/internal/importer"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+const maxImportSize = 20 << 20
+
+func RegisterImportRoutes(e *echo.Echo, database *db.Db) {
+	e.POST("/api/chat-sessions/:id/import", importDocument(database))
+}
+
+// importDocument turns an uploaded document into the first version of a
+// session's artifact. It is recorded as a human turn, so the next
+// createMessage sends it to the model as the user's edits.
+func importDocument(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		sessionID := c.Param("id")
+
+		session, err := authorizeSession(c, database, sessionID, models.RoleEditor)
+		if err != nil {
+			return err
+		}
+
+		versions, err := database.ListArtifactVersions(sessionID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		if len(versions) > 0 {
+			return echo.NewHTTPError(http.StatusConflict, "documents can only be imported into a session without an artifact")
+		}
+
+		format := convert.HTML
+		if f := c.FormValue("format"); f != "" {
+			if format, err = convert.ParseFormat(f); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+		}
+
+		fh, err := c.FormFile("file")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "expected a multipart upload with a file field")
+		}
+		if fh.Size > maxImportSize {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("file exceeds %d MB", maxImportSize>>20))
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		defer f.Close()
+
+		data, err := io.ReadAll(io.LimitReader(f, maxImportSize))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+
+		artifact, err := importer.Import(fh.Filename, data, format)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+
+		msg := models.ChatMessage{
+			SessionID: sessionID,
+			Role:      "human",
+			Content:   fmt.Sprintf("Imported %s", fh.Filename),
+			Doc:       artifact,
+			Format:    string(format),
+			Diff:      artifact,
+			UserID:    auth.CurrentUser(c).ID,
+			CreatedAt: time.Now(),
+		}
+		if err := database.InsertChatMessage(&msg); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		recordAudit(c, database, session, models.AuditEvent{Action: auditArtifactImport, Version: 1, Detail: fh.Filename})
+
+		if session.Title == "" {
+			session.Title = strings.TrimSuffix(fh.Filename, filepath.Ext(fh.Filename))
+			if err := database.UpdateChatSession(session); err != nil {
+				return c.JSON(http.StatusInternalServerError, err)
+			}
+		}
+
+		return c.JSON(http.StatusCreated, msg)
+	}
+}
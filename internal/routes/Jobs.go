@@ -0,0 +1,289 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"composer/internal/auth"
+	"composer/internal/convert"
+	"composer/internal/db"
+	"composer/internal/export"
+	"composer/internal/jobs"
+	"composer/internal/models"
+	"composer/internal/webhooks"
+
+	"github.com/labstack/echo/v4"
+)
+
+const jobListLimit = 100
+
+// RegisterJobRoutes lets clients run generations, exports and imports in
+// the background and poll for the result, or be notified through a webhook.
+func RegisterJobRoutes(e *echo.Echo, database *db.Db, queue *jobs.Queue, dispatcher *webhooks.Dispatcher) {
+	e.POST("/api/jobs", submitJob(database, queue, dispatcher))
+	e.GET("/api/jobs", listJobs(database))
+	e.GET("/api/jobs/:id", getJob(database, queue))
+	e.GET("/api/jobs/:id/result", getJobResult(database, queue))
+	e.GET("/api/jobs/:id/deliveries", listJobDeliveries(database))
+}
+
+type jobRequest struct {
+	Kind  string          `json:"kind"`
+	Input json.RawMessage `json:"input"`
+	// WebhookURL is sent the job when it finishes, signed with the
+	// webhook_secret returned on submission.
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+type exportJobInput struct {
+	SessionID string `json:"session_id"`
+	Format    string `json:"format"`
+	Version   int    `json:"version,omitempty"`
+}
+
+type importJobInput struct {
+	SessionID string `json:"session_id"`
+	Format    string `json:"format,omitempty"`
+	Filename  string `json:"filename"`
+	Content   []byte `json:"content,omitempty"`
+}
+
+// jobResponse adds where to fetch the result of a succeeded job, and the
+// result itself when it is JSON.
+type jobResponse struct {
+	*models.Job
+	ResultURL string          `json:"result_url,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	// WebhookSecret is only returned when the job is submitted.
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+}
+
+// submitJob queues the API request a job stands for. Sessions are checked
+// now so that obvious mistakes fail fast; the job checks again when it runs.
+func submitJob(database *db.Db, queue *jobs.Queue, dispatcher *webhooks.Dispatcher) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		var req jobRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+
+		job := models.Job{Kind: req.Kind, UserID: auth.CurrentUser(c).ID, Input: req.Input}
+		if req.WebhookURL != "" {
+			if err := dispatcher.CheckURL(c.Request().Context(), req.WebhookURL); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "webhook_url: "+err.Error())
+			}
+			secret, _, err := auth.NewToken()
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, err)
+			}
+			job.WebhookURL, job.WebhookSecret = req.WebhookURL, secret
+		}
+		if key := auth.CurrentAPIKey(c); key != nil {
+			job.APIKeyID = key.ID
+		}
+
+		var body []byte
+		switch req.Kind {
+		case models.JobGenerate:
+			var input generateRequest
+			if err := json.Unmarshal(req.Input, &input); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "input: "+err.Error())
+			}
+			if strings.TrimSpace(input.Prompt) == "" {
+				return echo.NewHTTPError(http.StatusBadRequest, "input: prompt is required")
+			}
+			job.Request = models.JobRequest{Method: http.MethodPost, Path: "/api/generate", ContentType: echo.MIMEApplicationJSON}
+			body = req.Input
+
+		case models.JobExport:
+			var input exportJobInput
+			if err := json.Unmarshal(req.Input, &input); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "input: "+err.Error())
+			}
+			if _, err := export.ParseFormat(input.Format); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "input: "+err.Error())
+			}
+			if _, err := authorizeSession(c, database, input.SessionID, models.RoleViewer); err != nil {
+				return err
+			}
+			query := url.Values{"format": {input.Format}}
+			if input.Version > 0 {
+				query.Set("version", strconv.Itoa(input.Version))
+			}
+			job.Request = models.JobRequest{Method: http.MethodGet, Path: "/api/chat-sessions/" + url.PathEscape(input.SessionID) + "/export?" + query.Encode()}
+
+		case models.JobImport:
+			var input importJobInput
+			if err := json.Unmarshal(req.Input, &input); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "input: "+err.Error())
+			}
+			if input.Filename == "" || len(input.Content) == 0 {
+				return echo.NewHTTPError(http.StatusBadRequest, "input: filename and content are required")
+			}
+			if len(input.Content) > maxImportSize {
+				return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("file exceeds %d MB", maxImportSize>>20))
+			}
+			if input.Format != "" {
+				if _, err := convert.ParseFormat(input.Format); err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, "input: "+err.Error())
+				}
+			}
+			if _, err := authorizeSession(c, database, input.SessionID, models.RoleEditor); err != nil {
+				return err
+			}
+
+			var buf bytes.Buffer
+			mw := multipart.NewWriter(&buf)
+			if input.Format != "" {
+				mw.WriteField("format", input.Format)
+			}
+			fw, err := mw.CreateFormFile("file", input.Filename)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, err)
+			}
+			fw.Write(input.Content)
+			if err := mw.Close(); err != nil {
+				return c.JSON(http.StatusInternalServerError, err)
+			}
+			body = buf.Bytes()
+			job.Request = models.JobRequest{Method: http.MethodPost, Path: "/api/chat-sessions/" + url.PathEscape(input.SessionID) + "/import", ContentType: mw.FormDataContentType()}
+
+			// The file is kept with the request; the job only shows its name.
+			input.Content = nil
+			job.Input, _ = json.Marshal(input)
+
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "kind must be generate, export or import")
+		}
+
+		if err := queue.Submit(c.Request().Context(), &job, body); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		c.Response().Header().Set(echo.HeaderLocation, "/api/jobs/"+job.ID)
+		return c.JSON(http.StatusAccepted, jobResponse{Job: &job, WebhookSecret: job.WebhookSecret})
+	}
+}
+
+func listJobs(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		all, err := database.ListJobs(auth.CurrentUser(c).ID, jobListLimit)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		visible := []jobResponse{}
+		for _, j := range all {
+			if jobVisible(c, j) {
+				visible = append(visible, jobResponse{Job: j, ResultURL: resultURL(j)})
+			}
+		}
+		return c.JSON(http.StatusOK, visible)
+	}
+}
+
+func getJob(database *db.Db, queue *jobs.Queue) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		j, err := authorizeJob(c, database)
+		if err != nil {
+			return err
+		}
+
+		res := jobResponse{Job: j, ResultURL: resultURL(j)}
+		if j.Status == models.JobSucceeded && strings.HasPrefix(j.ResultType, echo.MIMEApplicationJSON) {
+			result, err := queue.Result(c.Request().Context(), j)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, err)
+			}
+			res.Result = result
+		}
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// getJobResult returns the response of a succeeded job as the API gave it,
+// such as an exported file.
+func getJobResult(database *db.Db, queue *jobs.Queue) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		j, err := authorizeJob(c, database)
+		if err != nil {
+			return err
+		}
+		if j.Status != models.JobSucceeded {
+			return echo.NewHTTPError(http.StatusConflict, "job is "+j.Status)
+		}
+
+		result, err := queue.Result(c.Request().Context(), j)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		return c.Blob(http.StatusOK, j.ResultType, result)
+	}
+}
+
+// listJobDeliveries is the delivery log of the job's webhook, empty until the
+// job finishes.
+func listJobDeliveries(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		j, err := authorizeJob(c, database)
+		if err != nil {
+			return err
+		}
+
+		webhook, err := database.GetJobWebhook(j.ID)
+		if errors.Is(err, db.ErrWebhookNotFound) {
+			return c.JSON(http.StatusOK, []*models.WebhookDelivery{})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		deliveries, err := database.ListWebhookDeliveries(webhook.ID, defaultDeliveryLimit)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, deliveries)
+	}
+}
+
+// authorizeJob loads the job in the :id param. Jobs are only visible to the
+// user who submitted them and, for jobs submitted with an API key, only
+// through that key.
+func authorizeJob(c echo.Context, database *db.Db) (*models.Job, error) {
+	j, err := database.GetJob(c.Param("id"))
+	if errors.Is(err, db.ErrJobNotFound) || (err == nil && !jobVisible(c, j)) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "job not found")
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return j, nil
+}
+
+func jobVisible(c echo.Context, j *models.Job) bool {
+	if j.UserID != auth.CurrentUser(c).ID {
+		return false
+	}
+	if key := auth.CurrentAPIKey(c); key != nil {
+		return j.APIKeyID == key.ID
+	}
+	return true
+}
+
+func resultURL(j *models.Job) string {
+	if j.Status != models.JobSucceeded {
+		return ""
+	}
+	return "/api/jobs/" + j.ID + "/result"
+}
@@ -0,0 +1,160 @@
+package routes
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"composer/internal/auth"
+	"composer/internal/db"
+	"composer/internal/knowledge"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RegisterKnowledgeRoutes exposes the workspace knowledge bases. Directories
+// can only be ingested from under root, so that the API can't be used to read
+// arbitrary files from the server. Only editors, by email, may add to the
+// shared default knowledge base, which grounds every personal session.
+func RegisterKnowledgeRoutes(e *echo.Echo, database *db.Db, kb *knowledge.Base, root string, editors []string) {
+	e.POST("/api/knowledge/ingest", ingestKnowledgeDir(database, kb, root, editors))
+	e.POST("/api/knowledge/documents", ingestKnowledgeDocument(database, kb, editors))
+	e.GET("/api/knowledge/search", searchKnowledge(database, kb))
+}
+
+type ingestRequest struct {
+	Path      string `json:"path"`
+	Workspace string `json:"workspace"`
+}
+
+type ingestResponse struct {
+	Chunks int `json:"chunks"`
+}
+
+func ingestKnowledgeDir(database *db.Db, kb *knowledge.Base, root string, editors []string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		req := ingestRequest{}
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+
+		workspace, err := authorizeKnowledge(c, database, req.Workspace, models.RoleEditor, editors)
+		if err != nil {
+			return err
+		}
+
+		dir := filepath.Join(root, filepath.FromSlash(req.Path))
+		if dir != filepath.Clean(root) && !strings.HasPrefix(dir, filepath.Clean(root)+string(filepath.Separator)) {
+			return echo.NewHTTPError(http.StatusBadRequest, "path must be inside the knowledge directory")
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("directory %q not found", req.Path))
+		}
+
+		n, err := kb.IngestDir(c.Request().Context(), workspace, root, dir)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+
+		return c.JSON(http.StatusOK, ingestResponse{Chunks: n})
+	}
+}
+
+func ingestKnowledgeDocument(database *db.Db, kb *knowledge.Base, editors []string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		workspace, err := authorizeKnowledge(c, database, c.FormValue("workspace"), models.RoleEditor, editors)
+		if err != nil {
+			return err
+		}
+
+		fh, err := c.FormFile("file")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "expected a multipart upload with a file field")
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		defer f.Close()
+
+		data, err := io.ReadAll(io.LimitReader(f, maxAttachmentSize))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+
+		n, err := kb.IngestFile(c.Request().Context(), workspace, fh.Filename, data)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+
+		return c.JSON(http.StatusOK, ingestResponse{Chunks: n})
+	}
+}
+
+func searchKnowledge(database *db.Db, kb *knowledge.Base) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		workspace, err := authorizeKnowledge(c, database, c.QueryParam("workspace"), models.RoleViewer, nil)
+		if err != nil {
+			return err
+		}
+
+		query := c.QueryParam("q")
+		if query == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "q is required")
+		}
+
+		k := knowledgePassages
+		if param := c.QueryParam("k"); param != "" {
+			n, err := strconv.Atoi(param)
+			if err != nil || n < 1 || n > 50 {
+				return echo.NewHTTPError(http.StatusBadRequest, "k must be between 1 and 50")
+			}
+			k = n
+		}
+
+		passages, err := kb.Search(c.Request().Context(), workspace, query, k)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, passages)
+	}
+}
+
+// authorizeKnowledge maps a workspace ID to its knowledge base. Every
+// signed-in user can read the shared default knowledge base, but only
+// editors can change it, as whatever is in it is shown to the model for
+// everyone. API keys bound to a workspace always use their workspace's.
+func authorizeKnowledge(c echo.Context, database *db.Db, workspaceID string, min models.Role, editors []string) (string, error) {
+	if ws := keyWorkspace(c); ws != "" && (workspaceID == "" || workspaceID == knowledge.DefaultWorkspace) {
+		workspaceID = ws
+	}
+	if workspaceID == "" || workspaceID == knowledge.DefaultWorkspace {
+		if min.AtLeast(models.RoleCommenter) && !slices.Contains(editors, strings.ToLower(auth.CurrentUser(c).Email)) {
+			return "", echo.NewHTTPError(http.StatusForbidden, "only the configured knowledge editors can add to the default knowledge base")
+		}
+		return knowledge.DefaultWorkspace, nil
+	}
+	if _, err := authorizeWorkspace(c, database, workspaceID, min); err != nil {
+		return "", err
+	}
+	return workspaceID, nil
+}
+
+// sessionKnowledge is the knowledge base searched for a session's turns.
+func sessionKnowledge(chatSession *models.ChatSession) string {
+	if chatSession.WorkspaceID == "" {
+		return knowledge.DefaultWorkspace
+	}
+	return chatSession.WorkspaceID
+}
@@ -1,12 +1,22 @@
 package routes
 
 import (
+	"composer/internal/auth"
+	"composer/internal/convert"
 	"composer/internal/db"
+	"composer/internal/guardrails"
+	"composer/internal/knowledge"
+	"composer/internal/logging"
+	"composer/internal/metrics"
 	"composer/internal/models"
+	"composer/internal/ratelimit"
+	"composer/internal/sanitize"
+	"composer/internal/tracing"
+	"composer/internal/usage"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"regexp"
 	"strings"
@@ -15,28 +25,36 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/sergi/go-diff/diffmatchpatch"
 	"github.com/tmc/langchaingo/llms"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func RegisterMessageRoutes(e *echo.Echo) {
+func RegisterMessageRoutes(e *echo.Echo, limiter *ratelimit.Limiter) {
 	e.GET("/api/chat-sessions/:id/messages", getMessages)
-	e.POST("/api/chat-sessions/:id/messages", createMessage)
+	e.POST("/api/chat-sessions/:id/messages", createMessage, limiter.Middleware(generationSubjects))
 }
 
 func getMessages(c echo.Context) error {
 	sessionID := c.Param("id")
 	database := c.Get("db").(*db.Db)
 
-	log.Printf("Getting messages for session: %s", sessionID)
+	if _, err := authorizeSession(c, database, sessionID, models.RoleViewer); err != nil {
+		return err
+	}
 
 	msgs, err := database.ListChatMessages(sessionID)
 	if err != nil {
 		return err
 	}
-	log.Printf("Messages are %+v", msgs)
+	logger(c).Debug("listed messages", "count", len(msgs))
 
 	return c.JSON(http.StatusOK, msgs)
 }
 
+// promptVersion identifies the system prompt in the audit log. Bump it
+// whenever systemPrompt changes.
+const promptVersion = "4"
+
 func systemPrompt(isDocumentEditor bool) string {
 
 	formatType := `The artifact should be valid rich HTML document fit for presentation.`
@@ -96,6 +114,14 @@ Prefer the edit method over creating the complete file.
 If the user makes a change to the artifact during the course of the conversation, you will recieve a diff of the user 
 change in the <user_edits> tag.
 
+The user may attach reference material, and passages from the team's knowledge base may be retrieved for the request.
+You will receive both in the <references> tag. When it is present, base the artifact on it and prefer it over your
+general knowledge.
+
+Cite every statement you take from a reference by placing a marker of the form [cite:ID] right after it, using the
+reference's id, e.g. [cite:K2]. Only cite ids that appear in the current <references> tag. Numbered citations already
+in the artifact, such as [1], must be kept where they are.
+
 %s
 
 IMPORTANT: Please do not respond with text outside of the artifact, explanation or edit tags.
@@ -107,23 +133,144 @@ var editRegex = regexp.MustCompile(`(?s)<edit>\s*<textToReplace>(.*?)</textToRep
 func createMessage(c echo.Context) error {
 	sessionID := c.Param("id")
 	database := c.Get("db").(*db.Db)
-	aiModel := c.Get("llm").(llms.Model)
+
+	session, err := authorizeSession(c, database, sessionID, models.RoleEditor)
+	if err != nil {
+		return err
+	}
 
 	rb := requestBody{}
-	err := c.Bind(&rb)
+	err = c.Bind(&rb)
 	if err != nil {
 		return err
 	}
 
+	// The stream starts with the first update, so that errors before it are
+	// still reported with a status code.
+	w := c.Response()
+	stream := func(out UserChatMessageResponse) error {
+		if !w.Committed {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+		}
+		err := json.NewEncoder(w).Encode(out)
+		if err != nil {
+			return err
+		}
+		w.Write([]byte("\r\n"))
+		w.Flush()
+		return nil
+	}
+
+	_, err = runTurn(c, database, turn{session: session, request: rb, persist: true, stream: stream})
+	var blocked *requestBlocked
+	if errors.As(err, &blocked) {
+		return c.JSON(http.StatusUnprocessableEntity, guardrailsResponse{
+			Message:    "The request was blocked by the content policy.",
+			Guardrails: blocked.findings,
+		})
+	}
+	return err
+}
+
+// turn is one round of the prompt pipeline: a user's request in a session
+// and the model's response to it.
+type turn struct {
+	session *models.ChatSession
+	request requestBody
+
+	// template is an outline for the artifact, see loadTemplate.
+	template string
+
+	// persist saves the request and the response in the session. Otherwise
+	// the session only provides the workspace, and the turn leaves no trace
+	// but the audit log and its usage.
+	persist bool
+
+	// stream, if set, receives the state of the response on every update,
+	// and the final response last.
+	stream func(UserChatMessageResponse) error
+}
+
+type turnResult struct {
+	Response UserChatMessageResponse
+	Edits    []appliedEdit
+
+	// Tokens are those charged for the turn, including the session title's.
+	Tokens usage.Tokens
+	Cost   float64
+
+	// Version is the artifact version the turn saved, if any.
+	Version int
+
+	// Withheld is set when guardrails withheld the response. Response then
+	// has the artifact as it was before the turn.
+	Withheld bool
+}
+
+// appliedEdit is an <edit> of the model's response. It applied if the text
+// it replaces was found in the artifact.
+type appliedEdit struct {
+	TextToReplace string `json:"text_to_replace"`
+	Replacement   string `json:"replacement"`
+	Applied       bool   `json:"applied"`
+}
+
+// requestBlocked is returned by runTurn when guardrails block a request.
+type requestBlocked struct {
+	findings []models.GuardrailFinding
+}
+
+func (e *requestBlocked) Error() string {
+	return "request blocked by guardrails: " + findingsSummary(e.findings)
+}
+
+// runTurn sends a request to the model with the session's history,
+// references and guardrails, and records the response.
+func runTurn(c echo.Context, database *db.Db, t turn) (*turnResult, error) {
+	session, rb := t.session, t.request
+	sessionID := session.ID
+	aiModel := c.Get("llm").(llms.Model)
+
 	diff := rb.Artifact
-	previousAIArtifact, err := getPreviousArtifactVersion(database, sessionID, "ai")
+	var refs []reference
+	if t.persist {
+		previousAIArtifact, err := getPreviousArtifactVersion(database, sessionID, "ai")
+		if err != nil {
+			return nil, err
+		}
+
+		if previousAIArtifact != "" {
+			dmp := diffmatchpatch.New()
+			diff = dmp.DiffPrettyText(dmp.DiffMain(previousAIArtifact, rb.Artifact, false))
+		}
+
+		attachments, err := database.ListAttachments(sessionID)
+		if err != nil {
+			return nil, err
+		}
+		refs = attachmentReferences(attachments)
+	}
+
+	var citations []models.Citation
+	kb := c.Get("knowledge").(*knowledge.Base)
+	passages, err := kb.Search(c.Request().Context(), sessionKnowledge(session), strings.TrimSpace(rb.Content+"\n"+rb.SelectedText), knowledgePassages)
 	if err != nil {
-		return err
+		logger(c).Error("searching the knowledge base", "error", err)
+	} else {
+		passageRefs, passageCitations := passageReferences(passages)
+		refs = append(refs, passageRefs...)
+		citations = passageCitations
 	}
 
-	if previousAIArtifact != "" {
-		dmp := diffmatchpatch.New()
-		diff = dmp.DiffPrettyText(dmp.DiffMain(previousAIArtifact, rb.Artifact, false))
+	// Requests are checked before anything is stored, so that a blocked
+	// request leaves no trace in the session other than the audit log.
+	guard := c.Get("guardrails").(*guardrails.Guard)
+	checked := guard.CheckRequest(guardrailsRequest(rb, diff, refs))
+	if checked.Blocked() {
+		recordAudit(c, database, session, models.AuditEvent{Action: auditGuardrailBlock, Detail: findingsSummary(checked.Findings)})
+		return nil, &requestBlocked{findings: checked.Findings}
 	}
 
 	msg := models.ChatMessage{
@@ -132,44 +279,64 @@ func createMessage(c echo.Context) error {
 		Content:      rb.Content,
 		CreatedAt:    time.Now(),
 		Doc:          rb.Artifact,
+		Format:       string(convert.FormatFor(rb.IsDocumentEditor)),
 		Diff:         diff,
 		SelectedText: rb.SelectedText,
+		UserID:       auth.CurrentUser(c).ID,
 	}
 
-	err = database.InsertChatMessage(&msg)
-	if err != nil {
-		return err
-	}
-	// Retrieve session from database
-	session, err := database.GetChatSession(sessionID)
-	if err != nil {
-		return err
+	humanEvent := models.AuditEvent{Action: auditMessageCreate}
+	if t.persist {
+		err = database.InsertChatMessage(&msg)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Doc != "" {
+			humanEvent.Version = latestVersion(c, database, sessionID)
+		}
 	}
+	recordAudit(c, database, session, humanEvent)
 
-	if session.Title == "" {
-		title, err := generateSessionTitle(c, rb.Content)
+	// The tokens spent on the title are charged to this turn's AI message.
+	var titleTokens usage.Tokens
+	if t.persist && session.Title == "" {
+		title, tokens, err := generateSessionTitle(c, database, session, rb.Content)
+		titleTokens = tokens
 		if err != nil {
-			log.Printf("Error: %s generating session title for session: %s", err, sessionID)
+			logger(c).Error("generating session title", "error", err)
 		}
 
 		session.Title = title
 		err = database.UpdateChatSession(session)
 		if err != nil {
-			log.Printf("Error updating session title for session: %s to title: %s", sessionID, title)
+			logger(c).Error("updating session title", "error", err)
 		}
 	}
 
 	// If title is empty, then generate one
 
-	history, err := database.ListChatMessages(sessionID)
-	if err != nil {
-		return err
+	history := []*models.ChatMessage{&msg}
+	if t.persist {
+		history, err = database.ListChatMessages(sessionID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	messageToModel := []llms.MessageContent{
 		llms.TextParts(llms.ChatMessageType("human"), systemPrompt(rb.IsDocumentEditor)),
 	}
 
+	if references := referencesPrompt(c, refs); references != "" {
+		messageToModel = append(messageToModel, llms.TextParts(llms.ChatMessageTypeHuman, references))
+	}
+	if t.template != "" {
+		messageToModel = append(messageToModel, llms.TextParts(llms.ChatMessageTypeHuman, templatePrompt(t.template)))
+	}
+	if len(checked.Notes) > 0 {
+		messageToModel = append(messageToModel, llms.TextParts(llms.ChatMessageTypeHuman, guardrailsPrompt(checked.Notes)))
+	}
+
 	for _, m := range history {
 		prompt := ""
 		if m.Role == "ai" {
@@ -181,29 +348,62 @@ func createMessage(c echo.Context) error {
 		messageToModel = append(messageToModel, llms.TextParts(llms.ChatMessageType(m.Role), prompt))
 	}
 
-	w := c.Response()
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
 	streamMessage := &UserChatMessageResponse{
-		Message:  "",
-		Artifact: "",
+		Message:    "",
+		Artifact:   "",
+		Citations:  citations,
+		Guardrails: checked.Findings,
 	}
 
 	inArtifact := false
 	inExplanation := false
 	collectedChunks := ""
 
-	previousArtifact, err := getPreviousArtifactVersion(database, sessionID, "")
-	if err != nil {
-		return err
+	// Personal data and secrets are replaced with placeholders before the
+	// request leaves, and put back in what the model returns. Edits are
+	// applied to the redacted artifact, since that is what the model saw.
+	redactor := sessionRedactor(c, database, session)
+	messageToModel = redactor.Messages(messageToModel)
+
+	previousArtifact := redactor.Redact(latestDoc(history))
+	if n := redactor.Count(); n > 0 {
+		logger(c).Info("redacted the request", "redactions", n)
 	}
 
-	result, err := aiModel.GenerateContent(c.Request().Context(), messageToModel, llms.WithMaxTokens(8192), llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
-		log.Printf("Chunk is %s", chunk)
-		log.Printf("inArtifact is %v", inArtifact)
-		log.Printf("inExplanation is %v", inExplanation)
+	// send streams the current state of the response. HTML artifacts are
+	// sanitized on every send so that nothing unsafe reaches the editor, even
+	// mid-stream.
+	send := func(partial bool) error {
+		if t.stream == nil {
+			return nil
+		}
+		out := *streamMessage
+		out.Message = redactor.Restore(out.Message)
+		out.Artifact = redactor.Restore(out.Artifact)
+		if rb.IsDocumentEditor && out.Artifact != "" {
+			var violations []sanitize.Violation
+			out.Artifact, violations = sanitize.Artifact(out.Artifact, partial)
+			out.Violations = append(out.Violations[:len(out.Violations):len(out.Violations)], violations...)
+		}
+		return t.stream(out)
+	}
+
+	if len(checked.Findings) > 0 {
+		if err := send(false); err != nil {
+			return nil, err
+		}
+	}
+
+	genCtx, span := tracing.StartGeneration(c.Request().Context(), "llm.generate", metrics.Provider(aiModel), modelName(c), 8192)
+	start := time.Now()
+	var firstToken time.Duration
+	var edits []appliedEdit
+	result, err := aiModel.GenerateContent(genCtx, messageToModel, llms.WithMaxTokens(8192), llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+		if firstToken == 0 {
+			firstToken = time.Since(start)
+			span.AddEvent("first_token")
+		}
+		logger(c).Debug("received chunk", "chunk", logging.Content(string(chunk)), "in_artifact", inArtifact, "in_explanation", inExplanation)
 		collectedChunks += string(chunk)
 
 		if strings.Contains(collectedChunks, "</edit>") {
@@ -213,14 +413,19 @@ func createMessage(c echo.Context) error {
 				replacement := m[2]
 
 				// Perform the replacement on the previous artifact
+				applied := strings.Contains(previousArtifact, textToReplace)
+				edits = append(edits, appliedEdit{
+					TextToReplace: redactor.Restore(textToReplace),
+					Replacement:   redactor.Restore(replacement),
+					Applied:       applied,
+				})
+				metrics.Edit(applied)
+				span.AddEvent("edit", trace.WithAttributes(attribute.Bool("applied", applied)))
 				previousArtifact = strings.ReplaceAll(previousArtifact, textToReplace, replacement)
 				streamMessage.Artifact = previousArtifact
-				err := json.NewEncoder(w).Encode(streamMessage)
-				if err != nil {
+				if err := send(inArtifact); err != nil {
 					return err
 				}
-				w.Write([]byte("\r\n"))
-				w.Flush()
 			}
 			allComponents := strings.Split(collectedChunks, "</edit>")
 			collectedChunks = allComponents[len(allComponents)-1]
@@ -238,13 +443,10 @@ func createMessage(c echo.Context) error {
 			inArtifact = false
 			frags := strings.Split(string(chunk), "</artifact>")
 			streamMessage.Artifact += frags[0]
-			log.Printf("Ending artifact with message %s", streamMessage.Artifact)
-			err := json.NewEncoder(w).Encode(streamMessage)
-			if err != nil {
+			logger(c).Debug("artifact ended", "artifact", logging.Content(streamMessage.Artifact))
+			if err := send(inArtifact); err != nil {
 				return err
 			}
-			w.Write([]byte("\r\n"))
-			w.Flush()
 			collectedChunks = ""
 			if len(frags) > 1 {
 				collectedChunks = frags[1]
@@ -255,12 +457,9 @@ func createMessage(c echo.Context) error {
 		if inArtifact {
 			streamMessage.Artifact += string(chunk)
 			streamMessage.Artifact = strings.Replace(streamMessage.Artifact, "</artifact", "", -1)
-			err := json.NewEncoder(w).Encode(streamMessage)
-			if err != nil {
+			if err := send(inArtifact); err != nil {
 				return err
 			}
-			w.Write([]byte("\r\n"))
-			w.Flush()
 		}
 
 		if strings.Contains(collectedChunks, "<explanation>") {
@@ -276,12 +475,9 @@ func createMessage(c echo.Context) error {
 			frags := strings.Split(string(chunk), "</explanation>")
 			streamMessage.Message += frags[0]
 			streamMessage.Message = strings.Replace(streamMessage.Message, "</explanation>", "", -1)
-			err := json.NewEncoder(w).Encode(streamMessage)
-			if err != nil {
+			if err := send(inArtifact); err != nil {
 				return err
 			}
-			w.Write([]byte("\r\n"))
-			w.Flush()
 			if len(frags) > 1 {
 				collectedChunks = frags[1]
 			}
@@ -291,33 +487,155 @@ func createMessage(c echo.Context) error {
 		if inExplanation {
 			streamMessage.Message += string(chunk)
 			// streamMessage.Message = strings.Replace(streamMessage.Message, "</explanation", "", -1)
-			err := json.NewEncoder(w).Encode(streamMessage)
-			if err != nil {
+			if err := send(inArtifact); err != nil {
 				return err
 			}
-			w.Write([]byte("\r\n"))
-			w.Flush()
 		}
 
 		return nil
 	}))
+	tokens := usage.FromResponse(result)
+	tracing.EndGeneration(span, tokens, err)
 	if err != nil {
-		return err
+		metrics.LLMError(aiModel)
+		return nil, err
+	}
+	metrics.Generation(modelName(c), time.Since(start), firstToken)
+	metrics.Tokens(modelName(c), tokens)
+
+	resolver := newCitationResolver(refs, latestCitations(history))
+	streamMessage.Artifact = resolver.resolve(redactor.Restore(streamMessage.Artifact), rb.IsDocumentEditor)
+	streamMessage.Message = resolver.resolve(redactor.Restore(streamMessage.Message), false)
+	streamMessage.Violations = resolver.Violations
+	if rb.IsDocumentEditor && streamMessage.Artifact != "" {
+		var violations []sanitize.Violation
+		streamMessage.Artifact, violations = sanitize.Artifact(streamMessage.Artifact, false)
+		streamMessage.Violations = append(streamMessage.Violations, violations...)
+	}
+	if len(streamMessage.Violations) > 0 {
+		logger(c).Info("cleaned up artifact", "violations", len(streamMessage.Violations))
+	}
+	streamMessage.Citations = resolver.citations(streamMessage.Artifact)
+
+	artifact, output := guard.CheckOutput(streamMessage.Artifact, rb.IsDocumentEditor)
+	streamMessage.Guardrails = append(checked.Findings, output.Findings...)
+	doc := artifact
+	if output.Blocked() {
+		// The stream already showed parts of the response, so the final
+		// message puts the previous artifact back in the editor.
+		doc = ""
+		streamMessage.Artifact = latestDoc(history)
+		streamMessage.Citations = latestCitations(history)
+		streamMessage.Message = "The response was withheld by the content policy: " + findingsSummary(output.Findings) + "."
+	} else {
+		streamMessage.Artifact = artifact
+	}
+	if t.stream != nil {
+		if err := t.stream(*streamMessage); err != nil {
+			return nil, err
+		}
 	}
 
-	err = database.InsertChatMessage(&models.ChatMessage{
-		SessionID: sessionID,
-		Role:      "ai",
-		Content:   streamMessage.Message,
-		Doc:       streamMessage.Artifact,
-		CreatedAt: time.Now(),
-	})
-	if err != nil {
-		return err
+	charged := tokens.Add(titleTokens)
+	c.Set("usage", charged)
+	prices, _ := c.Get("prices").(usage.Prices)
+	res := &turnResult{
+		Response: *streamMessage,
+		Edits:    edits,
+		Tokens:   charged,
+		Cost:     prices.Cost(modelName(c), charged),
+		Withheld: output.Blocked(),
+	}
+
+	now := time.Now()
+	if t.persist {
+		err = database.InsertChatMessage(&models.ChatMessage{
+			SessionID:    sessionID,
+			Role:         "ai",
+			Content:      streamMessage.Message,
+			Doc:          doc,
+			Format:       string(convert.FormatFor(rb.IsDocumentEditor)),
+			Citations:    streamMessage.Citations,
+			Guardrails:   streamMessage.Guardrails,
+			UserID:       auth.CurrentUser(c).ID,
+			Model:        modelName(c),
+			InputTokens:  charged.Input,
+			OutputTokens: charged.Output,
+			Cost:         res.Cost,
+			CreatedAt:    now,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Saved or not, the turn counts towards the usage of its user, key and
+	// workspace. The model has been paid by now, so a failure to record it
+	// is logged rather than failing the turn.
+	record := &models.UsageRecord{
+		UserID:       auth.CurrentUser(c).ID,
+		SessionID:    sessionID,
+		WorkspaceID:  session.WorkspaceID,
+		Model:        modelName(c),
+		InputTokens:  charged.Input,
+		OutputTokens: charged.Output,
+		Cost:         res.Cost,
+		CreatedAt:    now,
+	}
+	if key := auth.CurrentAPIKey(c); key != nil {
+		record.APIKeyID = key.ID
+	}
+	if err := database.InsertUsage(record); err != nil {
+		logger(c).Error("recording usage", "error", err)
+	}
+
+	aiEvent := models.AuditEvent{Action: auditAIGenerate, Model: modelName(c), PromptVersion: promptVersion}
+	if n := redactor.Count(); n > 0 {
+		aiEvent.Detail = fmt.Sprintf("redactions: %d", n)
+	}
+	if output.Blocked() {
+		aiEvent.Detail = strings.TrimPrefix(aiEvent.Detail+"; withheld by guardrails", "; ")
+	}
+	aiEvent.InputTokens, aiEvent.OutputTokens = tokens.Input, tokens.Output
+	if t.persist && doc != "" {
+		res.Version = latestVersion(c, database, sessionID)
+		aiEvent.Version = res.Version
 	}
+	recordAudit(c, database, session, aiEvent)
+	emitEvent(c, session, models.EventGenerationCompleted, generationEvent{
+		Model:        modelName(c),
+		InputTokens:  charged.Input,
+		OutputTokens: charged.Output,
+		Cost:         res.Cost,
+		Version:      res.Version,
+		Withheld:     res.Withheld,
+	})
+
+	logger(c).Info("generated response", "model", modelName(c), "input_tokens", tokens.Input, "output_tokens", tokens.Output,
+		"duration_ms", time.Since(start).Milliseconds(), "edits", len(edits), "blocked", output.Blocked())
+	logger(c).Debug("model response", "content", logging.Content(result.Choices[0].Content))
+	return res, nil
+}
 
-	log.Printf("response from LLM is %+s", result.Choices[0].Content)
-	return nil
+// generationEvent is the data of a generation.completed event. Version is
+// the artifact version the generation created, or 0 if it wasn't saved.
+type generationEvent struct {
+	Model        string  `json:"model"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Cost         float64 `json:"cost"`
+	Version      int     `json:"version,omitempty"`
+	Withheld     bool    `json:"withheld,omitempty"`
+}
+
+// latestDoc is the artifact as of the last message of history that has one.
+func latestDoc(history []*models.ChatMessage) string {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Doc != "" {
+			return history[i].Doc
+		}
+	}
+	return ""
 }
 
 func getPreviousArtifactVersion(database *db.Db, sessionID, perspective string) (string, error) {
@@ -337,20 +655,36 @@ func getPreviousArtifactVersion(database *db.Db, sessionID, perspective string)
 	return "", nil
 }
 
-func generateSessionTitle(c echo.Context, userRequest string) (string, error) {
+func generateSessionTitle(c echo.Context, database *db.Db, session *models.ChatSession, userRequest string) (string, usage.Tokens, error) {
 	prompt := fmt.Sprintf(`Could you please generate a short title (a short sentence or phrase) for a user chat session where the user 
 						has requested the following\n
 						USER REQUEST: %s\nPlease respond with just one Title and do not provide an explanation or options`, userRequest)
 	aiModel := c.Get("llm").(llms.Model)
-	messageToModel := []llms.MessageContent{
+	redactor := sessionRedactor(c, database, session)
+	messageToModel := redactor.Messages([]llms.MessageContent{
 		llms.TextParts("human", prompt),
-	}
-	result, err := aiModel.GenerateContent(c.Request().Context(), messageToModel, llms.WithMaxTokens(512))
+	})
+	ctx, span := tracing.StartGeneration(c.Request().Context(), "llm.title", metrics.Provider(aiModel), modelName(c), 512)
+	result, err := aiModel.GenerateContent(ctx, messageToModel, llms.WithMaxTokens(512))
+	tokens := usage.FromResponse(result)
+	tracing.EndGeneration(span, tokens, err)
 	if err != nil {
-		return "", err
+		metrics.LLMError(aiModel)
+		return "", usage.Tokens{}, err
 	}
 
-	return result.Choices[0].Content, nil
+	metrics.Tokens(modelName(c), tokens)
+	event := models.AuditEvent{Action: auditAITitle, Model: modelName(c), InputTokens: tokens.Input, OutputTokens: tokens.Output}
+	recordAudit(c, database, session, event)
+
+	return redactor.Restore(result.Choices[0].Content), tokens, nil
+}
+
+// modelName is the name of the model set up in main, for the audit log and
+// cost accounting.
+func modelName(c echo.Context) string {
+	name, _ := c.Get("model").(string)
+	return name
 }
 
 type requestBody struct {
@@ -361,6 +695,13 @@ type requestBody struct {
 }
 
 type UserChatMessageResponse struct {
-	Message  string `json:"message"`
-	Artifact string `json:"artifact,omitempty"`
+	Message    string               `json:"message"`
+	Artifact   string               `json:"artifact,omitempty"`
+	Violations []sanitize.Violation `json:"violations,omitempty"`
+	// Citations lists the retrieved passages while the response streams and
+	// the artifact's resolved footnotes in the final message.
+	Citations []models.Citation `json:"citations,omitempty"`
+	// Guardrails lists findings on the request as soon as the stream starts,
+	// and those on the response as well in the final message.
+	Guardrails []models.GuardrailFinding `json:"guardrails,omitempty"`
 }
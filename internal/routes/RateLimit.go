@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"composer/internal/auth"
+	"composer/internal/db"
+	"composer/internal/ratelimit"
+
+	"github.com/labstack/echo/v4"
+)
+
+// generationSubjects counts a generation against the signed-in user, the API
+// key it was made with, and the workspace of the session in the :id param.
+func generationSubjects(c echo.Context) []ratelimit.Subject {
+	var workspaceID string
+	if id := c.Param("id"); id != "" {
+		database := c.Get("db").(*db.Db)
+		if session, err := database.GetChatSession(id); err == nil {
+			workspaceID = session.WorkspaceID
+		}
+	}
+	return limitSubjects(c, workspaceID)
+}
+
+// limitSubjects are the signed-in user, the API key and, if not empty, the
+// workspace.
+func limitSubjects(c echo.Context, workspaceID string) []ratelimit.Subject {
+	var subjects []ratelimit.Subject
+	if user := auth.CurrentUser(c); user != nil {
+		subjects = append(subjects, ratelimit.Subject{Kind: ratelimit.User, ID: user.ID})
+	}
+	if key := auth.CurrentAPIKey(c); key != nil {
+		subjects = append(subjects, ratelimit.Subject{Kind: ratelimit.APIKey, ID: key.ID})
+	}
+	if workspaceID != "" {
+		subjects = append(subjects, ratelimit.Subject{Kind: ratelimit.Workspace, ID: workspaceID})
+	}
+	return subjects
+}
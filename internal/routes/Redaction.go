@@ -0,0 +1,128 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"composer/internal/db"
+	"composer/internal/models"
+	"composer/internal/redact"
+
+	"github.com/labstack/echo/v4"
+)
+
+const maxRedactionPatterns = 50
+
+func RegisterRedactionRoutes(e *echo.Echo, database *db.Db) {
+	e.GET("/api/workspaces/:workspaceId/redaction", getRedactionPolicy(database))
+	e.PUT("/api/workspaces/:workspaceId/redaction", setRedactionPolicy(database))
+}
+
+func getRedactionPolicy(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		ws, err := authorizeWorkspace(c, database, c.Param("workspaceId"), models.RoleViewer)
+		if err != nil {
+			return err
+		}
+
+		policy, err := redactionPolicy(database, ws.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, policy)
+	}
+}
+
+func setRedactionPolicy(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		ws, err := authorizeWorkspace(c, database, c.Param("workspaceId"), models.RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		var req models.RedactionPolicy
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		if len(req.Patterns) > maxRedactionPatterns {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("a policy can have at most %d patterns", maxRedactionPatterns))
+		}
+
+		policy := models.RedactionPolicy{
+			WorkspaceID: ws.ID,
+			Enabled:     req.Enabled,
+			Detectors:   []string{},
+			Patterns:    []models.RedactionPattern{},
+			UpdatedAt:   time.Now(),
+		}
+		for _, name := range req.Detectors {
+			if _, ok := redact.Lookup(name); !ok {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown detector %q, expected one of %s", name, strings.Join(redact.Builtin, ", ")))
+			}
+			policy.Detectors = append(policy.Detectors, name)
+		}
+		for _, p := range req.Patterns {
+			if _, err := redact.Pattern(p.Name, p.Pattern); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			policy.Patterns = append(policy.Patterns, p)
+		}
+
+		if err := database.SetRedactionPolicy(&policy); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, policy)
+	}
+}
+
+// redactionPolicy returns a workspace's policy. Workspaces without one, and
+// sessions outside any workspace, redact with every built-in detector.
+func redactionPolicy(database *db.Db, workspaceID string) (*models.RedactionPolicy, error) {
+	if workspaceID != "" {
+		policy, err := database.GetRedactionPolicy(workspaceID)
+		if err != nil || policy != nil {
+			return policy, err
+		}
+	}
+
+	return &models.RedactionPolicy{
+		WorkspaceID: workspaceID,
+		Enabled:     true,
+		Detectors:   redact.Builtin,
+		Patterns:    []models.RedactionPattern{},
+	}, nil
+}
+
+// sessionRedactor builds the redactor for a request in session. If the
+// policy can't be loaded, the defaults apply rather than no redaction.
+func sessionRedactor(c echo.Context, database *db.Db, session *models.ChatSession) *redact.Redactor {
+	policy, err := redactionPolicy(database, session.WorkspaceID)
+	if err != nil {
+		logger(c).Error("loading redaction policy, using the defaults", "session_id", session.ID, "error", err)
+		policy, _ = redactionPolicy(database, "")
+	}
+	if !policy.Enabled {
+		return redact.New()
+	}
+
+	var detectors []redact.Detector
+	for _, name := range policy.Detectors {
+		d, _ := redact.Lookup(name)
+		detectors = append(detectors, d...)
+	}
+	for _, p := range policy.Patterns {
+		d, err := redact.Pattern(p.Name, p.Pattern)
+		if err != nil {
+			logger(c).Error("invalid redaction policy", "workspace_id", policy.WorkspaceID, "error", err)
+			continue
+		}
+		detectors = append(detectors, d)
+	}
+	return redact.New(detectors...)
+}
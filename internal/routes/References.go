@@ -0,0 +1,96 @@
+package routes
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"composer/internal/knowledge"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// referencesTokenBudget caps how much of the prompt reference material may
+// take up. Tokens are estimated at four characters each.
+const referencesTokenBudget = 32000
+
+// knowledgePassages is how many knowledge base passages are retrieved for
+// each turn.
+const knowledgePassages = 4
+
+// reference is a piece of grounding material given to the model. IDs are R<n>
+// for session attachments and K<n> for knowledge base passages.
+type reference struct {
+	ID   string
+	Name string
+	Text string
+}
+
+func attachmentReferences(attachments []*models.Attachment) []reference {
+	refs := make([]reference, len(attachments))
+	for i, a := range attachments {
+		refs[i] = reference{ID: fmt.Sprintf("R%d", i+1), Name: a.Filename, Text: a.Text}
+	}
+	return refs
+}
+
+func passageReferences(passages []knowledge.Passage) ([]reference, []models.Citation) {
+	refs := make([]reference, len(passages))
+	citations := make([]models.Citation, len(passages))
+	for i, p := range passages {
+		id := fmt.Sprintf("K%d", i+1)
+		refs[i] = reference{ID: id, Name: fmt.Sprintf("%s#%d", p.Source, p.Chunk), Text: p.Content}
+		citations[i] = models.Citation{ID: id, Source: refs[i].Name, Snippet: snippet(p.Content), Score: p.Score}
+	}
+	return refs, citations
+}
+
+// referencesPrompt renders grounding material for the model. References are
+// included in order until the token budget runs out; the one that crosses the
+// budget is truncated and the rest are left out.
+func referencesPrompt(c echo.Context, refs []reference) string {
+	if len(refs) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("<references>\n")
+	remaining := referencesTokenBudget * 4
+	for i, r := range refs {
+		if remaining <= 0 {
+			logger(c).Info("reference budget exhausted", "left_out", len(refs)-i)
+			break
+		}
+
+		text := r.Text
+		if len(text) > remaining {
+			text = truncate(text, remaining) + "\n[truncated]"
+		}
+		remaining -= len(text)
+
+		fmt.Fprintf(&b, "<reference id=%q name=%q>\n%s\n</reference>\n", r.ID, r.Name, text)
+	}
+	b.WriteString("</references>")
+
+	return b.String()
+}
+
+func snippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= 240 {
+		return text
+	}
+	return truncate(text, 240) + "…"
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
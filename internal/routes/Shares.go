@@ -0,0 +1,331 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/convert"
+	"composer/internal/db"
+	"composer/internal/export"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultShareTTL = 7 * 24 * time.Hour
+	maxShareTTL     = 90 * 24 * time.Hour
+)
+
+// RegisterShareRoutes adds management of share links for signed-in users, and
+// the public routes the links open. Public routes answer 404 for unknown,
+// expired and revoked links alike.
+func RegisterShareRoutes(e *echo.Echo, database *db.Db, brand export.Branding) {
+	e.POST("/api/chat-sessions/:id/shares", createShare(database))
+	e.GET("/api/chat-sessions/:id/shares", listShares(database))
+	e.DELETE("/api/chat-sessions/:id/shares/:shareId", revokeShare(database))
+	e.GET("/api/chat-sessions/:id/shares/:shareId/accesses", listShareAccesses(database))
+
+	e.GET("/s/:token", viewShare(database, brand))
+	e.GET("/api/shared/:token", getShared(database))
+	e.GET("/api/shared/:token/comments", listSharedComments(database))
+	e.POST("/api/shared/:token/comments", createSharedComment(database))
+}
+
+type shareRequest struct {
+	Access         models.Role `json:"access"`
+	ExpiresInHours int         `json:"expires_in_hours"`
+}
+
+type shareResponse struct {
+	*models.Share
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+type sharedSession struct {
+	Title    string             `json:"title"`
+	Access   models.Role        `json:"access"`
+	Versions []*models.Document `json:"versions"`
+}
+
+type sharedComment struct {
+	Name         string `json:"name"`
+	Body         string `json:"body"`
+	SelectedText string `json:"selectedText"`
+}
+
+// publicComment is a comment as link holders see it, without the IDs of the
+// users who left it.
+type publicComment struct {
+	ID           string    `json:"id"`
+	AuthorName   string    `json:"author_name,omitempty"`
+	Body         string    `json:"body"`
+	SelectedText string    `json:"selectedText"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// createShare returns the link's token once; only its hash is kept.
+func createShare(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		sessionID := c.Param("id")
+		if _, err := authorizeSession(c, database, sessionID, models.RoleEditor); err != nil {
+			return err
+		}
+
+		req := shareRequest{Access: models.RoleViewer}
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		if req.Access != models.RoleViewer && req.Access != models.RoleCommenter {
+			return echo.NewHTTPError(http.StatusBadRequest, "access must be viewer or commenter")
+		}
+		ttl := defaultShareTTL
+		if req.ExpiresInHours != 0 {
+			ttl = time.Duration(req.ExpiresInHours) * time.Hour
+		}
+		if ttl <= 0 || ttl > maxShareTTL {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("expires_in_hours must be between 1 and %d", int(maxShareTTL.Hours())))
+		}
+
+		token, hash, err := auth.NewToken()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		now := time.Now()
+		share := models.Share{
+			SessionID: sessionID,
+			TokenHash: hash,
+			Access:    req.Access,
+			CreatedBy: auth.CurrentUser(c).ID,
+			ExpiresAt: now.Add(ttl),
+			CreatedAt: now,
+		}
+		if err := database.InsertShare(&share); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusCreated, shareResponse{Share: &share, Token: token, URL: "/s/" + token})
+	}
+}
+
+func listShares(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		sessionID := c.Param("id")
+		if _, err := authorizeSession(c, database, sessionID, models.RoleEditor); err != nil {
+			return err
+		}
+
+		shares, err := database.ListShares(sessionID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, shares)
+	}
+}
+
+func revokeShare(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		sessionID := c.Param("id")
+		if _, err := authorizeSession(c, database, sessionID, models.RoleEditor); err != nil {
+			return err
+		}
+
+		share, err := database.GetShare(sessionID, c.Param("shareId"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		if err := database.RevokeShare(share, time.Now()); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, share)
+	}
+}
+
+func listShareAccesses(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		sessionID := c.Param("id")
+		if _, err := authorizeSession(c, database, sessionID, models.RoleEditor); err != nil {
+			return err
+		}
+
+		share, err := database.GetShare(sessionID, c.Param("shareId"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+
+		accesses, err := database.ListShareAccesses(share.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, accesses)
+	}
+}
+
+// viewShare renders the current artifact as a standalone page.
+func viewShare(database *db.Db, brand export.Branding) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		share, session, err := openShare(c, database, models.RoleViewer, "view")
+		if err != nil {
+			return err
+		}
+
+		versions, err := database.ListArtifactVersions(share.SessionID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		if len(versions) == 0 {
+			return echo.NewHTTPError(http.StatusNotFound, "session has no artifact")
+		}
+		version := versions[len(versions)-1]
+
+		title := session.Title
+		if title == "" {
+			title = "Untitled document"
+		}
+		meta := export.Metadata{Title: title, Version: version.Version, Date: version.CreatedAt}
+		for _, cite := range versionCitations(versions, version) {
+			meta.Footnotes = append(meta.Footnotes, export.Footnote{Number: cite.Number, Source: cite.Source, Snippet: cite.Snippet})
+		}
+
+		page, err := export.Export(version.Contents, convert.Stored(version.Format, version.Contents), export.HTML, meta, brand)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		// The artifact is already sanitized; the policy is a second line of
+		// defence for a page served on the application's origin.
+		h := c.Response().Header()
+		h.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src data: https:")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("X-Robots-Tag", "noindex")
+		return c.HTMLBlob(http.StatusOK, page)
+	}
+}
+
+// getShared returns the session's version history, oldest first.
+func getShared(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		share, session, err := openShare(c, database, models.RoleViewer, "versions")
+		if err != nil {
+			return err
+		}
+
+		versions, err := database.ListArtifactVersions(share.SessionID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, sharedSession{Title: session.Title, Access: share.Access, Versions: versions})
+	}
+}
+
+// listSharedComments is for comment links only: a view link shows the
+// document, not the discussion around it.
+func listSharedComments(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		share, _, err := openShare(c, database, models.RoleCommenter, "comments")
+		if err != nil {
+			return err
+		}
+
+		comments, err := database.ListComments(share.SessionID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		public := make([]publicComment, 0, len(comments))
+		for _, cm := range comments {
+			public = append(public, publicComment{
+				ID:           cm.ID,
+				AuthorName:   cm.AuthorName,
+				Body:         cm.Body,
+				SelectedText: cm.SelectedText,
+				CreatedAt:    cm.CreatedAt,
+			})
+		}
+
+		return c.JSON(http.StatusOK, public)
+	}
+}
+
+func createSharedComment(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		share, session, err := openShare(c, database, models.RoleCommenter, "comment")
+		if err != nil {
+			return err
+		}
+
+		var req sharedComment
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		if strings.TrimSpace(req.Body) == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "body is required")
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			name = "Guest"
+		}
+
+		comment := models.Comment{
+			SessionID:    share.SessionID,
+			AuthorName:   name,
+			Body:         req.Body,
+			SelectedText: req.SelectedText,
+			CreatedAt:    time.Now(),
+		}
+		if err := database.InsertComment(&comment); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		emitEvent(c, session, models.EventCommentAdded, comment)
+
+		return c.JSON(http.StatusCreated, comment)
+	}
+}
+
+// openShare resolves the link in the request and the session it shares,
+// checks it grants at least min, and records the access. A link to a
+// session that has since been deleted is treated as unknown.
+func openShare(c echo.Context, database *db.Db, min models.Role, action string) (*models.Share, *models.ChatSession, error) {
+	share, err := database.GetShareByToken(auth.HashToken(c.Param("token")))
+	if err != nil || !share.Active(time.Now()) {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, "link not found or expired")
+	}
+	session, err := database.GetChatSession(share.SessionID)
+	if err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, "link not found or expired")
+	}
+	if !share.Access.AtLeast(min) {
+		return nil, nil, echo.NewHTTPError(http.StatusForbidden, "this link does not allow commenting")
+	}
+
+	err = database.InsertShareAccess(&models.ShareAccess{
+		ShareID:   share.ID,
+		SessionID: share.SessionID,
+		Action:    action,
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		logger(c).Error("recording share access", "share_id", share.ID, "error", err)
+	}
+
+	return share, session, nil
+}
@@ -0,0 +1,86 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/db"
+	"composer/internal/export"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newTestShare(t *testing.T, database *db.Db, session *models.ChatSession, access models.Role) string {
+	t.Helper()
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	share := &models.Share{
+		SessionID: session.ID,
+		TokenHash: hash,
+		Access:    access,
+		CreatedBy: session.UserID,
+		ExpiresAt: time.Now().Add(time.Hour),
+		CreatedAt: time.Now(),
+	}
+	if err := database.InsertShare(share); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestSharedComments(t *testing.T) {
+	database := newTestDb(t)
+	owner := newTestUser(t, database, "owner@example.com")
+	session := &models.ChatSession{Title: "Shared", UserID: owner.ID}
+	if err := database.InsertChatSession(session); err != nil {
+		t.Fatal(err)
+	}
+	comment := &models.Comment{SessionID: session.ID, UserID: owner.ID, Body: "Internal note", CreatedAt: time.Now()}
+	if err := database.InsertComment(comment); err != nil {
+		t.Fatal(err)
+	}
+	viewer := newTestShare(t, database, session, models.RoleViewer)
+	commenter := newTestShare(t, database, session, models.RoleCommenter)
+
+	e := echo.New()
+	RegisterShareRoutes(e, database, export.Branding{})
+	request := func(method, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/shared/"+token+"/comments", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		return serve(e, req)
+	}
+
+	if rec := request(http.MethodGet, viewer, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("comments through a view link = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec := request(http.MethodGet, commenter, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Internal note") {
+		t.Errorf("comments through a comment link = %d %s, want %d with the comment", rec.Code, rec.Body, http.StatusOK)
+	}
+	if strings.Contains(rec.Body.String(), "user_id") {
+		t.Errorf("comments through a comment link include user IDs: %s", rec.Body)
+	}
+	if rec := request(http.MethodPost, viewer, `{"body": "Hi"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("commenting through a view link = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := request(http.MethodPost, commenter, `{"body": "Hi"}`); rec.Code != http.StatusCreated {
+		t.Errorf("commenting through a comment link = %d, want %d", rec.Code, http.StatusCreated)
+	}
+
+	// Once the session is gone, so are its links.
+	if _, err := database.DeleteChatSession(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		if rec := request(method, commenter, `{"body": "Hi"}`); rec.Code != http.StatusNotFound {
+			t.Errorf("%s after the session was deleted = %d, want %d", method, rec.Code, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Vite names what it builds under assets/ after the content, so those files
+// can be cached for good. Everything else, index.html in particular, is
+// revalidated against its ETag on every load.
+const (
+	immutableCache  = "public, max-age=31536000, immutable"
+	revalidateCache = "no-cache"
+)
+
+// RegisterUIRoutes serves the web UI from assets. Paths that aren't a file
+// get index.html, so that the UI's own routes survive a reload, except under
+// /api/ and for paths that look like a file, which are not found.
+func RegisterUIRoutes(e *echo.Echo, assets fs.FS) {
+	h := serveUI(assets)
+	e.GET("/*", h)
+	e.HEAD("/*", h)
+}
+
+func serveUI(assets fs.FS) echo.HandlerFunc {
+	etags := &sync.Map{}
+	return func(c echo.Context) error {
+		name := strings.TrimPrefix(path.Clean("/"+c.Param("*")), "/")
+		if name == "api" || strings.HasPrefix(name, "api/") {
+			return echo.ErrNotFound
+		}
+		if name == "" {
+			name = "index.html"
+		}
+
+		f, info, err := openAsset(assets, name)
+		if errors.Is(err, fs.ErrNotExist) && path.Ext(name) == "" {
+			name = "index.html"
+			f, info, err = openAsset(assets, name)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			if name == "index.html" {
+				return echo.NewHTTPError(http.StatusNotFound, "this build of composer has no UI; build it in ui/ first")
+			}
+			return echo.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		content, ok := f.(io.ReadSeeker)
+		if !ok {
+			b, err := io.ReadAll(f)
+			if err != nil {
+				return err
+			}
+			content = bytes.NewReader(b)
+		}
+		etag, err := assetETag(etags, name, info, content)
+		if err != nil {
+			return err
+		}
+
+		header := c.Response().Header()
+		header.Set("ETag", etag)
+		if strings.HasPrefix(name, "assets/") {
+			header.Set(echo.HeaderCacheControl, immutableCache)
+		} else {
+			header.Set(echo.HeaderCacheControl, revalidateCache)
+		}
+		// ServeContent answers If-None-Match, ranges and HEAD.
+		http.ServeContent(c.Response(), c.Request(), name, info.ModTime(), content)
+		return nil
+	}
+}
+
+// openAsset opens the file name in assets. Directories don't count.
+func openAsset(assets fs.FS, name string) (fs.File, fs.FileInfo, error) {
+	f, err := assets.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, nil, fs.ErrNotExist
+	}
+	return f, info, nil
+}
+
+// assetETag hashes a file's content. Hashes are remembered by size and
+// modification time, which never change for the embedded UI and do whenever
+// a file in an overriding directory is rebuilt.
+func assetETag(etags *sync.Map, name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	key := fmt.Sprintf("%s:%d:%d", name, info.Size(), info.ModTime().UnixNano())
+	if etag, ok := etags.Load(key); ok {
+		return etag.(string), nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16]) + `"`
+	etags.Store(key, etag)
+	return etag, nil
+}
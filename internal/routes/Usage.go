@@ -0,0 +1,72 @@
+package routes
+
+import (
+	"net/http"
+	"time"
+
+	"composer/internal/db"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func RegisterUsageRoutes(e *echo.Echo, database *db.Db) {
+	e.GET("/api/usage", getUsage(database))
+}
+
+type usageResponse struct {
+	GroupBy string               `json:"group_by"`
+	From    *time.Time           `json:"from,omitempty"`
+	To      *time.Time           `json:"to,omitempty"`
+	Groups  []*models.UsageTotal `json:"groups"`
+	Total   models.UsageTotal    `json:"total"`
+}
+
+// getUsage totals model usage and its estimated cost, over the same data a
+// user may audit: their own requests, and sessions and workspaces they own.
+func getUsage(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		filter := db.UsageFilter{GroupBy: c.QueryParam("group_by")}
+		if filter.GroupBy == "" {
+			filter.GroupBy = "user"
+		}
+		switch filter.GroupBy {
+		case "user", "api_key", "session", "model", "workspace":
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "group_by must be user, api_key, session, model or workspace")
+		}
+
+		var err error
+		if filter.From, err = queryTime(c, "from"); err != nil {
+			return err
+		}
+		if filter.To, err = queryTime(c, "to"); err != nil {
+			return err
+		}
+		if filter.Visible, err = ownerScope(c, database); err != nil {
+			return err
+		}
+
+		groups, err := database.SumUsage(filter)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		resp := usageResponse{GroupBy: filter.GroupBy, Groups: groups}
+		if !filter.From.IsZero() {
+			resp.From = &filter.From
+		}
+		if !filter.To.IsZero() {
+			resp.To = &filter.To
+		}
+		for _, g := range groups {
+			resp.Total.Requests += g.Requests
+			resp.Total.InputTokens += g.InputTokens
+			resp.Total.OutputTokens += g.OutputTokens
+			resp.Total.Cost += g.Cost
+		}
+
+		return c.JSON(http.StatusOK, resp)
+	}
+}
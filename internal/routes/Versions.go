@@ -0,0 +1,96 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/convert"
+	"composer/internal/db"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+func RegisterVersionRoutes(e *echo.Echo, database *db.Db) {
+	e.GET("/api/chat-sessions/:id/versions", listVersions(database))
+	e.POST("/api/chat-sessions/:id/versions/:version/restore", restoreVersion(database))
+}
+
+func listVersions(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		sessionID := c.Param("id")
+		if _, err := authorizeSession(c, database, sessionID, models.RoleViewer); err != nil {
+			return err
+		}
+
+		versions, err := database.ListArtifactVersions(sessionID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, versions)
+	}
+}
+
+// restoreVersion makes an earlier version the latest one again. History is
+// never rewritten: the restored contents are recorded as a new human turn, so
+// the model sees the restore as the user's edits.
+func restoreVersion(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		sessionID := c.Param("id")
+
+		session, err := authorizeSession(c, database, sessionID, models.RoleEditor)
+		if err != nil {
+			return err
+		}
+
+		versions, err := database.ListArtifactVersions(sessionID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		version, err := selectVersion(versions, c.Param("version"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		if version.Version == len(versions) {
+			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("version %d is already the latest", version.Version))
+		}
+
+		diff := version.Contents
+		previousAIArtifact, err := getPreviousArtifactVersion(database, sessionID, "ai")
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		if previousAIArtifact != "" {
+			dmp := diffmatchpatch.New()
+			diff = dmp.DiffPrettyText(dmp.DiffMain(previousAIArtifact, version.Contents, false))
+		}
+
+		msg := models.ChatMessage{
+			SessionID: sessionID,
+			Role:      "human",
+			Content:   fmt.Sprintf("Restored version %d.", version.Version),
+			Doc:       version.Contents,
+			Format:    string(convert.Stored(version.Format, version.Contents)),
+			Diff:      diff,
+			UserID:    auth.CurrentUser(c).ID,
+			CreatedAt: time.Now(),
+		}
+		if err := database.InsertChatMessage(&msg); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		recordAudit(c, database, session, models.AuditEvent{
+			Action:  auditVersionRestore,
+			Version: len(versions) + 1,
+			Detail:  fmt.Sprintf("restored from version %d", version.Version),
+		})
+
+		return c.JSON(http.StatusCreated, msg)
+	}
+}
@@ -0,0 +1,207 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/db"
+	"composer/internal/models"
+	"composer/internal/webhooks"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+	maxWebhooks          = 20
+)
+
+// RegisterWebhookRoutes lets workspace owners subscribe receivers to the
+// workspace's events.
+func RegisterWebhookRoutes(e *echo.Echo, database *db.Db, dispatcher *webhooks.Dispatcher) {
+	e.POST("/api/workspaces/:workspaceId/webhooks", createWebhook(database, dispatcher))
+	e.GET("/api/workspaces/:workspaceId/webhooks", listWebhooks(database))
+	e.DELETE("/api/workspaces/:workspaceId/webhooks/:webhookId", deleteWebhook(database))
+	e.GET("/api/workspaces/:workspaceId/webhooks/:webhookId/deliveries", listWebhookDeliveries(database))
+	e.POST("/api/workspaces/:workspaceId/webhooks/:webhookId/ping", pingWebhook(database, dispatcher))
+}
+
+type webhookResponse struct {
+	*models.Webhook
+	Secret string `json:"secret"`
+}
+
+// createWebhook returns the signing secret once.
+func createWebhook(database *db.Db, dispatcher *webhooks.Dispatcher) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		ws, err := authorizeWorkspace(c, database, c.Param("workspaceId"), models.RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		var req models.Webhook
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		if err := dispatcher.CheckURL(c.Request().Context(), req.URL); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if len(req.Events) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "events is required")
+		}
+		for _, event := range req.Events {
+			if !slices.Contains(models.WebhookEvents, event) {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown event %q, expected one of %s", event, strings.Join(models.WebhookEvents, ", ")))
+			}
+		}
+
+		existing, err := database.ListWebhooks(ws.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		if len(existing) >= maxWebhooks {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("a workspace can have at most %d webhooks", maxWebhooks))
+		}
+
+		secret, _, err := auth.NewToken()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		webhook := models.Webhook{
+			WorkspaceID: ws.ID,
+			URL:         req.URL,
+			Events:      req.Events,
+			Description: strings.TrimSpace(req.Description),
+			Secret:      secret,
+			CreatedBy:   auth.CurrentUser(c).ID,
+			CreatedAt:   time.Now(),
+		}
+		if err := database.InsertWebhook(&webhook); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusCreated, webhookResponse{Webhook: &webhook, Secret: secret})
+	}
+}
+
+func listWebhooks(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		ws, err := authorizeWorkspace(c, database, c.Param("workspaceId"), models.RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		webhooks, err := database.ListWebhooks(ws.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, webhooks)
+	}
+}
+
+func deleteWebhook(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		ws, err := authorizeWorkspace(c, database, c.Param("workspaceId"), models.RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		err = database.DeleteWebhook(ws.ID, c.Param("webhookId"))
+		if errors.Is(err, db.ErrWebhookNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// listWebhookDeliveries is the delivery log: what was sent, and how the
+// receiver answered the last attempt.
+func listWebhookDeliveries(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		webhook, err := authorizeWebhook(c, database)
+		if err != nil {
+			return err
+		}
+
+		limit := defaultDeliveryLimit
+		if l := c.QueryParam("limit"); l != "" {
+			limit, err = strconv.Atoi(l)
+			if err != nil || limit <= 0 || limit > maxDeliveryLimit {
+				return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxDeliveryLimit))
+			}
+		}
+
+		deliveries, err := database.ListWebhookDeliveries(webhook.ID, limit)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, deliveries)
+	}
+}
+
+// pingWebhook sends a ping event to check that the receiver works; its
+// outcome shows up in the delivery log.
+func pingWebhook(database *db.Db, dispatcher *webhooks.Dispatcher) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		webhook, err := authorizeWebhook(c, database)
+		if err != nil {
+			return err
+		}
+
+		delivery, err := dispatcher.Ping(c.Request().Context(), webhook)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusAccepted, delivery)
+	}
+}
+
+// authorizeWebhook loads the webhook in the :webhookId param, if the user
+// owns its workspace.
+func authorizeWebhook(c echo.Context, database *db.Db) (*models.Webhook, error) {
+	ws, err := authorizeWorkspace(c, database, c.Param("workspaceId"), models.RoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	webhook, err := database.GetWebhook(ws.ID, c.Param("webhookId"))
+	if errors.Is(err, db.ErrWebhookNotFound) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return webhook, nil
+}
+
+// emitEvent queues an event in session's workspace for its webhooks. A
+// failure is logged rather than failing the request the event is about.
+func emitEvent(c echo.Context, session *models.ChatSession, event string, data any) {
+	dispatcher, _ := c.Get("webhooks").(*webhooks.Dispatcher)
+	if dispatcher == nil || session == nil {
+		return
+	}
+	err := dispatcher.Emit(c.Request().Context(), webhooks.Event{
+		Type:        event,
+		WorkspaceID: session.WorkspaceID,
+		SessionID:   session.ID,
+		Data:        data,
+	})
+	if err != nil {
+		logger(c).Error("queueing webhook event", "event", event, "session_id", session.ID, "error", err)
+	}
+}
@@ -0,0 +1,215 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"composer/internal/auth"
+	"composer/internal/db"
+	"composer/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func RegisterWorkspaceRoutes(e *echo.Echo, database *db.Db) {
+	e.POST("/api/workspaces", createWorkspace(database))
+	e.GET("/api/workspaces", listWorkspaces(database))
+	e.GET("/api/workspaces/:workspaceId", getWorkspace(database))
+	e.PUT("/api/workspaces/:workspaceId/members", setWorkspaceMember(database))
+	e.DELETE("/api/workspaces/:workspaceId/members/:userId", removeWorkspaceMember(database))
+}
+
+type workspaceResponse struct {
+	*models.Workspace
+	Members []*models.WorkspaceMember `json:"members"`
+}
+
+type memberRequest struct {
+	Email string      `json:"email"`
+	Role  models.Role `json:"role"`
+}
+
+func createWorkspace(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		if keyWorkspace(c) != "" {
+			return echo.NewHTTPError(http.StatusForbidden, "API keys bound to a workspace can't create workspaces")
+		}
+
+		var req models.Workspace
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+
+		ws := models.Workspace{Name: strings.TrimSpace(req.Name), CreatedAt: time.Now()}
+		if ws.Name == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "name is required")
+		}
+		if err := database.InsertWorkspace(&ws, auth.CurrentUser(c).ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusCreated, ws)
+	}
+}
+
+func listWorkspaces(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		workspaces, err := database.ListWorkspaces(auth.CurrentUser(c).ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		if bound := keyWorkspace(c); bound != "" {
+			var visible []*models.Workspace
+			for _, ws := range workspaces {
+				if ws.ID == bound {
+					visible = append(visible, ws)
+				}
+			}
+			workspaces = visible
+		}
+
+		return c.JSON(http.StatusOK, workspaces)
+	}
+}
+
+func getWorkspace(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		ws, err := authorizeWorkspace(c, database, c.Param("workspaceId"), models.RoleViewer)
+		if err != nil {
+			return err
+		}
+
+		members, err := database.ListWorkspaceMembers(ws.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, workspaceResponse{Workspace: ws, Members: members})
+	}
+}
+
+// setWorkspaceMember adds an existing user to a workspace by email, or
+// changes their role.
+func setWorkspaceMember(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		ws, err := authorizeWorkspace(c, database, c.Param("workspaceId"), models.RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		var req memberRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		if !req.Role.Valid() {
+			return echo.NewHTTPError(http.StatusBadRequest, "role must be one of owner, editor, commenter or viewer")
+		}
+
+		user, err := database.GetUserByEmail(strings.TrimSpace(req.Email))
+		if errors.Is(err, db.ErrUserNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no user with email %q", req.Email))
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		if err := keepAnOwner(database, ws.ID, user.ID, req.Role); err != nil {
+			return err
+		}
+
+		member := models.WorkspaceMember{
+			WorkspaceID: ws.ID,
+			UserID:      user.ID,
+			Email:       user.Email,
+			Name:        user.Name,
+			Role:        req.Role,
+			CreatedAt:   time.Now(),
+		}
+		if err := database.SetWorkspaceMember(&member); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, member)
+	}
+}
+
+// removeWorkspaceMember lets owners remove anyone, and members leave.
+func removeWorkspaceMember(database *db.Db) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := database.WithContext(c.Request().Context())
+		userID := c.Param("userId")
+		min := models.RoleOwner
+		if userID == auth.CurrentUser(c).ID {
+			min = models.RoleViewer
+		}
+
+		ws, err := authorizeWorkspace(c, database, c.Param("workspaceId"), min)
+		if err != nil {
+			return err
+		}
+		if err := keepAnOwner(database, ws.ID, userID, ""); err != nil {
+			return err
+		}
+
+		if err := database.RemoveWorkspaceMember(ws.ID, userID); err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// keepAnOwner refuses a change that would leave a workspace without an owner.
+func keepAnOwner(database *db.Db, workspaceID, userID string, newRole models.Role) error {
+	current, err := database.GetWorkspaceRole(workspaceID, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if current != models.RoleOwner || newRole == models.RoleOwner {
+		return nil
+	}
+
+	owners, err := database.CountWorkspaceOwners(workspaceID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if owners <= 1 {
+		return echo.NewHTTPError(http.StatusConflict, "a workspace must keep at least one owner")
+	}
+	return nil
+}
+
+// authorizeWorkspace loads a workspace and checks the signed-in user's role
+// in it. Non-members get a 404.
+func authorizeWorkspace(c echo.Context, database *db.Db, id string, min models.Role) (*models.Workspace, error) {
+	if bound := keyWorkspace(c); bound != "" && id != bound {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "workspace not found")
+	}
+
+	ws, err := database.GetWorkspace(id)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	role, err := database.GetWorkspaceRole(id, auth.CurrentUser(c).ID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if role == "" {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "workspace not found")
+	}
+	if !role.AtLeast(min) {
+		return nil, echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("this requires the %s role, you are a %s", min, role))
+	}
+
+	ws.Role = role
+	return ws, nil
+}
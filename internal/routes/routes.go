@@ -1,11 +1,19 @@
 package routes
 
 import (
+	"log/slog"
 	"net/http"
 
+	"composer/internal/logging"
+
 	"github.com/labstack/echo/v4"
 )
 
 func Healthz(c echo.Context) error {
 	return c.JSON(http.StatusOK, struct{ Result bool }{Result: true})
 }
+
+// logger is the request's logger, see logging.Middleware.
+func logger(c echo.Context) *slog.Logger {
+	return logging.FromContext(c.Request().Context())
+}
@@ -0,0 +1,140 @@
+package sanitize
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var voidTags = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true,
+	"img": true, "input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+var blockTags = map[string]bool{
+	"article": true, "aside": true, "blockquote": true, "details": true, "div": true, "dl": true,
+	"figure": true, "footer": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "header": true, "hr": true, "main": true, "ol": true, "p": true, "pre": true,
+	"section": true, "table": true, "ul": true,
+}
+
+// Elements whose end tag HTML lets authors omit. Closing them implicitly is
+// not reported as a violation.
+var optionalEnd = map[string]bool{
+	"p": true, "li": true, "dt": true, "dd": true, "tr": true, "td": true, "th": true,
+	"thead": true, "tbody": true, "tfoot": true,
+}
+
+// closedBy reports whether opening next implicitly closes an open element.
+func closedBy(open, next string) bool {
+	switch open {
+	case "p":
+		return blockTags[next]
+	case "li":
+		return next == "li"
+	case "dt", "dd":
+		return next == "dt" || next == "dd"
+	case "td", "th":
+		return next == "td" || next == "th" || next == "tr"
+	case "tr":
+		return next == "tr"
+	case "thead", "tbody":
+		return next == "tbody" || next == "tfoot"
+	}
+	return false
+}
+
+// Balance repairs the tag structure of an artifact: stray end tags are
+// dropped, misnested elements are closed where their parent closes and
+// anything still open at the end is closed. When partial is set the input is
+// a stream that has not finished yet, so elements left open at the end are
+// closed silently and a trailing half-written tag is held back.
+func Balance(input string, partial bool) (string, []Violation) {
+	if partial {
+		input = trimIncompleteTag(input)
+	}
+
+	var out strings.Builder
+	var violations []Violation
+	var stack []string
+	z := html.NewTokenizer(strings.NewReader(input))
+
+	closeTop := func() string {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		out.WriteString("</" + top + ">")
+		return top
+	}
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				violations = append(violations, Violation{Kind: "parse_error", Detail: z.Err().Error()})
+			}
+			break
+		}
+		raw := string(z.Raw())
+		name, _ := z.TagName()
+		tag := string(name)
+
+		switch tt {
+		case html.StartTagToken:
+			for len(stack) > 0 && closedBy(stack[len(stack)-1], tag) {
+				closeTop()
+			}
+			out.WriteString(raw)
+			if !voidTags[tag] {
+				stack = append(stack, tag)
+			}
+		case html.EndTagToken:
+			if voidTags[tag] {
+				continue
+			}
+			i := len(stack) - 1
+			for i >= 0 && stack[i] != tag {
+				i--
+			}
+			if i < 0 {
+				violations = append(violations, Violation{Kind: "unbalanced_tag", Tag: tag, Detail: fmt.Sprintf("dropped stray </%s>", tag)})
+				continue
+			}
+			for len(stack)-1 > i {
+				if top := closeTop(); !optionalEnd[top] {
+					violations = append(violations, Violation{Kind: "unbalanced_tag", Tag: top, Detail: fmt.Sprintf("closed <%s> before </%s>", top, tag)})
+				}
+			}
+			closeTop()
+		default:
+			out.WriteString(raw)
+		}
+	}
+
+	for len(stack) > 0 {
+		if top := closeTop(); !partial && !optionalEnd[top] {
+			violations = append(violations, Violation{Kind: "unbalanced_tag", Tag: top, Detail: fmt.Sprintf("closed unterminated <%s>", top)})
+		}
+	}
+
+	return out.String(), violations
+}
+
+// Artifact sanitizes an HTML artifact and repairs its structure.
+func Artifact(input string, partial bool) (string, []Violation) {
+	if partial {
+		input = trimIncompleteTag(input)
+	}
+	cleaned, violations := HTML(input)
+	balanced, structural := Balance(cleaned, partial)
+	return balanced, append(violations, structural...)
+}
+
+func trimIncompleteTag(s string) string {
+	open := strings.LastIndex(s, "<")
+	if open != -1 && !strings.Contains(s[open:], ">") {
+		return s[:open]
+	}
+	return s
+}
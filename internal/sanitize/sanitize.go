@@ -0,0 +1,189 @@
+package sanitize
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+type Violation struct {
+	Kind   string `json:"kind"`
+	Tag    string `json:"tag,omitempty"`
+	Detail string `json:"detail"`
+}
+
+var allowedTags = map[string]bool{
+	"a": true, "abbr": true, "article": true, "aside": true, "b": true, "blockquote": true,
+	"br": true, "caption": true, "cite": true, "code": true, "col": true, "colgroup": true,
+	"dd": true, "del": true, "details": true, "div": true, "dl": true, "dt": true, "em": true,
+	"figcaption": true, "figure": true, "footer": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "header": true, "hr": true, "i": true, "img": true,
+	"ins": true, "kbd": true, "li": true, "main": true, "mark": true, "ol": true, "p": true,
+	"pre": true, "q": true, "s": true, "section": true, "small": true, "span": true,
+	"strong": true, "sub": true, "summary": true, "sup": true, "table": true, "tbody": true,
+	"td": true, "tfoot": true, "th": true, "thead": true, "tr": true, "u": true, "ul": true,
+}
+
+// Elements whose contents are dropped along with the element itself. This
+// includes every raw text element, since their contents would otherwise be
+// re-parsed as markup once the wrapper is gone.
+var droppedWithContents = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"noscript": true, "template": true, "head": true, "title": true, "frame": true,
+	"frameset": true, "applet": true, "svg": true, "math": true, "textarea": true,
+	"xmp": true, "noembed": true, "noframes": true, "plaintext": true,
+}
+
+var globalAttrs = map[string]bool{"class": true, "id": true, "title": true, "style": true, "dir": true, "lang": true}
+
+var tagAttrs = map[string]map[string]bool{
+	"a":          {"href": true, "target": true, "rel": true, "name": true},
+	"img":        {"src": true, "alt": true, "width": true, "height": true},
+	"td":         {"colspan": true, "rowspan": true, "align": true, "valign": true},
+	"th":         {"colspan": true, "rowspan": true, "align": true, "valign": true, "scope": true},
+	"col":        {"span": true, "width": true},
+	"colgroup":   {"span": true},
+	"ol":         {"start": true, "type": true, "reversed": true},
+	"li":         {"value": true},
+	"table":      {"border": true, "cellpadding": true, "cellspacing": true, "width": true},
+	"blockquote": {"cite": true},
+	"q":          {"cite": true},
+	"del":        {"cite": true, "datetime": true},
+	"ins":        {"cite": true, "datetime": true},
+	"details":    {"open": true},
+}
+
+var urlAttrs = map[string]bool{"href": true, "src": true, "cite": true}
+
+var safeSchemes = map[string]bool{"http": true, "https": true, "mailto": true, "tel": true}
+
+var safeDataImage = regexp.MustCompile(`^data:image/(png|jpe?g|gif|webp);base64,[a-z0-9+/=\s]+$`)
+
+var unsafeStyle = regexp.MustCompile(`(?i)expression\s*\(|javascript:|vbscript:|url\s*\(|@import|behavior\s*:`)
+
+// HTML strips everything outside the allowlist from an artifact: disallowed
+// elements, event handlers, unknown attributes and unsafe URLs. Text is kept
+// byte for byte so that edits the model makes against the stored artifact
+// still match.
+func HTML(input string) (string, []Violation) {
+	var out strings.Builder
+	var violations []Violation
+	z := html.NewTokenizer(strings.NewReader(input))
+	skipping := ""
+	depth := 0
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				violations = append(violations, Violation{Kind: "parse_error", Detail: z.Err().Error()})
+			}
+			break
+		}
+		raw := string(z.Raw())
+		tok := z.Token()
+
+		if skipping != "" {
+			switch {
+			case tt == html.StartTagToken && tok.Data == skipping:
+				depth++
+			case tt == html.EndTagToken && tok.Data == skipping:
+				depth--
+				if depth == 0 {
+					skipping = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			out.WriteString(raw)
+		case html.CommentToken, html.DoctypeToken:
+			continue
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedWithContents[tok.Data] {
+				violations = append(violations, Violation{Kind: "disallowed_tag", Tag: tok.Data, Detail: fmt.Sprintf("removed <%s> and its contents", tok.Data)})
+				if tt == html.StartTagToken {
+					skipping = tok.Data
+					depth = 1
+				}
+				continue
+			}
+			if !allowedTags[tok.Data] {
+				if !isDocumentWrapper(tok.Data) {
+					violations = append(violations, Violation{Kind: "disallowed_tag", Tag: tok.Data, Detail: fmt.Sprintf("removed <%s>", tok.Data)})
+				}
+				continue
+			}
+			out.WriteString("<" + tok.Data)
+			for _, attr := range tok.Attr {
+				ok, v := allowAttr(tok.Data, attr)
+				if v != nil {
+					violations = append(violations, *v)
+				}
+				if ok {
+					fmt.Fprintf(&out, ` %s="%s"`, attr.Key, html.EscapeString(attr.Val))
+				}
+			}
+			if tt == html.SelfClosingTagToken {
+				out.WriteString("/>")
+			} else {
+				out.WriteString(">")
+			}
+		case html.EndTagToken:
+			if allowedTags[tok.Data] {
+				out.WriteString("</" + tok.Data + ">")
+			}
+		}
+	}
+
+	return out.String(), violations
+}
+
+func allowAttr(tag string, attr html.Attribute) (bool, *Violation) {
+	key := strings.ToLower(attr.Key)
+	if attr.Namespace != "" || strings.HasPrefix(key, "on") {
+		return false, &Violation{Kind: "event_handler", Tag: tag, Detail: fmt.Sprintf("removed %s attribute", attr.Key)}
+	}
+	if !globalAttrs[key] && !tagAttrs[tag][key] && !strings.HasPrefix(key, "data-") && !strings.HasPrefix(key, "aria-") {
+		return false, &Violation{Kind: "disallowed_attribute", Tag: tag, Detail: fmt.Sprintf("removed %s attribute", attr.Key)}
+	}
+	if urlAttrs[key] && !safeURL(tag, attr.Val) {
+		return false, &Violation{Kind: "unsafe_url", Tag: tag, Detail: fmt.Sprintf("removed %s=%q", attr.Key, attr.Val)}
+	}
+	if key == "style" && unsafeStyle.MatchString(attr.Val) {
+		return false, &Violation{Kind: "unsafe_style", Tag: tag, Detail: "removed style attribute"}
+	}
+	return true, nil
+}
+
+func safeURL(tag, raw string) bool {
+	// Browsers ignore whitespace and control characters inside a scheme, so
+	// "java\tscript:" has to be caught as well.
+	normalized := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, strings.ToLower(raw))
+
+	i := strings.IndexAny(normalized, ":/?#")
+	if i == -1 || normalized[i] != ':' {
+		return true
+	}
+	scheme := normalized[:i]
+	if scheme == "data" && tag == "img" {
+		return safeDataImage.MatchString(strings.ToLower(strings.TrimSpace(raw)))
+	}
+	return safeSchemes[scheme]
+}
+
+// The model sometimes wraps the artifact in a full document; the wrappers are
+// harmless so they are unwrapped without being reported.
+func isDocumentWrapper(tag string) bool {
+	return tag == "html" || tag == "body"
+}
@@ -0,0 +1,86 @@
+package sanitize
+
+import "testing"
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		kinds []string
+	}{
+		{"allowed markup", `<h1 class="t">Title</h1><p>Some <strong>bold</strong> text</p>`, `<h1 class="t">Title</h1><p>Some <strong>bold</strong> text</p>`, nil},
+		{"text kept byte for byte", `<p>a &amp; b &lt; c</p>`, `<p>a &amp; b &lt; c</p>`, nil},
+		{"script", `<p>a</p><script>alert(1)</script><p>b</p>`, `<p>a</p><p>b</p>`, []string{"disallowed_tag"}},
+		{"nested raw text", `<svg><svg></svg><script>alert(1)</script></svg>ok`, `ok`, []string{"disallowed_tag"}},
+		{"unknown tag keeps its text", `<blink>hi</blink>`, `hi`, []string{"disallowed_tag"}},
+		{"document wrapper", `<html><body><p>hi</p></body></html>`, `<p>hi</p>`, nil},
+		{"comment", `<p>a<!-- <script>x</script> --></p>`, `<p>a</p>`, nil},
+		{"event handler", `<img src="a.png" onerror="alert(1)">`, `<img src="a.png">`, []string{"event_handler"}},
+		{"unknown attribute", `<p align="center" data-x="1" aria-label="l">t</p>`, `<p data-x="1" aria-label="l">t</p>`, []string{"disallowed_attribute"}},
+		{"javascript url", `<a href="javascript:alert(1)">x</a>`, `<a>x</a>`, []string{"unsafe_url"}},
+		{"obfuscated scheme", `<a href="java&#09;script:alert(1)">x</a>`, `<a>x</a>`, []string{"unsafe_url"}},
+		{"relative url", `<a href="/docs?a=1#b">x</a>`, `<a href="/docs?a=1#b">x</a>`, nil},
+		{"mailto", `<a href="mailto:a@example.com">x</a>`, `<a href="mailto:a@example.com">x</a>`, nil},
+		{"data image", `<img src="data:image/png;base64,iVBORw0KGgo=">`, `<img src="data:image/png;base64,iVBORw0KGgo=">`, nil},
+		{"data svg", `<img src="data:image/svg+xml;base64,PHN2Zz4=">`, `<img>`, []string{"unsafe_url"}},
+		{"data link", `<a href="data:text/html,<script>x</script>">x</a>`, `<a>x</a>`, []string{"unsafe_url"}},
+		{"unsafe style", `<p style="background: url(https://evil.example/x)">t</p>`, `<p>t</p>`, []string{"unsafe_style"}},
+		{"safe style", `<p style="color: red">t</p>`, `<p style="color: red">t</p>`, nil},
+		{"attribute escaped", `<p title='a"><script>'>t</p>`, `<p title="a&#34;&gt;&lt;script&gt;">t</p>`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, violations := HTML(tt.input)
+			if got != tt.want {
+				t.Errorf("HTML(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+			if len(violations) != len(tt.kinds) {
+				t.Fatalf("HTML(%q) violations = %v, want kinds %v", tt.input, violations, tt.kinds)
+			}
+			for i, v := range violations {
+				if v.Kind != tt.kinds[i] {
+					t.Errorf("violation %d = %s, want %s", i, v.Kind, tt.kinds[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBalance(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		partial    bool
+		want       string
+		violations int
+	}{
+		{"balanced", `<p>a <em>b</em></p>`, false, `<p>a <em>b</em></p>`, 0},
+		{"stray end tag", `<p>a</div></p>`, false, `<p>a</p>`, 1},
+		{"misnested", `<p><em>a</p>`, false, `<p><em>a</em></p>`, 1},
+		{"unterminated", `<div><p>a`, false, `<div><p>a</p></div>`, 1},
+		{"optional end tags", `<ul><li>a<li>b</ul>`, false, `<ul><li>a</li><li>b</li></ul>`, 0},
+		{"paragraph closed by block", `<p>a<div>b</div>`, false, `<p>a</p><div>b</div>`, 0},
+		{"partial closes silently", `<div><p>a`, true, `<div><p>a</p></div>`, 0},
+		{"partial holds back a half tag", `<p>a</p><str`, true, `<p>a</p>`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, violations := Balance(tt.input, tt.partial)
+			if got != tt.want || len(violations) != tt.violations {
+				t.Errorf("Balance(%q, %v) = %q, %v; want %q with %d violations", tt.input, tt.partial, got, violations, tt.want, tt.violations)
+			}
+		})
+	}
+}
+
+func TestArtifactPartial(t *testing.T) {
+	// A stream cut inside a tag must not let the half-written attribute
+	// through, nor the rest of a dropped element.
+	for _, input := range []string{`<p>a</p><img src="x" onerr`, `<p>a</p><scr`, `<p>a</p><a href="javascript:`} {
+		got, _ := Artifact(input, true)
+		if got != `<p>a</p>` {
+			t.Errorf("Artifact(%q, true) = %q, want %q", input, got, `<p>a</p>`)
+		}
+	}
+}
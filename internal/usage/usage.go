@@ -0,0 +1,104 @@
+// Package usage reads token counts from model responses and estimates what
+// they cost.
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Tokens counts the tokens of one or more model calls.
+type Tokens struct {
+	Input  int
+	Output int
+}
+
+func (t Tokens) Add(o Tokens) Tokens {
+	return Tokens{Input: t.Input + o.Input, Output: t.Output + o.Output}
+}
+
+// FromResponse reads the token counts a provider reports for a generation.
+// Vertex and Google AI report input_tokens and output_tokens, others use
+// InputTokens and OutputTokens; both may be missing.
+func FromResponse(result *llms.ContentResponse) Tokens {
+	if result == nil || len(result.Choices) == 0 {
+		return Tokens{}
+	}
+	info := result.Choices[0].GenerationInfo
+	return Tokens{
+		Input:  infoInt(info, "input_tokens", "InputTokens", "PromptTokens"),
+		Output: infoInt(info, "output_tokens", "OutputTokens", "CompletionTokens"),
+	}
+}
+
+func infoInt(info map[string]any, keys ...string) int {
+	for _, k := range keys {
+		switch v := info[k].(type) {
+		case int:
+			return v
+		case int32:
+			return int(v)
+		case int64:
+			return int(v)
+		case float64:
+			return int(v)
+		}
+	}
+	return 0
+}
+
+// Price is what a model charges, in US dollars per million tokens.
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// Prices maps model names to prices. A model without an entry of its own
+// uses the longest entry its name starts with, so "gemini-2.0-flash" also
+// prices "gemini-2.0-flash-exp".
+type Prices map[string]Price
+
+// DefaultPrices are list prices at the time of writing. They are estimates;
+// set MODEL_PRICES_FILE to use your own.
+var DefaultPrices = Prices{
+	"gemini-2.0-flash":  {Input: 0.10, Output: 0.40},
+	"gemini-1.5-flash":  {Input: 0.075, Output: 0.30},
+	"gemini-1.5-pro":    {Input: 1.25, Output: 5.00},
+	"claude-3-5-sonnet": {Input: 3.00, Output: 15.00},
+	"claude-3-5-haiku":  {Input: 0.80, Output: 4.00},
+}
+
+// LoadPrices reads a JSON price table from path, or returns the defaults if
+// path is empty.
+func LoadPrices(path string) (Prices, error) {
+	if path == "" {
+		return DefaultPrices, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var prices Prices
+	if err := json.Unmarshal(raw, &prices); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return prices, nil
+}
+
+// Cost estimates the cost of tokens on model, or 0 for unknown models.
+func (p Prices) Cost(model string, tokens Tokens) float64 {
+	price, ok := p[model]
+	if !ok {
+		best := ""
+		for name, pr := range p {
+			if strings.HasPrefix(model, name) && len(name) > len(best) {
+				best, price = name, pr
+			}
+		}
+	}
+	return (float64(tokens.Input)*price.Input + float64(tokens.Output)*price.Output) / 1e6
+}
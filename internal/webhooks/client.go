@@ -0,0 +1,106 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned for URLs that reach, or redirect to, the
+// server's own network, so that webhooks can't be used to probe it.
+var ErrForbiddenAddress = errors.New("webhook URLs must not reach loopback, private, link-local or metadata addresses")
+
+const maxRedirects = 5
+
+// sharedAddress is the carrier-grade NAT range, private in all but name.
+var sharedAddress = netip.MustParsePrefix("100.64.0.0/10")
+
+// forbidden reports whether ip is on the server's side of the internet. The
+// cloud metadata services are link-local, 169.254.169.254 and fd00:ec2::254,
+// which is a private IPv6 address.
+func forbidden(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddress.Contains(ip) ||
+		(ip.Is4() && ip.As4()[0] == 0)
+}
+
+// newClient returns the client deliveries are sent with. Unless allowPrivate
+// is set, it refuses to connect to forbidden addresses. The check is made on
+// the address actually dialed, after DNS resolution, so that a name resolving
+// to a different address by the time of the request changes nothing, and it
+// applies to every redirect too.
+func newClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil || forbidden(addr.Addr()) {
+				return ErrForbiddenAddress
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialed instead of the receiver, defeating the check.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if allowPrivate {
+				return checkScheme(req.URL)
+			}
+			return checkURL(req.Context(), req.URL)
+		},
+	}
+}
+
+// CheckURL reports whether raw is a URL deliveries may be sent to: http or
+// https, and, unless the dispatcher allows private addresses, resolving only
+// to public ones. Delivery checks again, as DNS answers can change.
+func (d *Dispatcher) CheckURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.New("url must be an http or https URL")
+	}
+	if d.cfg.AllowPrivate {
+		return checkScheme(u)
+	}
+	return checkURL(ctx, u)
+}
+
+func checkScheme(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http or https URL")
+	}
+	return nil
+}
+
+func checkURL(ctx context.Context, u *url.URL) error {
+	if err := checkScheme(u); err != nil {
+		return err
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("resolving %s: %w", u.Hostname(), err)
+	}
+	for _, ip := range ips {
+		if forbidden(ip) {
+			return ErrForbiddenAddress
+		}
+	}
+	return nil
+}
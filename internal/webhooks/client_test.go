@@ -0,0 +1,69 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestForbidden(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"fd00:ec2::254", true},
+		{"fe80::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"8.8.8.8", false},
+		{"93.184.216.34", false},
+		{"2606:4700::1111", false},
+	}
+	for _, tt := range tests {
+		if got := forbidden(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("forbidden(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	_, err := newClient(time.Second, false).Get(srv.URL)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("Get(%s) = %v, want %v", srv.URL, err, ErrForbiddenAddress)
+	}
+
+	res, err := newClient(time.Second, true).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get(%s) with private addresses allowed: %v", srv.URL, err)
+	}
+	res.Body.Close()
+}
+
+func TestCheckURL(t *testing.T) {
+	d := &Dispatcher{}
+	for _, raw := range []string{"http://127.0.0.1:9090/hook", "http://localhost/hook", "http://[::1]/", "http://169.254.169.254/latest/meta-data", "ftp://example.com/", "not a url"} {
+		if err := d.CheckURL(context.Background(), raw); err == nil {
+			t.Errorf("CheckURL(%q) = nil, want an error", raw)
+		}
+	}
+
+	d.cfg.AllowPrivate = true
+	if err := d.CheckURL(context.Background(), "http://127.0.0.1:9090/hook"); err != nil {
+		t.Errorf("CheckURL with private addresses allowed: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package webhooks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// Computed independently, so that receivers written in other languages
+	// can check their implementation against it too.
+	got := Sign("secret", time.Unix(1700000000, 0), []byte(`{"event":"ping"}`))
+	want := "t=1700000000,v1=4d39bd2442f073b6bc62e95d0297ce25475582a17389ab860abdc778fe1d9f77"
+	if got != want {
+		t.Errorf("Sign() = %q, want %q", got, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	sent := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"ping"}`)
+	header := Sign("secret", sent, body)
+
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		body    string
+		now     time.Time
+		wantErr bool
+	}{
+		{"valid", "secret", header, string(body), sent, false},
+		{"within tolerance", "secret", header, string(body), sent.Add(5 * time.Minute), false},
+		{"clock behind", "secret", header, string(body), sent.Add(-5 * time.Minute), false},
+		{"spaces and extra parts", "secret", "t=1700000000, v0=abc, " + header[len("t=1700000000,"):], string(body), sent, false},
+		{"replayed later", "secret", header, string(body), sent.Add(5*time.Minute + time.Second), true},
+		{"from the future", "secret", header, string(body), sent.Add(-6 * time.Minute), true},
+		{"wrong secret", "other", header, string(body), sent, true},
+		{"tampered body", "secret", header, `{"event":"pong"}`, sent, true},
+		{"timestamp changed", "secret", "t=1700000001," + header[len("t=1700000000,"):], string(body), sent, true},
+		{"no signature", "secret", "t=1700000000", string(body), sent, true},
+		{"no timestamp", "secret", header[len("t=1700000000,"):], string(body), sent, true},
+		{"not hex", "secret", "t=1700000000,v1=zz", string(body), sent, true},
+		{"empty", "secret", "", string(body), sent, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, []byte(tt.body), tt.now, 5*time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify(%q) = %v, want error %v", tt.header, err, tt.wantErr)
+			}
+		})
+	}
+}
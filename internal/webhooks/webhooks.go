@@ -0,0 +1,250 @@
+// Package webhooks tells receivers subscribed to a workspace about what
+// happens in it.
+//
+// Events are written to the database as one delivery per subscribed webhook
+// and sent in the background, so that a slow or failing receiver never holds
+// up the request that caused the event, and deliveries survive restarts.
+// Failed deliveries are retried with exponential backoff. Every delivery is
+// signed with its webhook's secret, see Sign.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"composer/internal/db"
+	"composer/internal/models"
+)
+
+// Config bounds delivery. A delivery is tried up to MaxAttempts times, and
+// each attempt waits Timeout for the receiver to answer. AllowPrivate lets
+// deliveries reach private and loopback addresses, for local development.
+type Config struct {
+	MaxAttempts  int
+	Timeout      time.Duration
+	Workers      int
+	Poll         time.Duration
+	AllowPrivate bool
+}
+
+const (
+	retryBase = 30 * time.Second
+	retryMax  = time.Hour
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent     = "X-Composer-Event"
+	HeaderDelivery  = "X-Composer-Delivery"
+	HeaderSignature = "X-Composer-Signature"
+)
+
+// Event is something that happened in a workspace. Data is sent as is.
+type Event struct {
+	Type        string
+	WorkspaceID string
+	SessionID   string
+	Data        any
+}
+
+// Payload is the body of a delivery.
+type Payload struct {
+	Event       string    `json:"event"`
+	WorkspaceID string    `json:"workspace_id,omitempty"`
+	SessionID   string    `json:"session_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Data        any       `json:"data"`
+}
+
+type Dispatcher struct {
+	db     *db.Db
+	cfg    Config
+	client *http.Client
+	wake   chan struct{}
+}
+
+func New(database *db.Db, cfg Config) *Dispatcher {
+	if cfg.Workers == 0 {
+		cfg.Workers = 2
+	}
+	if cfg.Poll == 0 {
+		cfg.Poll = time.Second
+	}
+	return &Dispatcher{
+		db:     database,
+		cfg:    cfg,
+		client: newClient(cfg.Timeout, cfg.AllowPrivate),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Emit queues e for every webhook in its workspace subscribed to it.
+// Events outside any workspace have no subscribers.
+func (d *Dispatcher) Emit(ctx context.Context, e Event) error {
+	if e.WorkspaceID == "" {
+		return nil
+	}
+	database := d.db.WithContext(ctx)
+	webhooks, err := database.ListWebhooks(e.WorkspaceID)
+	if err != nil {
+		return err
+	}
+
+	var payload []byte
+	for _, w := range webhooks {
+		if !w.Subscribed(e.Type) {
+			continue
+		}
+		if payload == nil {
+			payload, err = json.Marshal(Payload{
+				Event:       e.Type,
+				WorkspaceID: e.WorkspaceID,
+				SessionID:   e.SessionID,
+				CreatedAt:   time.Now().UTC(),
+				Data:        e.Data,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := d.queue(ctx, w, e.Type, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping queues a ping to w, whatever it subscribes to, to check that its
+// receiver works.
+func (d *Dispatcher) Ping(ctx context.Context, w *models.Webhook) (*models.WebhookDelivery, error) {
+	return d.Send(ctx, w, models.WebhookPing, w)
+}
+
+// Send queues event to w alone, whatever it subscribes to, with data.
+func (d *Dispatcher) Send(ctx context.Context, w *models.Webhook, event string, data any) (*models.WebhookDelivery, error) {
+	payload, err := json.Marshal(Payload{
+		Event:       event,
+		WorkspaceID: w.WorkspaceID,
+		CreatedAt:   time.Now().UTC(),
+		Data:        data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.queue(ctx, w, event, payload)
+}
+
+func (d *Dispatcher) queue(ctx context.Context, w *models.Webhook, event string, payload []byte) (*models.WebhookDelivery, error) {
+	now := time.Now()
+	dl := &models.WebhookDelivery{
+		WebhookID:     w.ID,
+		Event:         event,
+		Payload:       payload,
+		Status:        models.DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	if err := d.db.WithContext(ctx).InsertWebhookDelivery(dl); err != nil {
+		return nil, err
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return dl, nil
+}
+
+// Start sends deliveries until ctx is done.
+func (d *Dispatcher) Start(ctx context.Context) {
+	for i := 0; i < d.cfg.Workers; i++ {
+		go d.work(ctx)
+	}
+}
+
+func (d *Dispatcher) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now()
+		// The lease outlasts an attempt, so only a sender that stopped
+		// lets another one retry.
+		dl, w, err := d.db.ClaimWebhookDelivery(now, now.Add(2*d.cfg.Timeout))
+		if err != nil {
+			slog.Error("claiming a webhook delivery", "error", err)
+		}
+		if dl != nil {
+			d.send(ctx, dl, w)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-d.wake:
+		case <-time.After(d.cfg.Poll):
+		}
+	}
+}
+
+// send makes one attempt at dl, and records the outcome.
+func (d *Dispatcher) send(ctx context.Context, dl *models.WebhookDelivery, w *models.Webhook) {
+	logger := slog.With("webhook_id", w.ID, "delivery_id", dl.ID, "event", dl.Event, "attempt", dl.Attempts)
+	start := time.Now()
+
+	status, err := d.post(ctx, dl, w)
+	if ctx.Err() != nil {
+		// Shutting down. Once the lease runs out the delivery is tried again.
+		return
+	}
+	now := time.Now()
+	dl.ResponseStatus, dl.DurationMs = status, now.Sub(start).Milliseconds()
+	switch {
+	case err == nil:
+		dl.Status, dl.Error, dl.DeliveredAt = models.DeliveryDelivered, "", &now
+	case dl.Attempts >= d.cfg.MaxAttempts:
+		dl.Status, dl.Error = models.DeliveryFailed, err.Error()
+	default:
+		backoff := retryBase << (dl.Attempts - 1)
+		if backoff > retryMax || backoff <= 0 {
+			backoff = retryMax
+		}
+		dl.Error, dl.NextAttemptAt = err.Error(), now.Add(backoff)
+	}
+
+	if err := d.db.FinishWebhookDelivery(dl); err != nil {
+		logger.Error("recording webhook delivery", "error", err)
+		return
+	}
+	if err != nil {
+		logger.Warn("webhook delivery failed", "status", dl.Status, "response_status", status, "error", err)
+		return
+	}
+	logger.Info("delivered webhook", "response_status", status, "duration_ms", dl.DurationMs)
+}
+
+func (d *Dispatcher) post(ctx context.Context, dl *models.WebhookDelivery, w *models.Webhook) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "composer-webhooks")
+	req.Header.Set(HeaderEvent, dl.Event)
+	req.Header.Set(HeaderDelivery, dl.ID)
+	req.Header.Set(HeaderSignature, Sign(w.Secret, time.Now(), dl.Payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("receiver answered %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
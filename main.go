@@ -1,67 +1,187 @@
 package main
 
 import (
-	"composer/internal/db"
-	"composer/internal/routes"
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
 
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
-	"github.com/tmc/langchaingo/llms/googleai"
-	"github.com/tmc/langchaingo/llms/googleai/vertex"
+	"composer/internal/config"
+	"composer/internal/logging"
+	"composer/internal/metrics"
 )
 
+const usageText = `usage: composer <command> [flags]
+
+Commands:
+  serve                     run the web server (the default)
+  migrate                   create or upgrade the database tables
+  export-session <id>       export a session's artifact as docx, pdf, md or html
+  import <file>             import a document into a new or existing session
+  generate --prompt <text>  generate a document without the UI
+  users create              create a local account
+  users claim-sessions      give sessions from before accounts to an account
+  webhooks listen           receive and check webhook deliveries locally
+  config print              print the effective configuration
+
+Every command accepts --config and the configuration flags; run
+"composer <command> -h" to list them.
+`
+
+// commands run with the arguments after their name. Those that take
+// configuration flags parse them along with their own.
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"serve":          serveCommand,
+	"migrate":        migrateCommand,
+	"export-session": exportSessionCommand,
+	"import":         importCommand,
+	"generate":       generateCommand,
+	"users":          usersCommand,
+	"webhooks":       webhooksCommand,
+	"config":         configCommand,
+}
+
 func main() {
-	e := echo.New()
+	// Without a command, or with only flags, composer serves, as it did
+	// before it had commands.
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-	e.Use(middleware.Logger())
-	e.Use(middleware.Recover())
+	if name == "help" {
+		fmt.Print(usageText)
+		return
+	}
 
-	dbType := os.Getenv("DB_TYPE")
-	dbConnectionString := os.Getenv("DB_CONNECTION_STRING")
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "composer: unknown command %q\n\n%s", name, usageText)
+		os.Exit(2)
+	}
 
-	conn, err := db.New(dbType, dbConnectionString)
-	if err != nil {
-		e.Logger.Fatal(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := command(ctx, args); err != nil {
+		var usage usageError
+		if errors.As(err, &usage) {
+			fmt.Fprintf(os.Stderr, "composer %s: %s\n", name, usage.msg)
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "composer %s: %s\n", name, err)
+		os.Exit(1)
 	}
+}
+
+// usageError is a mistake in the command line, which exits with status 2.
+type usageError struct{ msg string }
 
-	// llm, err := anthropic.New(
-	// 	anthropic.WithModel("claude-3-5-sonnet-latest"),
-	// )
-	// if err != nil {
-	// 	e.Logger.Fatal(err)
-	// }
+func (e usageError) Error() string { return e.msg }
 
-	ctx := context.Background()
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
 
-	project := "kodespaces"
+// parseFlags parses args with fs, allowing flags after positional arguments
+// as in "composer export-session 42 --format pdf", and loads the
+// configuration. It returns the positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string) (*config.Config, []string, error) {
+	flags := config.BindFlags(fs)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
 
-	llm, err := vertex.New(ctx,
-		googleai.WithCloudProject(project),
-		googleai.WithDefaultModel("gemini-2.0-flash-exp"),
-		googleai.WithDefaultMaxTokens(8192),
-	)
+	cfg, err := flags.Load()
 	if err != nil {
-		e.Logger.Fatal(err)
+		return nil, nil, usagef("invalid configuration:\n%s", err)
 	}
+	if err := logging.Setup(os.Stderr, cfg.LoggingConfig()); err != nil {
+		return nil, nil, err
+	}
+	return cfg, positional, nil
+}
+
+// serveCommand runs the web server until interrupted.
+func serveCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("composer serve", flag.ExitOnError)
+	cfg, _, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	srv, err := newServer(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer srv.Close(context.WithoutCancel(ctx))
+	// The server is no use without the model, so find out now.
+	if _, err := srv.llm.get(); err != nil {
+		return err
+	}
+
+	srv.jobs.Start(ctx)
+	srv.webhooks.Start(ctx)
 
-	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			c.Set("db", conn)
-			c.Set("llm", llm)
-			return next(c)
+	if cfg.Server.MetricsAddr != "" {
+		ln, err := net.Listen("tcp", cfg.Server.MetricsAddr)
+		if err != nil {
+			return err
 		}
-	})
+		metricsSrv := metrics.Server(cfg.Server.MetricsAddr)
+		go func() {
+			<-ctx.Done()
+			metricsSrv.Shutdown(context.WithoutCancel(ctx))
+		}()
+		go func() {
+			if err := metricsSrv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("serving metrics", "error", err)
+			}
+		}()
+		slog.Info("serving metrics", "addr", cfg.Server.MetricsAddr)
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.WithoutCancel(ctx))
+	}()
 
-	e.GET("/api/v1/healthz", routes.Healthz)
-	routes.RegisterMessageRoutes(e)
-	routes.RegisterChatSessionRoutes(e, conn)
+	slog.Info("listening", "addr", cfg.Server.Addr)
+	if err := srv.Start(cfg.Server.Addr); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
 
-	e.Static("/", "ui/dist")
+// configCommand runs "composer config print", which shows the configuration
+// the server would run with.
+func configCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return usagef("usage: composer config print [-format yaml|toml] [flags]")
+	}
 
-	err = e.Start(":9081")
+	fs := flag.NewFlagSet("composer config print", flag.ExitOnError)
+	format := fs.String("format", "yaml", "yaml or toml")
+	cfg, _, err := parseFlags(fs, args[1:])
 	if err != nil {
-		e.Logger.Fatal(err)
+		return err
+	}
+	if err := config.Print(os.Stdout, cfg, *format); err != nil {
+		return usagef("%s", err)
 	}
+	return nil
 }
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+
+	"composer/internal/auth"
+	"composer/internal/blobstore"
+	"composer/internal/config"
+	"composer/internal/db"
+	"composer/internal/export"
+	"composer/internal/guardrails"
+	"composer/internal/jobs"
+	"composer/internal/knowledge"
+	"composer/internal/logging"
+	"composer/internal/metrics"
+	"composer/internal/ratelimit"
+	"composer/internal/routes"
+	"composer/internal/tracing"
+	"composer/internal/usage"
+	"composer/internal/webhooks"
+	"composer/ui"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/googleai"
+	"github.com/tmc/langchaingo/llms/googleai/vertex"
+)
+
+// defaultLogo is the UI's logo, from ui/public, used on exports unless
+// branding.logo names another.
+const defaultLogo = "citi.png"
+
+// server is the configured application. "composer serve" listens with it;
+// the other commands call it in-process.
+type server struct {
+	*echo.Echo
+	db       *db.Db
+	jobs     *jobs.Queue
+	webhooks *webhooks.Dispatcher
+	llm      *lazyLLM
+
+	shutdownTracing func(context.Context) error
+}
+
+func newServer(ctx context.Context, cfg *config.Config) (*server, error) {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+
+	// Client addresses are audited, so forwarding headers are only believed
+	// from the proxies configured.
+	proxies, err := cfg.TrustedProxies()
+	if err != nil {
+		return nil, err
+	}
+	e.IPExtractor = echo.ExtractIPDirect()
+	if len(proxies) > 0 {
+		trust := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+		for _, p := range proxies {
+			trust = append(trust, echo.TrustIPRange(p))
+		}
+		e.IPExtractor = echo.ExtractIPFromXFFHeader(trust...)
+	}
+
+	e.Use(tracing.Middleware())
+	e.Use(logging.Middleware())
+	e.Use(metrics.Middleware())
+	e.Use(middleware.Recover())
+
+	conn, err := db.New(cfg.Database.Type, cfg.Database.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	// llm, err := anthropic.New(
+	// 	anthropic.WithModel("claude-3-5-sonnet-latest"),
+	// )
+	// if err != nil {
+	// 	e.Logger.Fatal(err)
+	// }
+
+	shutdownTracing, err := tracing.Setup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	model := cfg.LLM.Model
+	llm := &lazyLLM{ctx: ctx, cfg: cfg.LLM}
+
+	var embedder embeddings.Embedder = knowledge.NewHashEmbedder()
+	if cfg.Knowledge.Embedder == "vertex" {
+		embedder, err = embeddings.NewEmbedder(llm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	opener := knowledge.SQLOpener(conn, embedder)
+	if cfg.Database.Type == "postgres" {
+		opener = knowledge.PgvectorOpener(cfg.Database.DSN, embedder)
+	}
+	kb := knowledge.New(opener)
+
+	guard, err := guardrails.Load(cfg.LLM.GuardrailsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	prices, err := usage.LoadPrices(cfg.LLM.PricesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	dispatcher := webhooks.New(conn, cfg.WebhooksConfig())
+
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("db", conn.WithContext(c.Request().Context()))
+			c.Set("llm", llm)
+			c.Set("model", model)
+			c.Set("knowledge", kb)
+			c.Set("guardrails", guard)
+			c.Set("prices", prices)
+			c.Set("webhooks", dispatcher)
+			return next(c)
+		}
+	})
+
+	e.Use(auth.Middleware(conn))
+
+	var provider *auth.OIDC
+	if cfg.OIDC.Issuer != "" {
+		provider, err = auth.NewOIDC(ctx, cfg.OIDC.Issuer, cfg.OIDC.ClientID, cfg.OIDC.ClientSecret, cfg.OIDC.RedirectURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	e.GET("/api/v1/healthz", routes.Healthz)
+	routes.RegisterAuthRoutes(e, conn, provider, cfg.Registration())
+	limits, err := cfg.RateLimits()
+	if err != nil {
+		return nil, err
+	}
+	var counters ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.Limits.Store == "sql" {
+		counters = ratelimit.NewSQLStore(conn)
+	}
+	limiter := ratelimit.New(counters, limits)
+	blobs, err := blobstore.NewFileStore(cfg.Server.BlobDir)
+	if err != nil {
+		return nil, err
+	}
+	routes.RegisterMessageRoutes(e, limiter)
+	routes.RegisterGenerateRoutes(e, limiter, cfg.LLM.TemplatesDir)
+	routes.RegisterChatSessionRoutes(e, conn, blobs)
+	routes.RegisterWorkspaceRoutes(e, conn)
+	routes.RegisterRedactionRoutes(e, conn)
+	routes.RegisterWebhookRoutes(e, conn, dispatcher)
+	routes.RegisterCommentRoutes(e, conn)
+	routes.RegisterAPIKeyRoutes(e, conn)
+	routes.RegisterConvertRoutes(e, conn)
+	routes.RegisterImportRoutes(e, conn)
+	routes.RegisterVersionRoutes(e, conn)
+	routes.RegisterAuditRoutes(e, conn)
+	routes.RegisterUsageRoutes(e, conn)
+
+	routes.RegisterAttachmentRoutes(e, conn, blobs)
+	queue := jobs.New(conn, blobs, e, dispatcher, cfg.JobsConfig())
+	routes.RegisterJobRoutes(e, conn, queue, dispatcher)
+	routes.RegisterKnowledgeRoutes(e, conn, kb, cfg.Knowledge.Dir, cfg.KnowledgeEditors())
+
+	// The UI is the one built into the binary unless a directory overrides
+	// it, to try a UI build without rebuilding the server.
+	assets := ui.Dist()
+	if cfg.Server.StaticDir != "" {
+		assets = os.DirFS(cfg.Server.StaticDir)
+	}
+
+	brand := export.Branding{Name: cfg.Branding.Name}
+	if cfg.Branding.Logo != "" {
+		brand.Logo, _ = os.ReadFile(cfg.Branding.Logo)
+	} else {
+		brand.Logo, _ = fs.ReadFile(assets, defaultLogo)
+	}
+	routes.RegisterExportRoutes(e, conn, brand)
+	routes.RegisterShareRoutes(e, conn, brand)
+
+	routes.RegisterUIRoutes(e, assets)
+
+	return &server{Echo: e, db: conn, jobs: queue, webhooks: dispatcher, llm: llm, shutdownTracing: shutdownTracing}, nil
+}
+
+// Close flushes traces and closes the database.
+func (s *server) Close(ctx context.Context) {
+	if err := s.shutdownTracing(ctx); err != nil {
+		slog.Error("flushing traces", "error", err)
+	}
+	s.db.Close()
+}
+
+// languageModel is what Composer needs of a provider: generation, and
+// embeddings for the knowledge base.
+type languageModel interface {
+	llms.Model
+	embeddings.EmbedderClient
+}
+
+// lazyLLM creates the provider's client when it is first used, so that
+// commands that never generate, such as export-session, run without the
+// provider's credentials. A failure to create it is returned by that call and
+// tried again by the next.
+type lazyLLM struct {
+	ctx context.Context
+	cfg config.LLM
+
+	mu  sync.Mutex
+	llm languageModel
+}
+
+func (l *lazyLLM) get() (languageModel, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.llm == nil {
+		llm, err := newLLM(l.ctx, l.cfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating the %s client: %w", l.cfg.Provider, err)
+		}
+		l.llm = llm
+	}
+	return l.llm, nil
+}
+
+// Provider is the provider's name for metrics, known before the client is.
+func (l *lazyLLM) Provider() string {
+	return l.cfg.Provider
+}
+
+func (l *lazyLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	llm, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return llm.GenerateContent(ctx, messages, options...)
+}
+
+func (l *lazyLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, l, prompt, options...)
+}
+
+func (l *lazyLLM) CreateEmbedding(ctx context.Context, texts []string) ([][]float32, error) {
+	llm, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return llm.CreateEmbedding(ctx, texts)
+}
+
+func newLLM(ctx context.Context, cfg config.LLM) (languageModel, error) {
+	if cfg.Provider == "googleai" {
+		return googleai.New(ctx,
+			googleai.WithAPIKey(cfg.APIKey),
+			googleai.WithDefaultModel(cfg.Model),
+			googleai.WithDefaultMaxTokens(8192),
+		)
+	}
+	return vertex.New(ctx,
+		googleai.WithCloudProject(cfg.Project),
+		googleai.WithCloudLocation(cfg.Location),
+		googleai.WithDefaultModel(cfg.Model),
+		googleai.WithDefaultMaxTokens(8192),
+	)
+}
@@ -0,0 +1,24 @@
+// Package ui embeds the built web UI, so that the composer binary serves it
+// wherever it runs. Build the UI with "npm run build" before "go build" to
+// include it; a binary built without it serves the API only.
+package ui
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// dist is committed with only .gitkeep, so that the package builds before
+// the UI has been.
+//
+//go:embed all:dist
+var dist embed.FS
+
+// Dist returns the built UI, with index.html at its root.
+func Dist() fs.FS {
+	sub, err := fs.Sub(dist, "dist")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}